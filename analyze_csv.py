@@ -2,6 +2,7 @@
 import csv
 import sys
 import glob
+import os
 from statistics import mean, median
 
 def read_csv(path):
@@ -59,6 +60,31 @@ def analyze(filepath):
     }
     return result
 
+def load_series(name):
+    """client-go 以 REPORT_FILE=load_<name> 运行时写出的逐秒序列，<name> 可以省略 _stat 后缀"""
+    for n in (name, name[:-len("_stat")] if name.endswith("_stat") else None):
+        if n and os.path.exists(f"load_{n}_series.csv"):
+            return read_csv(f"load_{n}_series.csv")
+    return None
+
+def join_load(rows, series):
+    """按 unix 秒把监控数据和负载序列对齐，只统计两边都有数据的秒"""
+    by_ts = {int(r["timestamp"]): r for r in series}
+    joined = [(r, by_ts[int(r["timestamp"])]) for r in rows if int(r["timestamp"]) in by_ts]
+    if not joined:
+        return None
+    rps = [l["rps"] for _, l in joined]
+    cpu = [m["cpu%"] for m, _ in joined]
+    return {
+        "seconds": len(joined),
+        "rps_avg": mean(rps),
+        "p99_avg": mean(l["p99_ms"] for _, l in joined),
+        "p99_max": max(l["p99_ms"] for _, l in joined),
+        "err_avg": mean(l["error_rate"] for _, l in joined) * 100,
+        # 每千次请求每秒占用的 CPU
+        "cpu_per_krps": mean(cpu) / (mean(rps) / 1000) if mean(rps) > 0 else 0,
+    }
+
 def summarize(name, stat):
     return f"""
 {name}:
@@ -68,6 +94,13 @@ def summarize(name, stat):
   网络：收 {stat['recv_avg']:.1f} KB/s，发 {stat['send_avg']:.1f} KB/s
   上下文切换：平均 {stat['ctx_avg']:.1f} 次/s，峰值 {stat['ctx_max']:.1f} 次/s
   线程数：平均 {stat['threads_avg']:.1f}，最小 {stat['threads_min']:.0f}，最大 {stat['threads_max']:.0f}
+""" + summarize_load(stat.get("load"))
+
+def summarize_load(load):
+    if not load:
+        return ""
+    return f"""  负载（{load['seconds']} 秒对齐）：平均 {load['rps_avg']:.1f} req/s，P99 平均 {load['p99_avg']:.2f} ms、最高 {load['p99_max']:.2f} ms，失败率 {load['err_avg']:.2f}%
+  效率：每 1000 req/s 占用 CPU {load['cpu_per_krps']:.1f}%
 """
 
 def main():
@@ -80,6 +113,9 @@ def main():
     for f in files:
         name = f.replace("monitor_", "").replace(".csv", "")
         stats = analyze(f)
+        series = load_series(name)
+        if series:
+            stats["load"] = join_load(read_csv(f), series)
         all_stats[name] = stats
 
     # 打印简洁对比报告
@@ -99,6 +135,12 @@ def main():
     print(f"CPU 利用率最低：{best_cpu[0]}（平均 {best_cpu[1]['cpu_avg']:.1f}%）")
     print(f"内存占用最低：{best_mem[0]}（平均 {best_mem[1]['mem_avg']:.1f} MB）")
     print(f"锁竞争最少（ctx 切换最低）：{best_ctx[0]}（平均 {best_ctx[1]['ctx_avg']:.1f} 次/s）")
+    with_load = [x for x in all_stats.items() if x[1].get("load")]
+    if with_load:
+        best_p99 = min(with_load, key=lambda x: x[1]["load"]["p99_avg"])
+        best_eff = min(with_load, key=lambda x: x[1]["load"]["cpu_per_krps"])
+        print(f"延迟最低：{best_p99[0]}（P99 平均 {best_p99[1]['load']['p99_avg']:.2f} ms）")
+        print(f"单位吞吐 CPU 最低：{best_eff[0]}（每 1000 req/s {best_eff[1]['load']['cpu_per_krps']:.1f}%）")
 
     print("\n分析完毕。")
 
@@ -13,7 +13,9 @@ IMAGE_NAME=""
 PORT="3010"
 DURATION="60"
 INTERVAL="1"
+READY_TIMEOUT="60"
 ENV_VARS=()
+VOLUMES=()
 
 usage() {
     echo "Usage: $0 [options]"
@@ -22,7 +24,9 @@ usage() {
     echo "  -i, --image IMAGE       镜像名称 (required)"
     echo "  -p, --port PORT         端口映射 (default: 3010)"
     echo "  -d, --duration SECONDS  监控持续时间 (default: 60)"
+    echo "  -w, --wait SECONDS      等待容器就绪的最长时间 (default: 60)"
     echo "  -e, --env KEY=VALUE     环境变量 (可多次使用)"
+    echo "  -v, --volume SRC:DST    挂载目录 (可多次使用)"
     echo "  -h, --help              显示帮助"
     exit 1
 }
@@ -34,7 +38,9 @@ while [[ $# -gt 0 ]]; do
         -i|--image) IMAGE_NAME="$2"; shift 2 ;;
         -p|--port) PORT="$2"; shift 2 ;;
         -d|--duration) DURATION="$2"; shift 2 ;;
+        -w|--wait) READY_TIMEOUT="$2"; shift 2 ;;
         -e|--env) ENV_VARS+=("-e" "$2"); shift 2 ;;
+        -v|--volume) VOLUMES+=("-v" "$2"); shift 2 ;;
         -h|--help) usage ;;
         *) echo "Unknown option: $1"; usage ;;
     esac
@@ -162,6 +168,42 @@ start_monitor() {
     echo "监控完成，共 ${elapsed}s"
 }
 
+# =============================================
+# 等待容器就绪：镜像带 HEALTHCHECK 时等到 healthy，否则退回固定等待
+# =============================================
+wait_ready() {
+    local CID=$1
+
+    if [ -z "$(docker inspect --format '{{if .State.Health}}yes{{end}}' $CID)" ]; then
+        echo "镜像没有 HEALTHCHECK，等待 2s"
+        sleep 2
+        return 0
+    fi
+
+    local waited=0
+    while [ $waited -lt $READY_TIMEOUT ]; do
+        STATUS=$(docker inspect --format '{{.State.Status}}/{{.State.Health.Status}}' $CID 2>/dev/null || true)
+        case "$STATUS" in
+            running/healthy)
+                echo "容器已就绪，用时 ${waited}s"
+                return 0
+                ;;
+            running/*) ;;
+            *)
+                echo "Error: 容器未就绪 ($STATUS)"
+                docker logs -n 50 $CID
+                return 1
+                ;;
+        esac
+        sleep 1
+        waited=$((waited + 1))
+    done
+
+    echo "Error: ${READY_TIMEOUT}s 内容器未就绪"
+    docker logs -n 50 $CID
+    return 1
+}
+
 cleanup() {
     echo "清理中..."
     docker stop "$NAME" 2>/dev/null || true
@@ -202,8 +244,8 @@ trap cleanup EXIT
 
 # 1. 启动容器
 echo "[1/4] 启动容器..."
-docker run -d --name "${NAME}" -p "${PORT}:${PORT}" "${ENV_VARS[@]}" "${IMAGE_NAME}"
-sleep 2
+docker run -d --name "${NAME}" -p "${PORT}:${PORT}" "${ENV_VARS[@]}" "${VOLUMES[@]}" "${IMAGE_NAME}"
+wait_ready "${NAME}"
 
 # 2-3. 启动监控并等待
 echo "[2/4] 启动监控..."
@@ -34,6 +34,14 @@ build_image() {
     fi
 }
 
+# server-go 和 client-go 各自作为构建上下文，共用的包只能复制，构建前确认两份一致
+for pkg in codec timewheel logging tracing; do
+    if ! diff -r "server-go/${pkg}" "client-go/${pkg}" > /dev/null; then
+        echo -e "${YELLOW}server-go/${pkg} 和 client-go/${pkg} 不一致，先同步再构建${NC}"
+        exit 1
+    fi
+done
+
 # 构建所有组件
 build_image "server-java" "./server-java/Dockerfile"
 build_image "server-cpp" "./server-cpp/Dockerfile"
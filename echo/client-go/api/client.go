@@ -0,0 +1,58 @@
+// Package api is the typed client of the server routes. routes_gen.go is
+// generated from the server-go route export by its cmd/routegen; run
+// go generate in server-go after changing routes.
+package api
+
+import (
+	"encoding/json"
+
+	"client-go/client"
+)
+
+// Client adds one typed method per route to a PinusTcpClient.
+type Client struct {
+	*client.PinusTcpClient
+}
+
+func New(c *client.PinusTcpClient) *Client {
+	return &Client{c}
+}
+
+// request sends req as a map, the body type the client encodes and traces,
+// and decodes the response map into resp.
+func (c *Client) request(route string, req, resp interface{}) error {
+	body, err := toMap(req)
+	if err != nil {
+		return err
+	}
+	res, err := c.Request(route, body)
+	if err != nil {
+		return err
+	}
+	return convert(res, resp)
+}
+
+func (c *Client) notify(route string, req interface{}) error {
+	body, err := toMap(req)
+	if err != nil {
+		return err
+	}
+	return c.Notify(route, body)
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m, nil
+	}
+	var m map[string]interface{}
+	err := convert(v, &m)
+	return m, err
+}
+
+func convert(v, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,31 @@
+// Code generated by routegen from routes.json. DO NOT EDIT.
+
+package api
+
+// Routes.
+const (
+	RouteHello = "connector.entryHandler.hello"
+)
+
+type HelloRequest struct {
+	Data string `json:"data"`
+}
+
+type HelloResponseMsg struct {
+	Data        string `json:"data,omitempty"`
+	ServerReqId uint32 `json:"serverReqId,omitempty"`
+}
+
+type HelloResponse struct {
+	Code int              `json:"code"`
+	Msg  HelloResponseMsg `json:"msg"`
+}
+
+// Hello sends a connector.entryHandler.hello request.
+func (c *Client) Hello(req *HelloRequest) (*HelloResponse, error) {
+	resp := new(HelloResponse)
+	if err := c.request(RouteHello, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -1,15 +1,23 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math/rand"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"client-go/codec"
+	"client-go/logging"
 	"client-go/protocol"
+	"client-go/timewheel"
+	"client-go/tracing"
 )
 
 const (
@@ -33,13 +41,42 @@ const (
 
 const gapThreshold = 100 // heartbeat gap threshold (ms)
 
+const (
+	handshakeTimeout = 10 * time.Second
+	requestTimeout   = 30 * time.Second
+)
+
+const (
+	defaultReconnectBaseDelay = 500 * time.Millisecond
+	defaultReconnectMaxDelay  = 30 * time.Second
+)
+
+var (
+	ErrNotConnected   = errors.New("not connected")
+	ErrConnectionLost = errors.New("connection lost")
+	ErrRequestTimeout = errors.New("request timeout")
+)
+
+var (
+	logger = logging.For("client")
+	// 一个进程里有上千个客户端，逐条的错误日志按秒采样
+	sampledLogger = logging.Sampled(logger, 10, 100)
+)
+
+// KickReasonDuplicateLogin is sent by the server when the same uid logs in
+// on another connection.
+const KickReasonDuplicateLogin = "duplicate_login"
+
 type HandshakeData struct {
 	Sys struct {
-		Type    string                 `json:"type"`
-		Version string                 `json:"version"`
-		RSA     map[string]interface{} `json:"rsa"`
-		Dict    map[string]uint16      `json:"dict"`
-		Protos  map[string]interface{} `json:"protos"`
+		Type        string                 `json:"type"`
+		Version     string                 `json:"version"`
+		RSA         map[string]interface{} `json:"rsa"`
+		Dict        map[string]uint16      `json:"dict"`
+		Protos      map[string]interface{} `json:"protos"`
+		ResumeToken string                 `json:"resumeToken,omitempty"`
+		Codecs      []string               `json:"codecs,omitempty"`
+		Heartbeat   int                    `json:"heartbeat,omitempty"`
 	} `json:"sys"`
 	User map[string]interface{} `json:"user"`
 }
@@ -55,38 +92,94 @@ type PinusTcpClient struct {
 	port     int
 	userId   string
 	conn     net.Conn
-	netState int
-
-	// Read state
-	readState     int
-	headBuffer    []byte
-	headOffset    int
-	packageBuffer []byte
-	packageOffset int
-	packageSize   int
+	connMu   sync.Mutex
+	netState atomic.Int32 // 读 goroutine、重连和 Disconnect 都会改变
 
-	// Heartbeat
+	// Heartbeat。定时器在时间轮的 goroutine 上触发，状态由 heartbeatMu 保护；
+	// heartbeatConn 为空表示心跳已停止，迟到的回调什么也不做
+	heartbeatHint         time.Duration
+	heartbeatPolicy       string
 	heartbeatInterval     time.Duration
 	heartbeatTimeout      time.Duration
-	heartbeatTimer        *time.Timer
-	heartbeatTimeoutTimer *time.Timer
+	heartbeatMu           sync.Mutex
+	heartbeatConn         net.Conn
+	heartbeatTimer        *timewheel.Timer
+	heartbeatTimeoutTimer *timewheel.Timer
 	nextHeartbeatTimeout  time.Time
 
-	// Request/Response
-	reqId         uint32
-	callbacks     map[uint32]func(interface{})
-	callbackMutex sync.Mutex
+	// Request/Response。请求可以来自多个 goroutine：编号原子递增，
+	// 先登记再写出，写操作串行，连接断开时所有未完成的请求一起失败
+	reqId     uint32
+	pending   map[uint32]*Call
+	pendingMu sync.Mutex
+	writeMu   sync.Mutex
 
 	// Protocol
-	dict     map[string]uint16
-	abbrs    map[uint16]string
-	protos   map[string]interface{}
-	protobuf *protocol.Protobuf
+	preferredCodec string
+	wire           atomic.Pointer[wireState]
 
 	// Events
-	handshakeChan chan *HandshakeResponse
-	messageChan   chan *protocol.Message
-	errorChan     chan error
+	pushSubs pushSubs
+
+	// Reconnect
+	resumeToken          string
+	resumed              bool
+	closing              bool
+	reconnect            bool
+	reconnectMaxAttempts int
+	reconnectBaseDelay   time.Duration
+	reconnectMaxDelay    time.Duration
+
+	// Kick
+	onKick             func(reason string)
+	kickReason         string
+	noReconnectReasons map[string]bool
+
+	// Lifecycle events
+	onEvent func(Event)
+
+	onResponse func(route string, body interface{})
+
+	// Tracing
+	tracer      *tracing.Tracer
+	traceSample float64
+}
+
+// wireState is what the last handshake negotiated. It is replaced as a
+// whole, so a request running on another goroutine sees a consistent one.
+type wireState struct {
+	dict   map[string]uint16
+	abbrs  map[uint16]string
+	protos map[string]interface{}
+	codec  codec.Codec
+}
+
+// Call is a request in flight, returned by RequestAsync. It completes
+// exactly once, by whoever takes it out of the pending table: its
+// response, a timeout or cancellation, or the connection breaking.
+type Call struct {
+	Route string // 响应里没有 route，按请求的 route 解码
+
+	id     uint32
+	done   chan struct{}
+	result interface{}
+	err    error
+	stop   func() bool // 停止超时定时器或 context 监听，在 pendingMu 下设置
+
+	// 追踪
+	span        tracing.SpanContext
+	start, sent time.Time
+}
+
+// Done is closed when the call completes.
+func (cl *Call) Done() <-chan struct{} {
+	return cl.done
+}
+
+// Result waits for the call to complete and returns the decoded response.
+func (cl *Call) Result() (interface{}, error) {
+	<-cl.done
+	return cl.result, cl.err
 }
 
 type ClientOptions struct {
@@ -94,143 +187,407 @@ type ClientOptions struct {
 	Port       int
 	UserId     string
 	TcpEncrypt bool
+
+	// Heartbeat 是希望服务端使用的心跳间隔，0 表示由服务端决定
+	Heartbeat time.Duration
+
+	// Codec 是希望与服务端协商的 body 编码：json / msgpack / protobuf，为空时由服务端决定
+	Codec string
+
+	// Reconnect 打开后，连接意外断开时按指数退避自动重连，并携带 resumeToken 恢复服务端 session
+	Reconnect            bool
+	ReconnectMaxAttempts int           // 0 表示不限次数
+	ReconnectBaseDelay   time.Duration // 默认 500ms
+	ReconnectMaxDelay    time.Duration // 默认 30s
+
+	// 被服务端踢下线时回调；NoReconnectReasons 中的原因不再自动重连，
+	// 默认为 KickReasonDuplicateLogin
+	OnKick             func(reason string)
+	NoReconnectReasons []string
+
+	// Tracer 不为空时，按 TraceSample 的比例（0 表示全部）给 map 类型的请求体
+	// 加上 trace 上下文并记录 span
+	Tracer      *tracing.Tracer
+	TraceSample float64
 }
 
 func NewPinusTcpClient(opts ClientOptions) *PinusTcpClient {
-	return &PinusTcpClient{
-		host:          opts.Host,
-		port:          opts.Port,
-		userId:        opts.UserId,
-		netState:      NetStateInited,
-		readState:     ReadStateHead,
-		headBuffer:    make([]byte, protocol.HEAD_SIZE),
-		headOffset:    0,
-		callbacks:     make(map[uint32]func(interface{})),
-		handshakeChan: make(chan *HandshakeResponse, 1),
-		messageChan:   make(chan *protocol.Message, 100),
-		errorChan:     make(chan error, 10),
+	baseDelay := opts.ReconnectBaseDelay
+	if baseDelay <= 0 {
+		baseDelay = defaultReconnectBaseDelay
+	}
+	maxDelay := opts.ReconnectMaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultReconnectMaxDelay
+	}
+	noReconnect := opts.NoReconnectReasons
+	if noReconnect == nil {
+		noReconnect = []string{KickReasonDuplicateLogin}
+	}
+	traceSample := opts.TraceSample
+	if traceSample <= 0 {
+		traceSample = 1
+	}
+	noReconnectReasons := make(map[string]bool, len(noReconnect))
+	for _, reason := range noReconnect {
+		noReconnectReasons[reason] = true
 	}
+	c := &PinusTcpClient{
+		host:                 opts.Host,
+		port:                 opts.Port,
+		userId:               opts.UserId,
+		heartbeatHint:        opts.Heartbeat,
+		reconnect:            opts.Reconnect,
+		reconnectMaxAttempts: opts.ReconnectMaxAttempts,
+		reconnectBaseDelay:   baseDelay,
+		reconnectMaxDelay:    maxDelay,
+		onKick:               opts.OnKick,
+		noReconnectReasons:   noReconnectReasons,
+		tracer:               opts.Tracer,
+		traceSample:          traceSample,
+		pending:              make(map[uint32]*Call),
+		preferredCodec:       opts.Codec,
+	}
+	c.wire.Store(&wireState{codec: codec.JSON})
+	return c
 }
 
 func (c *PinusTcpClient) Connect() error {
-	address := fmt.Sprintf("%s:%d", c.host, c.port)
+	c.connMu.Lock()
+	c.closing = false
+	c.connMu.Unlock()
+	return c.connect()
+}
+
+func (c *PinusTcpClient) connect() error {
+	address := net.JoinHostPort(c.host, strconv.Itoa(c.port))
 	conn, err := net.Dial("tcp", address)
 	if err != nil {
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 
+	c.connMu.Lock()
+	if c.closing {
+		c.connMu.Unlock()
+		conn.Close()
+		return ErrNotConnected
+	}
 	c.conn = conn
-	c.netState = NetStateInited
+	c.kickReason = ""
+	c.connMu.Unlock()
+	c.netState.Store(NetStateInited)
+	c.emit(Event{Type: EventConnected})
 
 	// Send handshake
 	handshakeData := HandshakeData{}
 	handshakeData.Sys.Type = "client-simulator"
 	handshakeData.Sys.Version = "0.1.0"
 	handshakeData.Sys.RSA = make(map[string]interface{})
+	handshakeData.Sys.ResumeToken = c.resumeToken
+	if c.preferredCodec != "" {
+		handshakeData.Sys.Codecs = []string{c.preferredCodec, codec.JSON.Name()}
+	}
+	handshakeData.Sys.Heartbeat = int(c.heartbeatHint / time.Second)
 	handshakeData.User = make(map[string]interface{})
 
 	handshakeJSON, _ := json.Marshal(handshakeData)
 	handshakeBody := protocol.StrEncode(string(handshakeJSON))
 	handshakePkg := protocol.EncodePackage(protocol.TYPE_HANDSHAKE, handshakeBody)
 
-	if _, err := c.conn.Write(handshakePkg); err != nil {
-		return fmt.Errorf("failed to send handshake: %w", err)
+	if _, err := conn.Write(handshakePkg); err != nil {
+		return c.handshakeFailed(conn, fmt.Errorf("failed to send handshake: %w", err))
 	}
 
-	// Start reading
-	go c.readLoop()
+	// Start reading. 握手期间连接断开时从 lost 得到原因。读状态和握手响应
+	// 都属于这个连接，上一个连接迟到的握手响应不会被这次取到
+	r := newConnReader(conn)
+	lost := make(chan error, 1)
+	go c.readLoop(r, lost)
 
 	// Wait for handshake response
+	timeout := make(chan struct{})
+	timer := timewheel.Default().AfterFunc(handshakeTimeout, func() { close(timeout) })
+	defer timer.Stop()
+
 	select {
-	case resp := <-c.handshakeChan:
+	case resp := <-r.handshake:
 		if resp.Code == ResponseOldClient {
-			return fmt.Errorf("client version not fulfill")
+			return c.handshakeFailed(conn, fmt.Errorf("client version not fulfill"))
 		}
 		if resp.Code != ResponseOK {
-			return fmt.Errorf("handshake fail: code=%d", resp.Code)
+			return c.handshakeFailed(conn, fmt.Errorf("handshake fail: code=%d", resp.Code))
 		}
 		c.handleHandshakeResponse(resp)
 
 		// Send handshake ack
 		ackPkg := protocol.EncodePackage(protocol.TYPE_HANDSHAKE_ACK, nil)
-		if _, err := c.conn.Write(ackPkg); err != nil {
-			return fmt.Errorf("failed to send handshake ack: %w", err)
+		if err := c.write(ackPkg); err != nil {
+			return c.handshakeFailed(conn, fmt.Errorf("failed to send handshake ack: %w", err))
 		}
-		c.netState = NetStateWorking
-		c.startHeartbeat()
+		c.netState.Store(NetStateWorking)
+		c.startHeartbeat(conn)
+		c.emit(Event{Type: EventHandshake, Resumed: c.resumed})
 		return nil
-	case err := <-c.errorChan:
+	case err := <-lost:
 		return err
-	case <-time.After(10 * time.Second):
-		return fmt.Errorf("handshake timeout")
+	case <-timeout:
+		return c.handshakeFailed(conn, fmt.Errorf("handshake timeout"))
+	}
+}
+
+// handshakeFailed drops conn and returns err.
+func (c *PinusTcpClient) handshakeFailed(conn net.Conn, err error) error {
+	c.onConnectionLost(conn, err)
+	return err
+}
+
+// ResumeToken returns the token issued by the server in the last handshake.
+func (c *PinusTcpClient) ResumeToken() string {
+	return c.resumeToken
+}
+
+// Resumed reports whether the last handshake reattached to a previous
+// server session.
+func (c *PinusTcpClient) Resumed() bool {
+	return c.resumed
+}
+
+// onConnectionLost drops conn after it stopped working: a read error, a
+// heartbeat timeout or a failed handshake. Only the first call for the
+// current connection has any effect.
+func (c *PinusTcpClient) onConnectionLost(conn net.Conn, err error) {
+	c.connMu.Lock()
+	if c.conn != conn {
+		// Already replaced by Disconnect or a new connection
+		c.connMu.Unlock()
+		return
+	}
+	c.conn = nil
+	closing := c.closing
+	if c.kickReason != "" {
+		err = fmt.Errorf("%w: %s", ErrKicked, c.kickReason)
+	}
+	c.connMu.Unlock()
+	conn.Close()
+
+	wasWorking := c.netState.Swap(NetStateClosed) == NetStateWorking
+	c.stopHeartbeat()
+	c.failPending(fmt.Errorf("%w: %v", ErrConnectionLost, err))
+	c.emit(Event{Type: EventDisconnected, Err: err})
+
+	if !wasWorking || closing {
+		return
+	}
+	if c.reconnect {
+		sampledLogger.Info("Connection lost, reconnecting", "uid", c.userId, "err", err)
+		go c.reconnectLoop()
+	} else {
+		sampledLogger.Info("Connection lost", "uid", c.userId, "err", err)
+	}
+}
+
+// reconnectLoop redials with exponential backoff until it succeeds,
+// the client is closed or the attempts run out.
+func (c *PinusTcpClient) reconnectLoop() {
+	delay := c.reconnectBaseDelay
+	for attempt := 1; c.reconnectMaxAttempts <= 0 || attempt <= c.reconnectMaxAttempts; attempt++ {
+		// 加一点随机抖动，避免大量机器人同时重连
+		time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)))
+
+		c.connMu.Lock()
+		closing := c.closing
+		c.connMu.Unlock()
+		if closing {
+			return
+		}
+
+		err := c.connect()
+		if err == nil {
+			sampledLogger.Info("Reconnected", "uid", c.userId, "attempts", attempt, "resumed", c.resumed)
+			return
+		}
+		sampledLogger.Warn("Reconnect attempt failed", "uid", c.userId, "attempt", attempt, "err", err)
+
+		delay *= 2
+		if delay > c.reconnectMaxDelay {
+			delay = c.reconnectMaxDelay
+		}
+	}
+	logger.Warn("Giving up reconnecting", "uid", c.userId)
+}
+
+// write sends one whole package. Writes are serialized so packages from
+// concurrent requests never interleave, whatever the conn does.
+func (c *PinusTcpClient) write(data []byte) error {
+	c.connMu.Lock()
+	conn := c.conn
+	c.connMu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	c.writeMu.Lock()
+	_, err := conn.Write(data)
+	c.writeMu.Unlock()
+	return err
+}
+
+// writeData sends a request or notify package. Until the handshake is
+// done the conn may be a new one still in its handshake, and responses
+// arriving then would be dropped, so data waits for NetStateWorking.
+func (c *PinusTcpClient) writeData(data []byte) error {
+	if c.netState.Load() != NetStateWorking {
+		return ErrNotConnected
+	}
+	return c.write(data)
+}
+
+// failPending completes every request still waiting for a response with err.
+func (c *PinusTcpClient) failPending(err error) {
+	c.pendingMu.Lock()
+	pending := c.pending
+	c.pending = make(map[uint32]*Call)
+	c.pendingMu.Unlock()
+
+	for _, cl := range pending {
+		c.complete(cl, nil, err)
 	}
 }
 
 func (c *PinusTcpClient) handleHandshakeResponse(resp *HandshakeResponse) {
 	if resp.Sys != nil {
+		// Handle resume
+		if token, ok := resp.Sys["resumeToken"].(string); ok {
+			c.resumeToken = token
+		}
+		resumed, _ := resp.Sys["resumed"].(bool)
+		c.resumed = resumed
+
 		// Handle heartbeat interval
 		if heartbeat, ok := resp.Sys["heartbeat"].(float64); ok {
 			c.heartbeatInterval = time.Duration(heartbeat) * time.Second
 			c.heartbeatTimeout = c.heartbeatInterval * 2
 		}
+		// 没有给出策略的服务端按 both 处理：双方都发心跳
+		c.heartbeatPolicy, _ = resp.Sys["heartbeatPolicy"].(string)
+		if c.heartbeatPolicy == "" {
+			c.heartbeatPolicy = "both"
+		}
+
+		wire := &wireState{codec: codec.JSON}
 
 		// Handle dict
 		if dictData, ok := resp.Sys["dict"].(map[string]interface{}); ok {
-			c.dict = make(map[string]uint16)
+			wire.dict = make(map[string]uint16)
 			for route, abbr := range dictData {
 				if abbrNum, ok := abbr.(float64); ok {
-					c.dict[route] = uint16(abbrNum)
+					wire.dict[route] = uint16(abbrNum)
 				}
 			}
-			c.abbrs = make(map[uint16]string)
-			for route, abbr := range c.dict {
-				c.abbrs[abbr] = route
+			wire.abbrs = make(map[uint16]string)
+			for route, abbr := range wire.dict {
+				wire.abbrs[abbr] = route
 			}
 		}
 
-		// Handle protos
+		// Handle protos: client protos describe what we send, server protos what we receive
+		var encoderProtos, decoderProtos map[string]interface{}
 		if protos, ok := resp.Sys["protos"].(map[string]interface{}); ok {
-			c.protos = protos
-			var encoderProtos, decoderProtos map[string]interface{}
-			if clientProtos, ok := protos["client"].(map[string]interface{}); ok {
-				decoderProtos = clientProtos
-			}
-			if serverProtos, ok := protos["server"].(map[string]interface{}); ok {
-				encoderProtos = serverProtos
-			}
-			c.protobuf = protocol.NewProtobuf(encoderProtos, decoderProtos)
-			if c.dict != nil {
-				c.protobuf.SetDict(c.dict)
+			wire.protos = protos
+			encoderProtos, _ = protos["client"].(map[string]interface{})
+			decoderProtos, _ = protos["server"].(map[string]interface{})
+		}
+
+		// Handle codec. A pinus server does not send one and uses protobuf
+		// for the routes it has protos for.
+		name, _ := resp.Sys["codec"].(string)
+		if name == "" && (len(encoderProtos) > 0 || len(decoderProtos) > 0) {
+			name = "protobuf"
+		}
+		if name == "protobuf" {
+			pb, err := codec.NewProtobuf(encoderProtos, decoderProtos, codec.JSON)
+			if err != nil {
+				logger.Error("Failed to parse protos", "uid", c.userId, "err", err)
+			} else {
+				wire.codec = pb
 			}
+		} else if bodyCodec, ok := codec.Get(name); ok {
+			wire.codec = bodyCodec
 		}
+		c.wire.Store(wire)
 	}
 }
 
-func (c *PinusTcpClient) readLoop() {
+// connReader is the read state of one connection. Every connection gets
+// its own, so a read loop that outlives its connection never shares
+// buffers with the next one.
+type connReader struct {
+	conn          net.Conn
+	readState     int
+	headBuffer    []byte
+	headOffset    int
+	packageBuffer []byte
+	packageOffset int
+	packageSize   int
+	handshake     chan *HandshakeResponse
+}
+
+func newConnReader(conn net.Conn) *connReader {
+	return &connReader{
+		conn:       conn,
+		readState:  ReadStateHead,
+		headBuffer: make([]byte, protocol.HEAD_SIZE),
+		handshake:  make(chan *HandshakeResponse, 1),
+	}
+}
+
+func (c *PinusTcpClient) readLoop(r *connReader, lost chan<- error) {
+	conn := r.conn
 	buffer := make([]byte, 4096)
 	for {
-		n, err := c.conn.Read(buffer)
+		n, err := conn.Read(buffer)
 		if err != nil {
-			if err != io.EOF {
-				c.errorChan <- fmt.Errorf("read error: %w", err)
+			if err == io.EOF {
+				err = ErrClosedByServer
+			} else {
+				err = fmt.Errorf("read error: %w", err)
 			}
+			c.onConnectionLost(conn, err)
+			lost <- err
+			return
+		}
+		if !c.isCurrent(conn) {
+			// 已被 Disconnect 或新的连接取代，剩下的数据属于旧的 session
+			lost <- ErrConnectionLost
 			return
 		}
 
 		offset := 0
-		for offset < n && c.readState != ReadStateClosed {
-			if c.readState == ReadStateHead {
-				offset = c.readHead(buffer, offset, n)
+		for offset < n && r.readState != ReadStateClosed {
+			if r.readState == ReadStateHead {
+				offset = c.readHead(r, buffer, offset, n)
 			}
-			if c.readState == ReadStateBody {
-				offset = c.readBody(buffer, offset, n)
+			if r.readState == ReadStateBody {
+				offset = c.readBody(r, buffer, offset, n)
 			}
 		}
+		if r.readState == ReadStateClosed {
+			err := ErrInvalidPackage
+			c.onConnectionLost(conn, err)
+			lost <- err
+			return
+		}
 	}
 }
 
-func (c *PinusTcpClient) readHead(data []byte, offset, totalLen int) int {
-	hlen := protocol.HEAD_SIZE - c.headOffset
+// isCurrent reports whether conn is still the connection of the client.
+func (c *PinusTcpClient) isCurrent(conn net.Conn) bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.conn == conn
+}
+
+func (c *PinusTcpClient) readHead(r *connReader, data []byte, offset, totalLen int) int {
+	hlen := protocol.HEAD_SIZE - r.headOffset
 	dlen := totalLen - offset
 	len := hlen
 	if dlen < len {
@@ -238,36 +595,36 @@ func (c *PinusTcpClient) readHead(data []byte, offset, totalLen int) int {
 	}
 	dend := offset + len
 
-	copy(c.headBuffer[c.headOffset:], data[offset:dend])
-	c.headOffset += len
+	copy(r.headBuffer[r.headOffset:], data[offset:dend])
+	r.headOffset += len
 
-	if c.headOffset == protocol.HEAD_SIZE {
+	if r.headOffset == protocol.HEAD_SIZE {
 		// Head finished
-		size := protocol.HeadHandler(c.headBuffer)
+		size := protocol.HeadHandler(r.headBuffer)
 		if size < 0 {
-			c.errorChan <- fmt.Errorf("invalid body size: %d", size)
-			c.readState = ReadStateClosed
+			sampledLogger.Warn("Closing connection with invalid body size", "uid", c.userId, "size", size)
+			r.readState = ReadStateClosed
 			return totalLen
 		}
 
-		if !protocol.CheckTypeData(c.headBuffer[0]) {
-			log.Printf("close the connection with invalid head message")
-			c.readState = ReadStateClosed
+		if !protocol.CheckTypeData(r.headBuffer[0]) {
+			sampledLogger.Warn("Closing connection with invalid head message", "uid", c.userId)
+			r.readState = ReadStateClosed
 			return totalLen
 		}
 
-		c.packageSize = size + protocol.HEAD_SIZE
-		c.packageBuffer = make([]byte, c.packageSize)
-		copy(c.packageBuffer, c.headBuffer)
-		c.packageOffset = protocol.HEAD_SIZE
-		c.readState = ReadStateBody
+		r.packageSize = size + protocol.HEAD_SIZE
+		r.packageBuffer = make([]byte, r.packageSize)
+		copy(r.packageBuffer, r.headBuffer)
+		r.packageOffset = protocol.HEAD_SIZE
+		r.readState = ReadStateBody
 	}
 
 	return dend
 }
 
-func (c *PinusTcpClient) readBody(data []byte, offset, totalLen int) int {
-	blen := c.packageSize - c.packageOffset
+func (c *PinusTcpClient) readBody(r *connReader, data []byte, offset, totalLen int) int {
+	blen := r.packageSize - r.packageOffset
 	dlen := totalLen - offset
 	len := blen
 	if dlen < len {
@@ -275,36 +632,36 @@ func (c *PinusTcpClient) readBody(data []byte, offset, totalLen int) int {
 	}
 	dend := offset + len
 
-	copy(c.packageBuffer[c.packageOffset:], data[offset:dend])
-	c.packageOffset += len
+	copy(r.packageBuffer[r.packageOffset:], data[offset:dend])
+	r.packageOffset += len
 
-	if c.packageOffset == c.packageSize {
+	if r.packageOffset == r.packageSize {
 		// Package finished
-		c.processPackage(c.packageBuffer)
-		c.reset()
+		c.processPackage(r, r.packageBuffer)
+		r.reset()
 	}
 
 	return dend
 }
 
-func (c *PinusTcpClient) reset() {
-	c.headOffset = 0
-	c.packageOffset = 0
-	c.packageSize = 0
-	c.packageBuffer = nil
-	c.readState = ReadStateHead
+func (r *connReader) reset() {
+	r.headOffset = 0
+	r.packageOffset = 0
+	r.packageSize = 0
+	r.packageBuffer = nil
+	r.readState = ReadStateHead
 }
 
-func (c *PinusTcpClient) processPackage(data []byte) {
+func (c *PinusTcpClient) processPackage(r *connReader, data []byte) {
 	pkg, err := protocol.DecodePackage(data)
 	if err != nil {
-		log.Printf("failed to decode package: %v", err)
+		sampledLogger.Warn("Failed to decode package", "uid", c.userId, "err", err)
 		return
 	}
 
 	switch pkg.Type {
 	case protocol.TYPE_HANDSHAKE:
-		c.handleHandshake(pkg)
+		c.handleHandshake(r, pkg)
 	case protocol.TYPE_HANDSHAKE_ACK:
 		c.handleHandshakeAck(pkg)
 	case protocol.TYPE_HEARTBEAT:
@@ -314,282 +671,462 @@ func (c *PinusTcpClient) processPackage(data []byte) {
 	case protocol.TYPE_KICK:
 		c.handleKick(pkg)
 	default:
-		log.Printf("unknown package type: %d", pkg.Type)
+		sampledLogger.Warn("Unknown package type", "uid", c.userId, "type", pkg.Type)
 	}
 }
 
-func (c *PinusTcpClient) handleHandshake(pkg *protocol.Package) {
-	if c.netState != NetStateInited {
+func (c *PinusTcpClient) handleHandshake(r *connReader, pkg *protocol.Package) {
+	if c.netState.Load() != NetStateInited {
 		return
 	}
 
 	var resp HandshakeResponse
 	bodyStr := protocol.StrDecode(pkg.Body)
 	if err := json.Unmarshal([]byte(bodyStr), &resp); err != nil {
-		log.Printf("failed to parse handshake: %v", err)
+		logger.Warn("Failed to parse handshake", "uid", c.userId, "err", err)
 		resp.Code = ResponseFail
 	}
-	c.handshakeChan <- &resp
+	// 同一个连接上重复的握手响应丢掉，不能阻塞读 goroutine
+	select {
+	case r.handshake <- &resp:
+	default:
+	}
 }
 
 func (c *PinusTcpClient) handleHandshakeAck(pkg *protocol.Package) {
-	if c.netState != NetStateWaitAck {
+	if c.netState.Load() != NetStateWaitAck {
 		return
 	}
-	c.netState = NetStateWorking
+	c.netState.Store(NetStateWorking)
 	c.triggerHeartbeat()
 }
 
 func (c *PinusTcpClient) handleHeartbeat(pkg *protocol.Package) {
-	if c.netState != NetStateWorking {
+	if c.netState.Load() != NetStateWorking {
 		return
 	}
+	// 服务端驱动时由客户端回应心跳
+	if c.heartbeatPolicy == "server" {
+		c.write(protocol.EncodePackage(protocol.TYPE_HEARTBEAT, nil))
+	}
 	c.triggerHeartbeat()
 }
 
 func (c *PinusTcpClient) handleData(pkg *protocol.Package) {
-	if c.netState != NetStateWorking {
+	if c.netState.Load() != NetStateWorking {
 		return
 	}
+	// 收到数据同样说明连接存活
+	c.triggerHeartbeat()
 	msg, err := protocol.DecodeMessage(pkg.Body)
 	if err != nil {
-		log.Printf("failed to decode message: %v", err)
+		sampledLogger.Warn("Failed to decode message", "uid", c.userId, "err", err)
 		return
 	}
 
+	wire := c.wire.Load()
+
 	// Decompress route if needed
-	if msg.CompressRoute && c.abbrs != nil && len(msg.Route) == 2 {
+	if msg.CompressRoute && wire.abbrs != nil && len(msg.Route) == 2 {
 		// Route is compressed as 2 bytes (uint16, big-endian)
 		routeBytes := []byte(msg.Route)
 		abbr := uint16(routeBytes[0])<<8 | uint16(routeBytes[1])
-		if route, ok := c.abbrs[abbr]; ok {
+		if route, ok := wire.abbrs[abbr]; ok {
 			msg.Route = route
 		} else {
-			log.Printf("failed to decompress route, abbr=%d", abbr)
-		}
-	}
-
-	// Decode body
-	var body interface{}
-	if c.protobuf != nil {
-		decoded, err := c.protobuf.Decode(msg.Route, msg.Body)
-		if err == nil {
-			body = decoded
-		} else {
-			// Fallback to JSON
-			json.Unmarshal(msg.Body, &body)
+			sampledLogger.Warn("Failed to decompress route", "uid", c.userId, "abbr", abbr)
 		}
-	} else {
-		json.Unmarshal(msg.Body, &body)
 	}
 
 	if msg.Type == protocol.TYPE_PUSH {
-		log.Printf("[%s] 通知 %s: %v", c.userId, msg.Route, body)
+		body, err := wire.codec.Decode(msg.Route, msg.Body)
+		if err != nil {
+			sampledLogger.Warn("Failed to decode push", "uid", c.userId, "route", msg.Route, "err", err)
+			return
+		}
+		sampledLogger.Debug("Push received", "uid", c.userId, "route", msg.Route, "body", body)
+		c.dispatchPush(&Push{Route: msg.Route, Body: body})
 	} else if msg.Type == protocol.TYPE_RESPONSE {
-		c.callbackMutex.Lock()
-		cb, ok := c.callbacks[msg.ID]
-		if ok {
-			delete(c.callbacks, msg.ID)
+		cl := c.takePending(msg.ID)
+		if cl == nil {
+			// 已经超时、被取消或连接已被判定断开
+			return
 		}
-		c.callbackMutex.Unlock()
 
-		if ok && cb != nil {
-			cb(body)
+		body, err := wire.codec.Decode(cl.Route, msg.Body)
+		if err != nil {
+			sampledLogger.Warn("Failed to decode response", "uid", c.userId, "route", cl.Route, "err", err)
+			err = fmt.Errorf("failed to decode response: %w", err)
+		} else {
+			c.connMu.Lock()
+			onResponse := c.onResponse
+			c.connMu.Unlock()
+			if onResponse != nil {
+				onResponse(cl.Route, body)
+			}
 		}
+		c.complete(cl, body, err)
 	}
 }
 
 func (c *PinusTcpClient) handleKick(pkg *protocol.Package) {
-	if c.netState != NetStateWorking {
+	if c.netState.Load() != NetStateWorking {
 		return
 	}
-	var msg map[string]interface{}
+	var msg struct {
+		Reason string `json:"reason"`
+	}
 	bodyStr := protocol.StrDecode(pkg.Body)
 	json.Unmarshal([]byte(bodyStr), &msg)
-	log.Printf("被踢: %v", msg)
+	logger.Info("Kicked", "uid", c.userId, "reason", msg.Reason)
+
+	// 服务端随后会断开连接，这些原因下不再自动重连
+	c.connMu.Lock()
+	if c.noReconnectReasons[msg.Reason] {
+		c.closing = true
+	}
+	c.kickReason = msg.Reason
+	onKick := c.onKick
+	c.connMu.Unlock()
+
+	if onKick != nil {
+		onKick(msg.Reason)
+	}
+	c.emit(Event{Type: EventKicked, Reason: msg.Reason})
 }
 
-func (c *PinusTcpClient) startHeartbeat() {
+// OnKick sets the callback for kicks from the server. It runs on the
+// read goroutine and must not block.
+func (c *PinusTcpClient) OnKick(fn func(reason string)) {
+	c.connMu.Lock()
+	c.onKick = fn
+	c.connMu.Unlock()
+}
+
+// OnResponse sets a callback that sees every decoded response in the
+// order they arrive, before the waiting request returns. Responses to
+// requests that already timed out or were cancelled are not decoded and
+// not seen. It runs on the read goroutine and must not block.
+func (c *PinusTcpClient) OnResponse(fn func(route string, body interface{})) {
+	c.connMu.Lock()
+	c.onResponse = fn
+	c.connMu.Unlock()
+}
+
+// startHeartbeat starts sending heartbeats and checking for timeouts on
+// conn once its handshake is done.
+func (c *PinusTcpClient) startHeartbeat(conn net.Conn) {
 	if c.heartbeatInterval <= 0 {
 		return
 	}
+	c.heartbeatMu.Lock()
+	c.heartbeatConn = conn
+	c.heartbeatMu.Unlock()
 	// Start the first heartbeat cycle
-	c.scheduleNextHeartbeat()
+	c.triggerHeartbeat()
 }
 
-// scheduleNextHeartbeat schedules the next heartbeat to be sent
+// scheduleNextHeartbeat schedules the next heartbeat to be sent.
+// heartbeatMu must be held.
 func (c *PinusTcpClient) scheduleNextHeartbeat() {
-	if c.heartbeatInterval <= 0 {
-		return
-	}
-
 	// Stop existing heartbeat timer if any
 	if c.heartbeatTimer != nil {
 		c.heartbeatTimer.Stop()
-		c.heartbeatTimer = nil
 	}
 
-	// Schedule next heartbeat send
-	c.heartbeatTimer = time.AfterFunc(c.heartbeatInterval, func() {
-		c.heartbeatTimer = nil
-		// Send heartbeat
-		heartbeatPkg := protocol.EncodePackage(protocol.TYPE_HEARTBEAT, nil)
-		if c.conn != nil {
-			c.conn.Write(heartbeatPkg)
-		}
-		// Set timeout check
-		c.nextHeartbeatTimeout = time.Now().Add(c.heartbeatTimeout)
-		c.scheduleHeartbeatTimeout()
-		// Schedule next heartbeat
-		c.scheduleNextHeartbeat()
+	// Schedule next heartbeat send. 时间轮的回调不能阻塞，写操作放到单独的 goroutine
+	conn := c.heartbeatConn
+	c.heartbeatTimer = timewheel.Default().AfterFunc(c.heartbeatInterval, func() {
+		go c.sendHeartbeat(conn)
 	})
 }
 
-// scheduleHeartbeatTimeout schedules the heartbeat timeout check
-func (c *PinusTcpClient) scheduleHeartbeatTimeout() {
-	// Stop existing timeout timer if any
-	if c.heartbeatTimeoutTimer != nil {
-		c.heartbeatTimeoutTimer.Stop()
-		c.heartbeatTimeoutTimer = nil
+func (c *PinusTcpClient) sendHeartbeat(conn net.Conn) {
+	heartbeatPkg := protocol.EncodePackage(protocol.TYPE_HEARTBEAT, nil)
+	if err := c.write(heartbeatPkg); err != nil {
+		return
 	}
+	// 发送心跳不延长超时，只有收到数据才算对方存活
+	c.heartbeatMu.Lock()
+	if c.heartbeatConn == conn {
+		c.scheduleNextHeartbeat()
+	}
+	c.heartbeatMu.Unlock()
+}
 
-	// Schedule timeout check
-	c.heartbeatTimeoutTimer = time.AfterFunc(c.heartbeatTimeout, func() {
-		c.heartbeatTimeoutTimer = nil
-		// Check if we've exceeded the timeout
-		gap := time.Until(c.nextHeartbeatTimeout)
-		if gap > gapThreshold*time.Millisecond {
-			// Reschedule for the remaining time
-			c.heartbeatTimeoutTimer = time.AfterFunc(gap, func() {
-				log.Printf("tcp heartbeat timeout")
-			})
-		} else {
-			log.Printf("tcp heartbeat timeout")
-		}
-	})
+// checkHeartbeatTimeout runs when the timeout timer fires. Anything
+// received since it was scheduled moved the deadline, so it waits for
+// the rest of the gap; otherwise the connection is dropped.
+func (c *PinusTcpClient) checkHeartbeatTimeout(conn net.Conn) {
+	c.heartbeatMu.Lock()
+	if c.heartbeatConn != conn {
+		c.heartbeatMu.Unlock()
+		return
+	}
+	if gap := time.Until(c.nextHeartbeatTimeout); gap > gapThreshold*time.Millisecond {
+		// Reschedule for the remaining time
+		c.heartbeatTimeoutTimer = timewheel.Default().AfterFunc(gap, func() { c.checkHeartbeatTimeout(conn) })
+		c.heartbeatMu.Unlock()
+		return
+	}
+	c.heartbeatTimeoutTimer = nil
+	c.heartbeatMu.Unlock()
+
+	sampledLogger.Info("Heartbeat timeout", "uid", c.userId)
+	// 断开连接会停止心跳并调用事件回调，不在时间轮的 goroutine 上做
+	go func() {
+		c.emit(Event{Type: EventHeartbeatTimeout})
+		c.onConnectionLost(conn, ErrHeartbeatTimeout)
+	}()
 }
 
-// triggerHeartbeat is called when we receive a heartbeat from server
-// It resets the heartbeat sending timer and timeout check timer
+// triggerHeartbeat is called when we receive anything from server.
+// It resets the heartbeat sending timer and moves the timeout deadline.
 func (c *PinusTcpClient) triggerHeartbeat() {
 	if c.heartbeatInterval <= 0 {
 		return
 	}
+	c.heartbeatMu.Lock()
+	defer c.heartbeatMu.Unlock()
+	if c.heartbeatConn == nil {
+		return
+	}
 
-	// Reset heartbeat sending timer (clear old, schedule new)
-	c.scheduleNextHeartbeat()
-	
-	// Reset timeout check timer (clear old, schedule new)
-	c.scheduleHeartbeatTimeout()
-}
+	// Reset heartbeat sending timer (clear old, schedule new).
+	// 服务端驱动时客户端不主动发送
+	if c.heartbeatPolicy != "server" {
+		c.scheduleNextHeartbeat()
+	}
 
-func (c *PinusTcpClient) encode(route string, msg interface{}) ([]byte, error) {
-	if c.protobuf != nil {
-		normalized := c.protobuf.NormalizeRoute(route)
-		if c.protobuf.Check("server", normalized) {
-			return c.protobuf.Encode(normalized, msg)
-		}
+	// 只移动截止时间，定时器到期时再按剩余时间重新安排，省去每个包一次重置
+	c.nextHeartbeatTimeout = time.Now().Add(c.heartbeatTimeout)
+	if c.heartbeatTimeoutTimer == nil {
+		conn := c.heartbeatConn
+		c.heartbeatTimeoutTimer = timewheel.Default().AfterFunc(c.heartbeatTimeout, func() { c.checkHeartbeatTimeout(conn) })
 	}
-	// Fallback to JSON
-	return json.Marshal(msg)
 }
 
-func (c *PinusTcpClient) compressRoute(route string) (uint16, bool) {
-	if c.dict != nil {
-		if abbr, ok := c.dict[route]; ok {
-			return abbr, true
-		}
+// encodeMessage encodes msg into a data package with the codec and
+// route dict of the current connection.
+func (c *PinusTcpClient) encodeMessage(id uint32, msgType byte, route string, msg interface{}) ([]byte, error) {
+	wire := c.wire.Load()
+	encodedBody, err := wire.codec.Encode(route, msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	// Compress route
+	compressedRoute, compressRoute := wire.dict[route]
+
+	encodedMsg, err := protocol.EncodeMessage(id, msgType, compressRoute, route, compressedRoute, encodedBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
 	}
-	return 0, false
+	return protocol.EncodePackage(protocol.TYPE_DATA, encodedMsg), nil
 }
 
+// Request sends a request and waits up to 30 seconds for its response.
+// It is safe to call from many goroutines at once; requests are pipelined
+// on the connection and responses matched by id. If the connection
+// breaks, every request still waiting fails with ErrConnectionLost.
 func (c *PinusTcpClient) Request(route string, msg interface{}) (interface{}, error) {
+	cl := c.send(route, msg)
+	// 默认超时用时间轮，比每个请求一个 context 定时器便宜
+	timer := timewheel.Default().AfterFunc(requestTimeout, func() { c.abort(cl, ErrRequestTimeout) })
+	c.watch(cl, timer.Stop)
+	return cl.Result()
+}
+
+// RequestContext is Request with the deadline and cancellation of ctx
+// instead of the default timeout. A cancelled request returns ctx.Err()
+// and its late response is dropped.
+func (c *PinusTcpClient) RequestContext(ctx context.Context, route string, msg interface{}) (interface{}, error) {
+	return c.RequestAsync(ctx, route, msg).Result()
+}
+
+// RequestAsync sends a request without waiting for it. The returned Call
+// completes when the response arrives, ctx is done or the connection
+// breaks, so many requests can be fired first and waited for later.
+func (c *PinusTcpClient) RequestAsync(ctx context.Context, route string, msg interface{}) *Call {
+	if err := ctx.Err(); err != nil {
+		return failedCall(route, err)
+	}
+	cl := c.send(route, msg)
+	if ctx.Done() != nil {
+		c.watch(cl, context.AfterFunc(ctx, func() { c.abort(cl, ctx.Err()) }))
+	}
+	return cl
+}
+
+// send registers and writes a request. The call is registered before it
+// is written so that even an immediate response finds it.
+func (c *PinusTcpClient) send(route string, msg interface{}) *Call {
 	if route == "" {
-		return nil, fmt.Errorf("route cannot be empty")
+		return failedCall(route, fmt.Errorf("route cannot be empty"))
 	}
 
-	c.reqId++
-	reqId := c.reqId
+	cl := &Call{Route: route, id: atomic.AddUint32(&c.reqId, 1), done: make(chan struct{})}
 
-	// Encode message
-	encodedBody, err := c.encode(route, msg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encode message: %w", err)
+	// 追踪：在请求体的保留字段里带上 traceparent
+	if c.tracer != nil && (c.traceSample >= 1 || rand.Float64() < c.traceSample) {
+		if body, ok := msg.(map[string]interface{}); ok {
+			cl.span = tracing.NewRoot()
+			traced := make(map[string]interface{}, len(body)+1)
+			for k, v := range body {
+				traced[k] = v
+			}
+			traced[tracing.Field] = cl.span.Traceparent()
+			msg = traced
+			cl.start = time.Now()
+		}
 	}
 
-	// Compress route
-	compressedRoute, compressRoute := c.compressRoute(route)
-
-	// Encode message
-	encodedMsg, err := protocol.EncodeMessage(reqId, protocol.TYPE_REQUEST, compressRoute, route, compressedRoute, encodedBody)
+	pkg, err := c.encodeMessage(cl.id, protocol.TYPE_REQUEST, route, msg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode message: %w", err)
+		c.complete(cl, nil, err)
+		return cl
 	}
 
-	// Encode package
-	pkg := protocol.EncodePackage(protocol.TYPE_DATA, encodedMsg)
+	c.pendingMu.Lock()
+	c.pending[cl.id] = cl
+	c.pendingMu.Unlock()
 
 	// Send
-	if _, err := c.conn.Write(pkg); err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+	if err := c.writeData(pkg); err != nil {
+		c.abort(cl, fmt.Errorf("failed to send request: %w", err))
+		return cl
 	}
+	if cl.span.IsValid() {
+		// 响应由读 goroutine 处理，这里写入 sent 可能晚于 complete 读取它，
+		// 所以只在还没完成时写
+		c.pendingMu.Lock()
+		if c.pending[cl.id] == cl {
+			cl.sent = time.Now()
+		}
+		c.pendingMu.Unlock()
+	}
+	return cl
+}
 
-	// Wait for response
-	resultChan := make(chan interface{}, 1)
-
-	c.callbackMutex.Lock()
-	c.callbacks[reqId] = func(res interface{}) {
-		resultChan <- res
+// watch attaches the stop function of the call's timeout or context
+// watcher, or stops it right away when the call has already completed.
+func (c *PinusTcpClient) watch(cl *Call, stop func() bool) {
+	c.pendingMu.Lock()
+	if c.pending[cl.id] == cl {
+		cl.stop = stop
+		c.pendingMu.Unlock()
+		return
 	}
-	c.callbackMutex.Unlock()
+	c.pendingMu.Unlock()
+	stop()
+}
 
-	select {
-	case result := <-resultChan:
-		return result, nil
-	case err := <-c.errorChan:
-		return nil, err
-	case <-time.After(30 * time.Second):
-		c.callbackMutex.Lock()
-		delete(c.callbacks, reqId)
-		c.callbackMutex.Unlock()
-		return nil, fmt.Errorf("request timeout")
+// abort completes cl with err unless it has already completed.
+func (c *PinusTcpClient) abort(cl *Call, err error) {
+	c.pendingMu.Lock()
+	pending := c.pending[cl.id] == cl
+	if pending {
+		delete(c.pending, cl.id)
+	}
+	c.pendingMu.Unlock()
+	if pending {
+		c.complete(cl, nil, err)
 	}
 }
 
-func (c *PinusTcpClient) Notify(route string, msg interface{}) error {
-	// Encode message
-	encodedBody, err := c.encode(route, msg)
-	if err != nil {
-		return fmt.Errorf("failed to encode message: %w", err)
+// takePending removes and returns the call waiting for response id.
+func (c *PinusTcpClient) takePending(id uint32) *Call {
+	c.pendingMu.Lock()
+	cl := c.pending[id]
+	delete(c.pending, id)
+	c.pendingMu.Unlock()
+	return cl
+}
+
+// complete finishes a call that the caller has taken out of the pending
+// table, or that never made it in.
+func (c *PinusTcpClient) complete(cl *Call, result interface{}, err error) {
+	if cl.stop != nil {
+		cl.stop()
+	}
+	if err == nil && cl.span.IsValid() {
+		received := time.Now()
+		if cl.sent.IsZero() {
+			cl.sent = received
+		}
+		c.exportRequestSpans(cl.span, cl.Route, cl.id, cl.start, cl.sent, received)
 	}
+	cl.result, cl.err = result, err
+	close(cl.done)
+}
 
-	// Compress route
-	compressedRoute, compressRoute := c.compressRoute(route)
+func failedCall(route string, err error) *Call {
+	cl := &Call{Route: route, done: make(chan struct{}), err: err}
+	close(cl.done)
+	return cl
+}
 
-	// Encode message
-	encodedMsg, err := protocol.EncodeMessage(0, protocol.TYPE_NOTIFY, compressRoute, route, compressedRoute, encodedBody)
+// exportRequestSpans records the client span of a traced request, from
+// encoding the request to receiving the response, and its send child.
+func (c *PinusTcpClient) exportRequestSpans(span tracing.SpanContext, route string, reqId uint32, start, sent, received time.Time) {
+	c.tracer.Export(&tracing.Span{
+		Name:    "client " + route,
+		Kind:    tracing.KindClient,
+		Context: span,
+		Start:   start,
+		End:     received,
+		Attrs: []tracing.Attr{
+			{Key: "rpc.method", Value: route},
+			{Key: "message.id", Value: int64(reqId)},
+			{Key: "enduser.id", Value: c.userId},
+		},
+	})
+	c.tracer.Export(&tracing.Span{
+		Name:    "send " + route,
+		Kind:    tracing.KindInternal,
+		Context: span.Child(),
+		Parent:  span.SpanID,
+		Start:   start,
+		End:     sent,
+	})
+}
+
+func (c *PinusTcpClient) Notify(route string, msg interface{}) error {
+	pkg, err := c.encodeMessage(0, protocol.TYPE_NOTIFY, route, msg)
 	if err != nil {
-		return fmt.Errorf("failed to encode message: %w", err)
+		return err
 	}
 
-	// Encode package
-	pkg := protocol.EncodePackage(protocol.TYPE_DATA, encodedMsg)
-
 	// Send
-	_, err = c.conn.Write(pkg)
-	return err
+	return c.writeData(pkg)
 }
 
-func (c *PinusTcpClient) Disconnect() {
-	c.netState = NetStateClosed
+func (c *PinusTcpClient) stopHeartbeat() {
+	c.heartbeatMu.Lock()
+	c.heartbeatConn = nil
 	if c.heartbeatTimer != nil {
 		c.heartbeatTimer.Stop()
+		c.heartbeatTimer = nil
 	}
 	if c.heartbeatTimeoutTimer != nil {
 		c.heartbeatTimeoutTimer.Stop()
+		c.heartbeatTimeoutTimer = nil
 	}
-	if c.conn != nil {
-		c.conn.Close()
-		c.conn = nil
+	c.heartbeatMu.Unlock()
+}
+
+func (c *PinusTcpClient) Disconnect() {
+	c.connMu.Lock()
+	c.closing = true
+	conn := c.conn
+	c.conn = nil
+	c.connMu.Unlock()
+
+	c.netState.Store(NetStateClosed)
+	c.stopHeartbeat()
+	c.failPending(ErrClosed)
+	if conn != nil {
+		conn.Close()
+		c.emit(Event{Type: EventDisconnected, Err: ErrClosed})
 	}
 }
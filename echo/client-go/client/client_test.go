@@ -0,0 +1,151 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"client-go/protocol"
+)
+
+// 以下测试连接一个进程内的假服务端，用 go test -race 运行可以发现
+// 重连时新旧连接的读 goroutine 之间的数据竞争。
+
+// fakeServer answers the handshakes on its connections with the codes
+// returned by handshake, and echoes requests. A connection is closed by
+// the server after closeAfter requests, 0 means never.
+type fakeServer struct {
+	ln         net.Listener
+	conns      atomic.Int32
+	handshake  func(conn int) []int
+	closeAfter int
+}
+
+func startFakeServer(t *testing.T, s *fakeServer) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ln = ln
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, int(s.conns.Add(1)))
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) options() ClientOptions {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return ClientOptions{Host: addr.IP.String(), Port: addr.Port, UserId: "test"}
+}
+
+func (s *fakeServer) serve(conn net.Conn, n int) {
+	defer conn.Close()
+	requests := 0
+	head := make([]byte, protocol.HEAD_SIZE)
+	for {
+		if _, err := io.ReadFull(conn, head); err != nil {
+			return
+		}
+		body := make([]byte, protocol.HeadHandler(head))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		switch head[0] {
+		case protocol.TYPE_HANDSHAKE:
+			// 几个握手响应一次写出，客户端在同一次读里收到
+			var out []byte
+			for _, code := range s.handshake(n) {
+				resp, _ := json.Marshal(map[string]interface{}{"code": code, "sys": map[string]interface{}{}})
+				out = append(out, protocol.EncodePackage(protocol.TYPE_HANDSHAKE, resp)...)
+			}
+			conn.Write(out)
+		case protocol.TYPE_DATA:
+			msg, err := protocol.DecodeMessage(body)
+			if err != nil {
+				return
+			}
+			resp, _ := protocol.EncodeMessage(msg.ID, protocol.TYPE_RESPONSE, false, "", 0, msg.Body)
+			conn.Write(protocol.EncodePackage(protocol.TYPE_DATA, resp))
+			requests++
+			if requests == s.closeAfter {
+				return
+			}
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	s := startFakeServer(t, &fakeServer{
+		handshake:  func(int) []int { return []int{ResponseOK} },
+		closeAfter: 3,
+	})
+	opts := s.options()
+	opts.Reconnect = true
+	opts.ReconnectBaseDelay = time.Millisecond
+	opts.ReconnectMaxDelay = time.Millisecond
+	c := NewPinusTcpClient(opts)
+	defer c.Disconnect()
+	handshakes := make(chan struct{}, 100)
+	c.OnEvent(func(ev Event) {
+		if ev.Type == EventHandshake {
+			handshakes <- struct{}{}
+		}
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	<-handshakes
+
+	// 每 3 个请求服务端断开一次，客户端重连后继续
+	for ok := 0; ok < 30; {
+		res, err := c.Request("echo", map[string]interface{}{"n": ok})
+		if err != nil {
+			select {
+			case <-handshakes:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no reconnect after %v", err)
+			}
+			continue
+		}
+		if n, _ := res.(map[string]interface{})["n"].(float64); int(n) != ok {
+			t.Fatalf("response %v to request %d", res, ok)
+		}
+		ok++
+	}
+	if n := s.conns.Load(); n < 10 {
+		t.Fatalf("%d connections for 30 requests, want at least 10", n)
+	}
+}
+
+func TestStaleHandshake(t *testing.T) {
+	// 第一个连接收到两个失败的握手响应，第二个不能被下一次连接取到
+	s := startFakeServer(t, &fakeServer{
+		handshake: func(conn int) []int {
+			if conn == 1 {
+				return []int{ResponseFail, ResponseFail}
+			}
+			return []int{ResponseOK}
+		},
+	})
+	c := NewPinusTcpClient(s.options())
+	defer c.Disconnect()
+	if err := c.Connect(); err == nil {
+		t.Fatal("first Connect succeeded, want the handshake to fail")
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("second Connect: %v", err)
+	}
+	if _, err := c.Request("echo", map[string]interface{}{"n": 1}); err != nil {
+		t.Fatalf("Request after reconnect: %v", err)
+	}
+}
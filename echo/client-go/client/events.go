@@ -0,0 +1,64 @@
+package client
+
+import "errors"
+
+// EventType is a stage in the life of a connection.
+type EventType int
+
+const (
+	EventConnected        EventType = iota + 1 // TCP 连接建立，握手之前
+	EventHandshake                             // 握手完成，可以收发请求
+	EventDisconnected                          // 连接断开，Err 为原因
+	EventKicked                                // 被服务端踢下线，Reason 为原因
+	EventHeartbeatTimeout                      // 心跳超时，随后以 ErrHeartbeatTimeout 断开
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventConnected:
+		return "connected"
+	case EventHandshake:
+		return "handshake"
+	case EventDisconnected:
+		return "disconnected"
+	case EventKicked:
+		return "kicked"
+	case EventHeartbeatTimeout:
+		return "heartbeat_timeout"
+	}
+	return "unknown"
+}
+
+// Event describes a lifecycle change of the client connection.
+type Event struct {
+	Type    EventType
+	Err     error  // EventDisconnected 的原因，可以用 errors.Is 区分
+	Reason  string // EventKicked 的原因
+	Resumed bool   // EventHandshake 是否恢复了服务端 session
+}
+
+// Causes of EventDisconnected besides read errors and failed handshakes.
+var (
+	ErrClosed           = errors.New("client closed")
+	ErrClosedByServer   = errors.New("connection closed by server")
+	ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+	ErrKicked           = errors.New("kicked")
+	ErrInvalidPackage   = errors.New("invalid package")
+)
+
+// OnEvent sets the callback for lifecycle events. It runs on the
+// goroutine that observed the change and must not block.
+func (c *PinusTcpClient) OnEvent(fn func(Event)) {
+	c.connMu.Lock()
+	c.onEvent = fn
+	c.connMu.Unlock()
+}
+
+func (c *PinusTcpClient) emit(ev Event) {
+	c.connMu.Lock()
+	onEvent := c.onEvent
+	c.connMu.Unlock()
+	if onEvent != nil {
+		onEvent(ev)
+	}
+}
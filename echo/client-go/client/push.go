@@ -0,0 +1,128 @@
+package client
+
+import (
+	"encoding/json"
+	"path"
+	"sync"
+	"sync/atomic"
+)
+
+// Push is a message pushed by the server.
+type Push struct {
+	Route string
+	Body  interface{} // 按握手协商的编码解出，json / msgpack 下通常是 map[string]interface{}
+}
+
+// Decode converts the body into v, a pointer to a struct or map with json tags.
+func (p *Push) Decode(v interface{}) error {
+	data, err := json.Marshal(p.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// pushSub is one OnPush subscription.
+type pushSub struct {
+	pattern string
+	fn      func(*Push)
+}
+
+// pushSubs is copy-on-write: the read goroutine dispatches without a lock
+// and handlers can subscribe or unsubscribe from inside a handler.
+type pushSubs struct {
+	mu   sync.Mutex
+	subs atomic.Pointer[[]*pushSub]
+}
+
+// OnPush calls fn for every push whose route matches pattern, and returns
+// a function that removes the subscription. Patterns use path.Match
+// syntax, so "snake.*" matches every route starting with "snake." and "*"
+// matches all. fn runs on the read goroutine in subscription order and
+// must not block.
+func (c *PinusTcpClient) OnPush(pattern string, fn func(*Push)) (unsubscribe func()) {
+	sub := &pushSub{pattern: pattern, fn: fn}
+
+	c.pushSubs.mu.Lock()
+	var subs []*pushSub
+	if old := c.pushSubs.subs.Load(); old != nil {
+		subs = append(subs, *old...)
+	}
+	subs = append(subs, sub)
+	c.pushSubs.subs.Store(&subs)
+	c.pushSubs.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.pushSubs.mu.Lock()
+			defer c.pushSubs.mu.Unlock()
+			old := c.pushSubs.subs.Load()
+			subs := make([]*pushSub, 0, len(*old))
+			for _, s := range *old {
+				if s != sub {
+					subs = append(subs, s)
+				}
+			}
+			c.pushSubs.subs.Store(&subs)
+		})
+	}
+}
+
+// PushStream returns a channel of the pushes matching pattern, for
+// callers that would rather select than register a callback. When the
+// channel is full new pushes are dropped and logged. Calling stop removes
+// the subscription and closes the channel.
+func (c *PinusTcpClient) PushStream(pattern string, size int) (pushes <-chan *Push, stop func()) {
+	ch := make(chan *Push, size)
+	var mu sync.Mutex
+	closed := false
+
+	unsubscribe := c.OnPush(pattern, func(p *Push) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- p:
+		default:
+			sampledLogger.Warn("Push stream full, dropping push", "uid", c.userId, "route", p.Route)
+		}
+	})
+	return ch, func() {
+		unsubscribe()
+		mu.Lock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+		mu.Unlock()
+	}
+}
+
+// OnPushTyped is OnPush with the body decoded into a T. Pushes whose body
+// does not decode are logged and skipped.
+func OnPushTyped[T interface{}](c *PinusTcpClient, pattern string, fn func(body *T)) (unsubscribe func()) {
+	return c.OnPush(pattern, func(p *Push) {
+		body := new(T)
+		if err := p.Decode(body); err != nil {
+			sampledLogger.Warn("Failed to decode push", "uid", c.userId, "route", p.Route, "err", err)
+			return
+		}
+		fn(body)
+	})
+}
+
+// dispatchPush hands a push to every matching subscription.
+func (c *PinusTcpClient) dispatchPush(p *Push) {
+	subs := c.pushSubs.subs.Load()
+	if subs == nil {
+		return
+	}
+	for _, sub := range *subs {
+		if matched, _ := path.Match(sub.pattern, p.Route); matched {
+			sub.fn(p)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package codec
+
+import (
+	"sync"
+)
+
+// Codec encodes and decodes message bodies. The route is passed in so
+// that schema based codecs such as protobuf can pick a message type.
+type Codec interface {
+	Name() string
+	Encode(route string, v interface{}) ([]byte, error)
+	Decode(route string, data []byte) (interface{}, error)
+}
+
+// Appender is implemented by codecs that can encode straight into a
+// caller supplied buffer.
+type Appender interface {
+	AppendEncode(dst []byte, route string, v interface{}) ([]byte, error)
+}
+
+// AppendEncode appends the encoding of v to dst, skipping the
+// intermediate buffer when c is an Appender.
+func AppendEncode(c Codec, dst []byte, route string, v interface{}) ([]byte, error) {
+	if a, ok := c.(Appender); ok {
+		return a.AppendEncode(dst, route, v)
+	}
+	b, err := c.Encode(route, v)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, b...), nil
+}
+
+var (
+	codecs     = make(map[string]Codec)
+	codecsLock sync.RWMutex
+)
+
+func init() {
+	Register(JSON)
+	Register(MsgPack)
+}
+
+// Register makes c available for negotiation under c.Name().
+func Register(c Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[c.Name()] = c
+}
+
+func Get(name string) (Codec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// Negotiate returns the first codec in prefs that is registered, or def
+// if the client did not ask for anything we support.
+func Negotiate(prefs []string, def Codec) Codec {
+	for _, name := range prefs {
+		if c, ok := Get(name); ok {
+			return c
+		}
+	}
+	return def
+}
@@ -0,0 +1,365 @@
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+type msgpackCodec struct{}
+
+// MsgPack encodes bodies as MessagePack. Integers decode as int64,
+// floats as float64 and maps as map[string]interface{}.
+var MsgPack Codec = msgpackCodec{}
+
+var errShortBuffer = errors.New("msgpack: unexpected end of data")
+
+// msgpackMaxDepth is the deepest nesting of arrays and maps Decode
+// accepts. 消息体来自网络，不限制深度时几 KB 的嵌套就能耗尽栈
+const msgpackMaxDepth = 100
+
+var errTooDeep = fmt.Errorf("msgpack: nesting deeper than %d", msgpackMaxDepth)
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Encode(route string, v interface{}) ([]byte, error) {
+	return AppendMsgPack(nil, v)
+}
+
+func (msgpackCodec) AppendEncode(dst []byte, route string, v interface{}) ([]byte, error) {
+	return AppendMsgPack(dst, v)
+}
+
+func (msgpackCodec) Decode(route string, data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	v, n, err := decodeMsgPack(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(data) {
+		return nil, fmt.Errorf("msgpack: %d trailing bytes", len(data)-n)
+	}
+	return v, nil
+}
+
+// AppendMsgPack appends the MessagePack encoding of v to buf. Types other
+// than the JSON-like basics go through encoding/json first.
+func AppendMsgPack(buf []byte, v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if val {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case int:
+		return appendMsgPackInt(buf, int64(val)), nil
+	case int8:
+		return appendMsgPackInt(buf, int64(val)), nil
+	case int16:
+		return appendMsgPackInt(buf, int64(val)), nil
+	case int32:
+		return appendMsgPackInt(buf, int64(val)), nil
+	case int64:
+		return appendMsgPackInt(buf, val), nil
+	case uint:
+		return appendMsgPackUint(buf, uint64(val)), nil
+	case uint8:
+		return appendMsgPackUint(buf, uint64(val)), nil
+	case uint16:
+		return appendMsgPackUint(buf, uint64(val)), nil
+	case uint32:
+		return appendMsgPackUint(buf, uint64(val)), nil
+	case uint64:
+		return appendMsgPackUint(buf, val), nil
+	case float32:
+		buf = append(buf, 0xca)
+		return binary.BigEndian.AppendUint32(buf, math.Float32bits(val)), nil
+	case float64:
+		buf = append(buf, 0xcb)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(val)), nil
+	case string:
+		return appendMsgPackString(buf, val), nil
+	case []byte:
+		return appendMsgPackBin(buf, val), nil
+	case []interface{}:
+		buf = appendMsgPackHeader(buf, len(val), 0x90, 16, 0xdc, 0xdd)
+		var err error
+		for _, item := range val {
+			if buf, err = AppendMsgPack(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		buf = appendMsgPackHeader(buf, len(val), 0x80, 16, 0xde, 0xdf)
+		var err error
+		for k, item := range val {
+			buf = appendMsgPackString(buf, k)
+			if buf, err = AppendMsgPack(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+
+	// Structs, typed slices and maps: normalize through JSON
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return AppendMsgPack(buf, generic)
+}
+
+func appendMsgPackInt(buf []byte, n int64) []byte {
+	switch {
+	case n >= 0:
+		return appendMsgPackUint(buf, uint64(n))
+	case n >= -32:
+		return append(buf, byte(n))
+	case n >= math.MinInt8:
+		return append(buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		buf = append(buf, 0xd1)
+		return binary.BigEndian.AppendUint16(buf, uint16(n))
+	case n >= math.MinInt32:
+		buf = append(buf, 0xd2)
+		return binary.BigEndian.AppendUint32(buf, uint32(n))
+	}
+	buf = append(buf, 0xd3)
+	return binary.BigEndian.AppendUint64(buf, uint64(n))
+}
+
+func appendMsgPackUint(buf []byte, n uint64) []byte {
+	switch {
+	case n <= 0x7f:
+		return append(buf, byte(n))
+	case n <= math.MaxUint8:
+		return append(buf, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		buf = append(buf, 0xcd)
+		return binary.BigEndian.AppendUint16(buf, uint16(n))
+	case n <= math.MaxUint32:
+		buf = append(buf, 0xce)
+		return binary.BigEndian.AppendUint32(buf, uint32(n))
+	}
+	buf = append(buf, 0xcf)
+	return binary.BigEndian.AppendUint64(buf, n)
+}
+
+func appendMsgPackString(buf []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = append(buf, 0xda)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, 0xdb)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(n))
+	}
+	return append(buf, s...)
+}
+
+func appendMsgPackBin(buf []byte, b []byte) []byte {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		buf = append(buf, 0xc5)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, 0xc6)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(n))
+	}
+	return append(buf, b...)
+}
+
+// appendMsgPackHeader writes an array or map header: the fix form for
+// small sizes, then the 16 and 32 bit forms.
+func appendMsgPackHeader(buf []byte, n int, fix byte, fixMax int, code16, code32 byte) []byte {
+	switch {
+	case n < fixMax:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		buf = append(buf, code16)
+		return binary.BigEndian.AppendUint16(buf, uint16(n))
+	}
+	buf = append(buf, code32)
+	return binary.BigEndian.AppendUint32(buf, uint32(n))
+}
+
+// decodeMsgPack decodes one value and returns it with the bytes consumed.
+// depth is the number of arrays and maps the value is nested in.
+func decodeMsgPack(data []byte, depth int) (interface{}, int, error) {
+	if len(data) < 1 {
+		return nil, 0, errShortBuffer
+	}
+	c := data[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), 1, nil
+	case c >= 0xe0:
+		return int64(int8(c)), 1, nil
+	case c&0xe0 == 0xa0:
+		return decodeMsgPackString(data, 1, int(c&0x1f))
+	case c&0xf0 == 0x90:
+		return decodeMsgPackArray(data, 1, int(c&0x0f), depth)
+	case c&0xf0 == 0x80:
+		return decodeMsgPackMap(data, 1, int(c&0x0f), depth)
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, 1, nil
+	case 0xc2:
+		return false, 1, nil
+	case 0xc3:
+		return true, 1, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		size := 1 << (c - 0xcc)
+		if len(data) < 1+size {
+			return nil, 0, errShortBuffer
+		}
+		u := readUint(data[1 : 1+size])
+		if u > math.MaxInt64 {
+			return u, 1 + size, nil
+		}
+		return int64(u), 1 + size, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		if len(data) < 1+size {
+			return nil, 0, errShortBuffer
+		}
+		u := readUint(data[1 : 1+size])
+		shift := 64 - 8*size
+		return int64(u<<shift) >> shift, 1 + size, nil
+	case 0xca:
+		if len(data) < 5 {
+			return nil, 0, errShortBuffer
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data[1:]))), 5, nil
+	case 0xcb:
+		if len(data) < 9 {
+			return nil, 0, errShortBuffer
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data[1:])), 9, nil
+	case 0xd9, 0xda, 0xdb:
+		n, hdr, err := readLength(data, c-0xd9)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMsgPackString(data, hdr, n)
+	case 0xc4, 0xc5, 0xc6:
+		n, hdr, err := readLength(data, c-0xc4)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(data) < hdr+n {
+			return nil, 0, errShortBuffer
+		}
+		b := make([]byte, n)
+		copy(b, data[hdr:hdr+n])
+		return b, hdr + n, nil
+	case 0xdc, 0xdd:
+		n, hdr, err := readLength(data, c-0xdc+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMsgPackArray(data, hdr, n, depth)
+	case 0xde, 0xdf:
+		n, hdr, err := readLength(data, c-0xde+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMsgPackMap(data, hdr, n, depth)
+	}
+	return nil, 0, fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+}
+
+// readLength reads a 1, 2 or 4 byte length (sizeClass 0, 1, 2) following
+// the type byte.
+func readLength(data []byte, sizeClass byte) (int, int, error) {
+	size := 1 << sizeClass
+	if len(data) < 1+size {
+		return 0, 0, errShortBuffer
+	}
+	return int(readUint(data[1 : 1+size])), 1 + size, nil
+}
+
+func readUint(b []byte) uint64 {
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u
+}
+
+func decodeMsgPackString(data []byte, offset, n int) (interface{}, int, error) {
+	if len(data) < offset+n {
+		return nil, 0, errShortBuffer
+	}
+	return string(data[offset : offset+n]), offset + n, nil
+}
+
+func decodeMsgPackArray(data []byte, offset, n, depth int) (interface{}, int, error) {
+	if depth >= msgpackMaxDepth {
+		return nil, 0, errTooDeep
+	}
+	if n > len(data)-offset {
+		return nil, 0, errShortBuffer
+	}
+	arr := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		v, used, err := decodeMsgPack(data[offset:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		arr[i] = v
+		offset += used
+	}
+	return arr, offset, nil
+}
+
+func decodeMsgPackMap(data []byte, offset, n, depth int) (interface{}, int, error) {
+	if depth >= msgpackMaxDepth {
+		return nil, 0, errTooDeep
+	}
+	if n > len(data)-offset {
+		return nil, 0, errShortBuffer
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, used, err := decodeMsgPack(data[offset:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += used
+		v, used, err := decodeMsgPack(data[offset:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += used
+		if key, ok := k.(string); ok {
+			m[key] = v
+		} else {
+			m[fmt.Sprint(k)] = v
+		}
+	}
+	return m, offset, nil
+}
@@ -0,0 +1,55 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMsgPackRoundTrip(t *testing.T) {
+	v := map[string]interface{}{
+		"code": int64(0),
+		"msg":  map[string]interface{}{"data": "world1", "serverReqId": int64(12)},
+		"list": []interface{}{nil, true, false, int64(-1), 1.5, "中文", []byte("raw")},
+	}
+	data, err := MsgPack.Encode("", v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := MsgPack.Decode("", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Fatalf("Decode = %#v, want %#v", got, v)
+	}
+	for n := 1; n < len(data); n++ {
+		if _, err := MsgPack.Decode("", data[:n]); err == nil {
+			t.Fatalf("Decode of %d of %d bytes did not fail", n, len(data))
+		}
+	}
+}
+
+// nestedMsgPack is depth arrays of one element around an empty map.
+func nestedMsgPack(depth int) []byte {
+	return append(bytes.Repeat([]byte{0x91}, depth), 0x80)
+}
+
+func TestMsgPackMaxDepth(t *testing.T) {
+	if _, err := MsgPack.Decode("", nestedMsgPack(msgpackMaxDepth-1)); err != nil {
+		t.Fatalf("Decode at the depth limit: %v", err)
+	}
+	if _, err := MsgPack.Decode("", nestedMsgPack(msgpackMaxDepth)); !errors.Is(err, errTooDeep) {
+		t.Fatalf("Decode past the depth limit = %v, want %v", err, errTooDeep)
+	}
+	// 很深的嵌套在读完之前就被拒绝，不会耗尽栈
+	if _, err := MsgPack.Decode("", nestedMsgPack(1<<20)); !errors.Is(err, errTooDeep) {
+		t.Fatalf("Decode of 1M nested arrays = %v, want %v", err, errTooDeep)
+	}
+	// 16 位长度的数组和映射同样计入深度
+	deep := append(bytes.Repeat([]byte{0xdc, 0, 1, 0xde, 0, 1, 0xa1, 'k'}, msgpackMaxDepth/2+1), 0xc0)
+	if _, err := MsgPack.Decode("", deep); !errors.Is(err, errTooDeep) {
+		t.Fatalf("Decode of nested array16 / map16 = %v, want %v", err, errTooDeep)
+	}
+}
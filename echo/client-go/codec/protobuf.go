@@ -0,0 +1,480 @@
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// pinus (pomelo-protobuf) wire types
+var protoWireTypes = map[string]uint64{
+	"uInt32": 0,
+	"sInt32": 0,
+	"int32":  0,
+	"double": 1,
+	"string": 2,
+	"float":  5,
+}
+
+var errProtoShort = errors.New("protobuf: unexpected end of data")
+
+// protoMaxDepth is the deepest nesting of messages Decode accepts. 消息类型
+// 可以引用自己，深度和 msgpack 一样要有上限
+const protoMaxDepth = 100
+
+var errProtoTooDeep = fmt.Errorf("protobuf: nesting deeper than %d", protoMaxDepth)
+
+type protoField struct {
+	option string // required, optional or repeated
+	typ    string
+	name   string
+	tag    uint64
+}
+
+type protoMessage struct {
+	fields   []*protoField // sorted by tag
+	tags     map[uint64]*protoField
+	messages map[string]*protoMessage
+	parent   *protoMessage
+}
+
+// Protobuf implements the pinus protobuf body encoding. Routes without a
+// proto definition are handled by the fallback codec, the same way pinus
+// falls back to JSON.
+type Protobuf struct {
+	encoders   map[string]*protoMessage
+	decoders   map[string]*protoMessage
+	encoderRaw map[string]interface{}
+	decoderRaw map[string]interface{}
+	fallback   Codec
+}
+
+// NewProtobuf builds a codec from pinus style proto definitions such as
+// {"connector.entryHandler.hello": {"required string data": 1}}.
+// encoderProtos describe what this side sends, decoderProtos what it
+// receives.
+func NewProtobuf(encoderProtos, decoderProtos map[string]interface{}, fallback Codec) (*Protobuf, error) {
+	if fallback == nil {
+		fallback = JSON
+	}
+	p := &Protobuf{
+		encoderRaw: encoderProtos,
+		decoderRaw: decoderProtos,
+		fallback:   fallback,
+	}
+	var err error
+	if p.encoders, err = parseProtos(encoderProtos); err != nil {
+		return nil, err
+	}
+	if p.decoders, err = parseProtos(decoderProtos); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Protobuf) Name() string {
+	return "protobuf"
+}
+
+// Protos returns the raw definitions, for sending in the handshake.
+func (p *Protobuf) Protos() (encoder, decoder map[string]interface{}) {
+	return p.encoderRaw, p.decoderRaw
+}
+
+// Check reports whether route has a proto definition on the given side.
+func (p *Protobuf) Check(encode bool, route string) bool {
+	if encode {
+		_, ok := p.encoders[route]
+		return ok
+	}
+	_, ok := p.decoders[route]
+	return ok
+}
+
+func (p *Protobuf) Encode(route string, v interface{}) ([]byte, error) {
+	msg, ok := p.encoders[route]
+	if !ok {
+		return p.fallback.Encode(route, v)
+	}
+	obj, err := toObject(v)
+	if err != nil {
+		return nil, err
+	}
+	return encodeProtoMessage(nil, msg, obj)
+}
+
+func (p *Protobuf) AppendEncode(dst []byte, route string, v interface{}) ([]byte, error) {
+	msg, ok := p.encoders[route]
+	if !ok {
+		return AppendEncode(p.fallback, dst, route, v)
+	}
+	obj, err := toObject(v)
+	if err != nil {
+		return dst, err
+	}
+	return encodeProtoMessage(dst, msg, obj)
+}
+
+func (p *Protobuf) Decode(route string, data []byte) (interface{}, error) {
+	msg, ok := p.decoders[route]
+	if !ok {
+		return p.fallback.Decode(route, data)
+	}
+	return decodeProtoMessage(data, msg, 0)
+}
+
+func parseProtos(raw map[string]interface{}) (map[string]*protoMessage, error) {
+	root := &protoMessage{messages: make(map[string]*protoMessage)}
+	routes := make(map[string]*protoMessage)
+	for key, value := range raw {
+		def, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(key, "message ") {
+			name := strings.TrimPrefix(key, "message ")
+			msg, err := parseProtoMessage(def, root)
+			if err != nil {
+				return nil, err
+			}
+			root.messages[name] = msg
+			continue
+		}
+		msg, err := parseProtoMessage(def, root)
+		if err != nil {
+			return nil, fmt.Errorf("protobuf: route %s: %w", key, err)
+		}
+		routes[key] = msg
+	}
+	return routes, nil
+}
+
+func parseProtoMessage(def map[string]interface{}, parent *protoMessage) (*protoMessage, error) {
+	msg := &protoMessage{
+		tags:     make(map[uint64]*protoField),
+		messages: make(map[string]*protoMessage),
+		parent:   parent,
+	}
+	for key, value := range def {
+		parts := strings.Fields(key)
+		if len(parts) == 2 && parts[0] == "message" {
+			sub, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("bad message definition %q", key)
+			}
+			nested, err := parseProtoMessage(sub, msg)
+			if err != nil {
+				return nil, err
+			}
+			msg.messages[parts[1]] = nested
+			continue
+		}
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("bad field definition %q", key)
+		}
+		tag, ok := value.(float64)
+		if !ok || tag <= 0 {
+			return nil, fmt.Errorf("bad tag for field %q", key)
+		}
+		field := &protoField{option: parts[0], typ: parts[1], name: parts[2], tag: uint64(tag)}
+		msg.fields = append(msg.fields, field)
+		msg.tags[field.tag] = field
+	}
+	sort.Slice(msg.fields, func(i, j int) bool { return msg.fields[i].tag < msg.fields[j].tag })
+	return msg, nil
+}
+
+// lookup resolves a message type from the innermost scope outwards.
+func (m *protoMessage) lookup(typ string) *protoMessage {
+	for scope := m; scope != nil; scope = scope.parent {
+		if msg, ok := scope.messages[typ]; ok {
+			return msg
+		}
+	}
+	return nil
+}
+
+func isSimpleProtoType(typ string) bool {
+	switch typ {
+	case "uInt32", "sInt32", "int32", "float", "double":
+		return true
+	}
+	return false
+}
+
+func appendProtoTag(buf []byte, typ string, tag uint64) []byte {
+	wire, ok := protoWireTypes[typ]
+	if !ok {
+		wire = 2
+	}
+	return binary.AppendUvarint(buf, tag<<3|wire)
+}
+
+func encodeProtoMessage(buf []byte, msg *protoMessage, obj map[string]interface{}) ([]byte, error) {
+	var err error
+	for _, field := range msg.fields {
+		value, ok := obj[field.name]
+		if !ok || value == nil {
+			if field.option == "required" {
+				return nil, fmt.Errorf("protobuf: missing required field %s", field.name)
+			}
+			continue
+		}
+		if field.option != "repeated" {
+			buf = appendProtoTag(buf, field.typ, field.tag)
+			if buf, err = encodeProtoProp(buf, msg, field.typ, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("protobuf: field %s is not an array", field.name)
+		}
+		if len(items) == 0 {
+			continue
+		}
+		if isSimpleProtoType(field.typ) {
+			// pinus packs simple repeated fields as tag, count, values
+			buf = appendProtoTag(buf, field.typ, field.tag)
+			buf = binary.AppendUvarint(buf, uint64(len(items)))
+			for _, item := range items {
+				if buf, err = encodeProtoProp(buf, msg, field.typ, item); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		for _, item := range items {
+			buf = appendProtoTag(buf, field.typ, field.tag)
+			if buf, err = encodeProtoProp(buf, msg, field.typ, item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf, nil
+}
+
+func encodeProtoProp(buf []byte, scope *protoMessage, typ string, value interface{}) ([]byte, error) {
+	switch typ {
+	case "uInt32":
+		return binary.AppendUvarint(buf, uint64(uint32(toFloat(value)))), nil
+	case "int32", "sInt32":
+		n := int64(toFloat(value))
+		return binary.AppendUvarint(buf, uint64(n<<1)^uint64(n>>63)), nil
+	case "float":
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(toFloat(value)))), nil
+	case "double":
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(toFloat(value))), nil
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			s = fmt.Sprint(value)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(s)))
+		return append(buf, s...), nil
+	}
+
+	sub := scope.lookup(typ)
+	if sub == nil {
+		return nil, fmt.Errorf("protobuf: unknown type %s", typ)
+	}
+	obj, err := toObject(value)
+	if err != nil {
+		return nil, err
+	}
+	body, err := encodeProtoMessage(nil, sub, obj)
+	if err != nil {
+		return nil, err
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(body)))
+	return append(buf, body...), nil
+}
+
+// decodeProtoMessage decodes msg, nested in depth other messages.
+func decodeProtoMessage(data []byte, msg *protoMessage, depth int) (map[string]interface{}, error) {
+	if depth >= protoMaxDepth {
+		return nil, errProtoTooDeep
+	}
+	obj := make(map[string]interface{})
+	offset := 0
+	for offset < len(data) {
+		head, n := binary.Uvarint(data[offset:])
+		if n <= 0 {
+			return nil, errProtoShort
+		}
+		offset += n
+		field, ok := msg.tags[head>>3]
+		if !ok {
+			skipped, err := skipProtoField(data[offset:], head&7)
+			if err != nil {
+				return nil, err
+			}
+			offset += skipped
+			continue
+		}
+
+		if field.option != "repeated" {
+			v, used, err := decodeProtoProp(data[offset:], msg, field.typ, depth)
+			if err != nil {
+				return nil, err
+			}
+			obj[field.name] = v
+			offset += used
+			continue
+		}
+
+		items, _ := obj[field.name].([]interface{})
+		if isSimpleProtoType(field.typ) {
+			count, n := binary.Uvarint(data[offset:])
+			if n <= 0 {
+				return nil, errProtoShort
+			}
+			offset += n
+			for i := uint64(0); i < count; i++ {
+				v, used, err := decodeProtoProp(data[offset:], msg, field.typ, depth)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, v)
+				offset += used
+			}
+		} else {
+			v, used, err := decodeProtoProp(data[offset:], msg, field.typ, depth)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			offset += used
+		}
+		obj[field.name] = items
+	}
+	return obj, nil
+}
+
+// decodeProtoProp decodes one value. Numbers come back as float64 to
+// match what encoding/json produces.
+func decodeProtoProp(data []byte, scope *protoMessage, typ string, depth int) (interface{}, int, error) {
+	switch typ {
+	case "uInt32":
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, 0, errProtoShort
+		}
+		return float64(uint32(v)), n, nil
+	case "int32", "sInt32":
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, 0, errProtoShort
+		}
+		return float64(int64(v>>1) ^ -int64(v&1)), n, nil
+	case "float":
+		if len(data) < 4 {
+			return nil, 0, errProtoShort
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data))), 4, nil
+	case "double":
+		if len(data) < 8 {
+			return nil, 0, errProtoShort
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), 8, nil
+	}
+
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return nil, 0, errProtoShort
+	}
+	body := data[n : n+int(size)]
+	if typ == "string" {
+		return string(body), n + int(size), nil
+	}
+	sub := scope.lookup(typ)
+	if sub == nil {
+		return nil, 0, fmt.Errorf("protobuf: unknown type %s", typ)
+	}
+	obj, err := decodeProtoMessage(body, sub, depth+1)
+	if err != nil {
+		return nil, 0, err
+	}
+	return obj, n + int(size), nil
+}
+
+func skipProtoField(data []byte, wire uint64) (int, error) {
+	switch wire {
+	case 0:
+		if _, n := binary.Uvarint(data); n > 0 {
+			return n, nil
+		}
+	case 1:
+		if len(data) >= 8 {
+			return 8, nil
+		}
+	case 2:
+		size, n := binary.Uvarint(data)
+		if n > 0 && uint64(len(data)-n) >= size {
+			return n + int(size), nil
+		}
+	case 5:
+		if len(data) >= 4 {
+			return 4, nil
+		}
+	default:
+		return 0, fmt.Errorf("protobuf: unknown wire type %d", wire)
+	}
+	return 0, errProtoShort
+}
+
+// toObject turns maps and structs into a map[string]interface{}.
+func toObject(v interface{}) (map[string]interface{}, error) {
+	if obj, ok := v.(map[string]interface{}); ok {
+		return obj, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("protobuf: body is not an object: %w", err)
+	}
+	return obj, nil
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case int:
+		return float64(n)
+	case int8:
+		return float64(n)
+	case int16:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint:
+		return float64(n)
+	case uint8:
+		return float64(n)
+	case uint16:
+		return float64(n)
+	case uint32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case bool:
+		if n {
+			return 1
+		}
+	}
+	return 0
+}
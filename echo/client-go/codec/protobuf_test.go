@@ -0,0 +1,360 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// 与 config/clientProtos.json、config/serverProtos.json 相同，握手时下发给客户端
+const (
+	helloClientProtos = `{"connector.entryHandler.hello": {"required string data": 1}}`
+	helloServerProtos = `{"connector.entryHandler.hello": {
+		"required sInt32 code": 1,
+		"message Msg": {"optional string data": 1, "optional uInt32 serverReqId": 2},
+		"optional Msg msg": 2
+	}}`
+)
+
+// richProtos covers every type: nested messages two levels deep, a message
+// defined at the top level, and repeated simple, string and message fields.
+const richProtos = `{
+	"message Point": {"required sInt32 x": 1, "required sInt32 y": 2},
+	"area.move": {
+		"required uInt32 id": 1,
+		"optional int32 delta": 2,
+		"optional float speed": 3,
+		"optional double at": 4,
+		"optional string name": 5,
+		"optional Point to": 6,
+		"repeated uInt32 ids": 7,
+		"repeated sInt32 deltas": 8,
+		"repeated double weights": 9,
+		"repeated string tags": 10,
+		"repeated Point path": 11,
+		"message Unit": {
+			"required string name": 1,
+			"message Stats": {"optional uInt32 hp": 1, "repeated float buffs": 2},
+			"optional Stats stats": 2,
+			"optional Point at": 3
+		},
+		"repeated Unit units": 12
+	},
+	"tree": {"message Node": {"optional uInt32 v": 1, "optional Node next": 2}, "optional Node root": 1}
+}`
+
+func protos(t testing.TB, raw string) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func richCodec(t testing.TB) *Protobuf {
+	t.Helper()
+	p, err := NewProtobuf(protos(t, richProtos), protos(t, richProtos), JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// richMove uses values that survive float32 for speed and weights.
+func richMove() map[string]interface{} {
+	return map[string]interface{}{
+		"id":      float64(math.MaxUint32),
+		"delta":   float64(math.MinInt32),
+		"speed":   1.5,
+		"at":      1e300,
+		"name":    "中文 name",
+		"to":      map[string]interface{}{"x": -1.0, "y": 64.0},
+		"ids":     []interface{}{0.0, 1.0, 300.0},
+		"deltas":  []interface{}{-1.0, 0.0, float64(math.MaxInt32)},
+		"weights": []interface{}{0.25, -2.0},
+		"tags":    []interface{}{"a", "", "c"},
+		"path": []interface{}{
+			map[string]interface{}{"x": 1.0, "y": 2.0},
+			map[string]interface{}{"x": -3.0, "y": -4.0},
+		},
+		"units": []interface{}{
+			map[string]interface{}{
+				"name":  "knight",
+				"stats": map[string]interface{}{"hp": 100.0, "buffs": []interface{}{0.5, 2.0}},
+				"at":    map[string]interface{}{"x": 0.0, "y": 0.0},
+			},
+			map[string]interface{}{"name": "archer"},
+		},
+	}
+}
+
+func TestProtobufHandshakeRoundTrip(t *testing.T) {
+	// 客户端按 clientProtos 编码、按 serverProtos 解码，服务端相反
+	client, err := NewProtobuf(protos(t, helloClientProtos), protos(t, helloServerProtos), JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewProtobuf(protos(t, helloServerProtos), protos(t, helloClientProtos), JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const route = "connector.entryHandler.hello"
+
+	req := map[string]interface{}{"data": "ab"}
+	data, err := client.Encode(route, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1<<3 | 2, 2, 'a', 'b'}; !bytes.Equal(data, want) {
+		t.Fatalf("request encodes as % x, want % x", data, want)
+	}
+	got, err := server.Decode(route, data)
+	if err != nil || !reflect.DeepEqual(got, req) {
+		t.Fatalf("server decodes %#v, %v, want %#v", got, err, req)
+	}
+
+	resp := map[string]interface{}{"code": 0, "msg": map[string]interface{}{"data": "ab", "serverReqId": 12}}
+	data, err = AppendEncode(server, []byte("hdr"), route, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:3]) != "hdr" {
+		t.Fatalf("AppendEncode overwrote the prefix: % x", data)
+	}
+	got, err = client.Decode(route, data[3:])
+	want := map[string]interface{}{"code": 0.0, "msg": map[string]interface{}{"data": "ab", "serverReqId": 12.0}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("client decodes %#v, %v, want %#v", got, err, want)
+	}
+
+	// 没有定义的路由交给 JSON
+	data, err = client.Encode("chat.send", map[string]interface{}{"text": "hi"})
+	if err != nil || string(data) != `{"text":"hi"}` {
+		t.Fatalf("fallback encodes %s, %v", data, err)
+	}
+	if !client.Check(true, route) || client.Check(true, "chat.send") || !client.Check(false, route) {
+		t.Fatal("Check does not match the protos")
+	}
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	p := richCodec(t)
+	move := richMove()
+	data, err := p.Encode("area.move", move)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Decode("area.move", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, move) {
+		a, _ := json.Marshal(got)
+		b, _ := json.Marshal(move)
+		t.Fatalf("Decode = %s\nwant     %s", a, b)
+	}
+
+	// 结构体先转成 map，字段为空的 optional 字段不编码，空的 repeated 字段也不编码
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+	data, err = p.Encode("area.move", struct {
+		ID   uint32        `json:"id"`
+		To   point         `json:"to"`
+		Tags []interface{} `json:"tags"`
+	}{ID: 7, To: point{-2, 3}, Tags: []interface{}{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = p.Decode("area.move", data)
+	want := map[string]interface{}{"id": 7.0, "to": map[string]interface{}{"x": -2.0, "y": 3.0}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("Decode = %#v, %v, want %#v", got, err, want)
+	}
+}
+
+func TestProtobufVarints(t *testing.T) {
+	p := richCodec(t)
+	tests := []struct {
+		field string
+		value float64
+		want  []byte // 字段头之后的字节
+	}{
+		{"id", 0, []byte{0}},
+		{"id", 127, []byte{0x7f}},
+		{"id", 128, []byte{0x80, 0x01}},
+		{"id", 300, []byte{0xac, 0x02}},
+		{"id", math.MaxUint32, []byte{0xff, 0xff, 0xff, 0xff, 0x0f}},
+		// zigzag：0、-1、1、-2 … 编码为 0、1、2、3 …
+		{"delta", 0, []byte{0}},
+		{"delta", -1, []byte{1}},
+		{"delta", 1, []byte{2}},
+		{"delta", -64, []byte{0x7f}},
+		{"delta", 64, []byte{0x80, 0x01}},
+		{"delta", math.MaxInt32, []byte{0xfe, 0xff, 0xff, 0xff, 0x0f}},
+		{"delta", math.MinInt32, []byte{0xff, 0xff, 0xff, 0xff, 0x0f}},
+	}
+	for _, tt := range tests {
+		body := map[string]interface{}{"id": 1.0, tt.field: tt.value}
+		data, err := p.Encode("area.move", body)
+		if err != nil {
+			t.Fatalf("%s=%v: %v", tt.field, tt.value, err)
+		}
+		// id 是第一个字段，delta 跟在 id=1 之后
+		prefix := []byte{1 << 3}
+		if tt.field == "delta" {
+			prefix = []byte{1 << 3, 1, 2 << 3}
+		}
+		if want := append(prefix, tt.want...); !bytes.Equal(data, want) {
+			t.Errorf("%s=%v encodes as % x, want % x", tt.field, tt.value, data, want)
+		}
+		got, err := p.Decode("area.move", data)
+		if err != nil || got.(map[string]interface{})[tt.field] != tt.value {
+			t.Errorf("%s=%v decodes as %#v, %v", tt.field, tt.value, got, err)
+		}
+	}
+
+	// pinus 的 repeated 简单类型：一个字段头，然后是个数和各个值
+	data, err := p.Encode("area.move", map[string]interface{}{"id": 1, "ids": []interface{}{1, 300}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1 << 3, 1, 7 << 3, 2, 1, 0xac, 0x02}; !bytes.Equal(data, want) {
+		t.Fatalf("repeated uInt32 encodes as % x, want % x", data, want)
+	}
+}
+
+func TestProtobufMalformed(t *testing.T) {
+	p := richCodec(t)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated head", []byte{0x80}},
+		{"head overflows", bytes.Repeat([]byte{0xff}, 11)},
+		{"truncated varint", []byte{1 << 3, 0x80}},
+		{"truncated float", []byte{3<<3 | 5, 0, 0}},
+		{"truncated double", []byte{4<<3 | 1, 0, 0, 0, 0, 0, 0, 0}},
+		{"string past the end", []byte{5<<3 | 2, 5, 'a'}},
+		{"string length overflows", append([]byte{5<<3 | 2}, bytes.Repeat([]byte{0xff}, 9)...)},
+		{"huge string length", []byte{5<<3 | 2, 0xff, 0xff, 0xff, 0xff, 0x0f, 'a'}},
+		{"nested past the end", []byte{6<<3 | 2, 4, 1 << 3, 2}},
+		{"truncated nested", []byte{6<<3 | 2, 2, 1 << 3, 0x80}},
+		{"repeated count past the end", []byte{7<<3 | 0, 5, 1}},
+		{"huge repeated count", []byte{9<<3 | 1, 0xff, 0xff, 0xff, 0xff, 0x0f, 0}},
+		{"truncated repeated message", []byte{11<<3 | 2, 3, 1 << 3}},
+		{"unknown wire type", []byte{13<<3 | 3}},
+		{"unknown field past the end", []byte{13<<3 | 2, 3, 'a'}},
+		{"unknown fixed64 past the end", []byte{13<<3 | 1, 0}},
+	}
+	for _, tt := range tests {
+		if v, err := p.Decode("area.move", tt.data); err == nil {
+			t.Errorf("%s: Decode(% x) = %#v, want an error", tt.name, tt.data, v)
+		}
+	}
+
+	// 未知的字段按线类型跳过，16 以上的字段号字段头占两个字节
+	data := []byte{
+		13<<3 | 0, 0x96, 0x01,
+		14<<3 | 1, 1, 2, 3, 4, 5, 6, 7, 8,
+		15<<3 | 2, 2, 'x', 'y',
+		20<<3 | 5 | 0x80, 20 >> 4, 1, 2, 3, 4,
+		1 << 3, 42,
+	}
+	got, err := p.Decode("area.move", data)
+	if err != nil || !reflect.DeepEqual(got, map[string]interface{}{"id": 42.0}) {
+		t.Fatalf("Decode with unknown fields = %#v, %v", got, err)
+	}
+}
+
+func TestProtobufTruncationNeverPanics(t *testing.T) {
+	p := richCodec(t)
+	data, err := p.Encode("area.move", richMove())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 任意截断或损坏的输入只能返回错误或部分字段，不能 panic
+	for n := 0; n < len(data); n++ {
+		p.Decode("area.move", data[:n])
+	}
+	rng := rand.New(rand.NewSource(1))
+	buf := make([]byte, 64)
+	for i := 0; i < 20000; i++ {
+		n := rng.Intn(len(buf))
+		rng.Read(buf[:n])
+		p.Decode("area.move", buf[:n])
+		// 随机翻转编码结果中的一个字节
+		mutated := append([]byte(nil), data...)
+		mutated[rng.Intn(len(mutated))] ^= byte(1 + rng.Intn(255))
+		p.Decode("area.move", mutated)
+	}
+}
+
+func TestProtobufMaxDepth(t *testing.T) {
+	p := richCodec(t)
+	// depth 层嵌套的 Node，最里面 v=1
+	nested := func(depth int) []byte {
+		body := []byte{1 << 3, 1}
+		for i := 1; i < depth; i++ {
+			var hdr []byte
+			hdr = append(hdr, 2<<3|2)
+			hdr = appendUvarint(hdr, uint64(len(body)))
+			body = append(hdr, body...)
+		}
+		out := appendUvarint([]byte{1<<3 | 2}, uint64(len(body)))
+		return append(out, body...)
+	}
+	if _, err := p.Decode("tree", nested(protoMaxDepth-1)); err != nil {
+		t.Fatalf("Decode at the depth limit: %v", err)
+	}
+	if _, err := p.Decode("tree", nested(protoMaxDepth)); !errors.Is(err, errProtoTooDeep) {
+		t.Fatalf("Decode past the depth limit = %v, want %v", err, errProtoTooDeep)
+	}
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+func TestProtobufEncodeErrors(t *testing.T) {
+	p := richCodec(t)
+	tests := []struct {
+		name string
+		body interface{}
+	}{
+		{"missing required", map[string]interface{}{"delta": 1}},
+		{"missing required nested", map[string]interface{}{"id": 1, "to": map[string]interface{}{"x": 1}}},
+		{"repeated not an array", map[string]interface{}{"id": 1, "ids": 5}},
+		{"message not an object", map[string]interface{}{"id": 1, "to": "here"}},
+		{"body not an object", []interface{}{1, 2}},
+	}
+	for _, tt := range tests {
+		if data, err := p.Encode("area.move", tt.body); err == nil {
+			t.Errorf("%s: Encode = % x, want an error", tt.name, data)
+		}
+	}
+
+	if _, err := NewProtobuf(map[string]interface{}{"r": map[string]interface{}{"required uInt32": 1.0}}, nil, nil); err == nil {
+		t.Error("field without a name accepted")
+	}
+	if _, err := NewProtobuf(map[string]interface{}{"r": map[string]interface{}{"required uInt32 id": 0.0}}, nil, nil); err == nil {
+		t.Error("tag 0 accepted")
+	}
+	bad, err := NewProtobuf(map[string]interface{}{"r": map[string]interface{}{"optional Missing m": 1.0}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bad.Encode("r", map[string]interface{}{"m": map[string]interface{}{}}); err == nil {
+		t.Error("unknown message type encoded")
+	}
+}
@@ -0,0 +1,128 @@
+package load
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Arrival is the request schedule of an open-model run: iterations start
+// at these times whether or not earlier ones have finished. The rate moves
+// linearly from StartRate through the target of each stage in turn and
+// stays at the last target until the run ends.
+type Arrival struct {
+	StartRate float64 `json:"start_rate,omitempty"` // 每秒到达数
+	Stages    []Stage `json:"stages"`
+}
+
+// Stage moves the arrival rate to Target over Duration. A stage with no
+// duration jumps straight to Target.
+type Stage struct {
+	Target   float64  `json:"target"`
+	Duration Duration `json:"duration,omitempty"`
+}
+
+// ConstantRate is an arrival schedule of rate iterations per second.
+func ConstantRate(rate float64) *Arrival {
+	return &Arrival{StartRate: rate}
+}
+
+// RateProfile builds one of the common schedules peaking at rate:
+//
+//	constant  rate throughout
+//	ramp      0 to rate over period, then rate
+//	step      rate/5 more every period until rate
+//	spike     rate/10, rate for one period after the first, then rate/10 again
+func RateProfile(name string, rate float64, period time.Duration) (*Arrival, error) {
+	p := Duration(period)
+	switch name {
+	case "", "constant":
+		return ConstantRate(rate), nil
+	case "ramp":
+		return &Arrival{Stages: []Stage{{Target: rate, Duration: p}}}, nil
+	case "step":
+		a := &Arrival{StartRate: rate / 5}
+		for i := 1; i <= 5; i++ {
+			a.Stages = append(a.Stages, Stage{Target: rate * float64(i) / 5}, Stage{Target: rate * float64(i) / 5, Duration: p})
+		}
+		return a, nil
+	case "spike":
+		base := rate / 10
+		return &Arrival{StartRate: base, Stages: []Stage{
+			{Target: base, Duration: p},
+			{Target: rate},
+			{Target: rate, Duration: p},
+			{Target: base},
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown rate profile %q", name)
+}
+
+func (a *Arrival) check() error {
+	if a.StartRate < 0 {
+		return fmt.Errorf("negative start_rate")
+	}
+	for i, st := range a.Stages {
+		if st.Target < 0 || st.Duration < 0 {
+			return fmt.Errorf("stages[%d]: negative target or duration", i)
+		}
+	}
+	return nil
+}
+
+// schedule yields the intended start times of the arrivals, as offsets
+// from the start of the run.
+type schedule struct {
+	stages []Stage
+	rate   float64 // 当前阶段开始时的速率
+	stage  int
+	base   float64 // 当前阶段开始的时间（秒）
+	before float64 // 当前阶段之前应到达的数量
+	n      float64 // 已经给出的到达数
+}
+
+func newSchedule(a *Arrival) *schedule {
+	return &schedule{stages: a.Stages, rate: a.StartRate}
+}
+
+// next returns the offset of the next arrival, or false if the rate has
+// dropped to zero for good.
+func (s *schedule) next() (time.Duration, bool) {
+	s.n++
+	for {
+		// 阶段内的到达数是时间的二次函数 from*t + (to-from)*t²/(2d)，
+		// 解出第 n 个到达的时间
+		need := s.n - s.before
+		if s.stage >= len(s.stages) {
+			if s.rate <= 0 {
+				return 0, false
+			}
+			return seconds(s.base + need/s.rate), true
+		}
+		st := s.stages[s.stage]
+		from, to, d := s.rate, st.Target, time.Duration(st.Duration).Seconds()
+		if d > 0 {
+			a := (to - from) / (2 * d)
+			var t float64
+			switch {
+			case a == 0 && from > 0:
+				t = need / from
+			case a != 0:
+				t = (-from + math.Sqrt(from*from+4*a*need)) / (2 * a)
+			default:
+				t = math.Inf(1)
+			}
+			if !math.IsNaN(t) && t <= d {
+				return seconds(s.base + t), true
+			}
+			s.before += from*d + (to-from)*d/2
+			s.base += d
+		}
+		s.rate = to
+		s.stage++
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,126 @@
+package load
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// offsets returns the first n arrival offsets of a.
+func offsets(a *Arrival, n int) []time.Duration {
+	s := newSchedule(a)
+	var out []time.Duration
+	for i := 0; i < n; i++ {
+		d, ok := s.next()
+		if !ok {
+			break
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+func msec(f float64) time.Duration {
+	return time.Duration(f * float64(time.Millisecond))
+}
+
+func TestSchedule(t *testing.T) {
+	profile := func(name string) *Arrival {
+		a, err := RateProfile(name, 100, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	tests := []struct {
+		name    string
+		arrival *Arrival
+		// 第 n 个到达（从 1 开始）的计划时间
+		want map[int]time.Duration
+	}{
+		{"constant", profile("constant"), map[int]time.Duration{
+			1: msec(10), 2: msec(20), 100: msec(1000), 1000: msec(10000),
+		}},
+		// 0 到 100/s 线性上升 1 秒，t 时刻累计 50t² 个，第 n 个在 sqrt(n/50)
+		{"ramp", profile("ramp"), map[int]time.Duration{
+			1: msec(141.421356), 2: msec(200), 8: msec(400), 50: msec(1000),
+			51: msec(1010), 150: msec(2000),
+		}},
+		// 20/s、40/s、…、100/s 各一秒
+		{"step", profile("step"), map[int]time.Duration{
+			1: msec(50), 20: msec(1000), 21: msec(1025), 60: msec(2000), 61: msec(2000 + 1000.0/60),
+			300: msec(5000), 301: msec(5010),
+		}},
+		// 10/s 一秒，100/s 一秒，之后回到 10/s
+		{"spike", profile("spike"), map[int]time.Duration{
+			1: msec(100), 10: msec(1000), 11: msec(1010), 110: msec(2000), 111: msec(2100), 120: msec(3000),
+		}},
+		// 50/s 一秒后在一秒内升到 150/s：第二阶段 t 时刻累计 50t + 50t²，
+		// 其中第 25 个在 t = (√3 - 1) / 2，一共 100 个
+		{"stages", &Arrival{StartRate: 50, Stages: []Stage{
+			{Target: 50, Duration: Duration(time.Second)},
+			{Target: 150, Duration: Duration(time.Second)},
+		}}, map[int]time.Duration{
+			50: msec(1000), 75: msec(1000 * (1 + math.Sqrt(3)) / 2), 150: msec(2000), 151: msec(2000 + 1000.0/150),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			last := 0
+			for n := range tt.want {
+				if n > last {
+					last = n
+				}
+			}
+			got := offsets(tt.arrival, last)
+			if len(got) != last {
+				t.Fatalf("got %d arrivals, want %d", len(got), last)
+			}
+			for i := 1; i < len(got); i++ {
+				if got[i] < got[i-1] {
+					t.Fatalf("arrival %d at %v is before arrival %d at %v", i+1, got[i], i, got[i-1])
+				}
+			}
+			for n, want := range tt.want {
+				if diff := got[n-1] - want; diff < -time.Microsecond || diff > time.Microsecond {
+					t.Errorf("arrival %d at %v, want %v", n, got[n-1], want)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleEnds(t *testing.T) {
+	// 10/s 在一秒内降到 0：累计 10t - 5t²，一共 5 个
+	a := &Arrival{StartRate: 10, Stages: []Stage{{Target: 0, Duration: Duration(time.Second)}}}
+	got := offsets(a, 10)
+	if len(got) != 5 {
+		t.Fatalf("got %d arrivals %v, want 5", len(got), got)
+	}
+	if got[4] != time.Second {
+		t.Fatalf("last arrival at %v, want 1s", got[4])
+	}
+
+	if got := offsets(&Arrival{}, 1); len(got) != 0 {
+		t.Fatalf("zero rate gave arrivals %v", got)
+	}
+	// 直接跳到 0 的阶段之后不再有到达
+	if got := offsets(&Arrival{StartRate: 10, Stages: []Stage{{Target: 0}}}, 1); len(got) != 0 {
+		t.Fatalf("jump to zero gave arrivals %v", got)
+	}
+}
+
+func TestRateProfileErrors(t *testing.T) {
+	if _, err := RateProfile("sawtooth", 100, time.Second); err == nil {
+		t.Error("unknown profile accepted")
+	}
+	for _, a := range []*Arrival{
+		{StartRate: -1},
+		{Stages: []Stage{{Target: -1}}},
+		{Stages: []Stage{{Target: 1, Duration: Duration(-time.Second)}}},
+	} {
+		if err := a.check(); err == nil {
+			t.Errorf("check(%+v) accepted", a)
+		}
+	}
+}
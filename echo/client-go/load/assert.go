@@ -0,0 +1,270 @@
+package load
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"client-go/api"
+)
+
+// Expect describes what the responses of a route must look like. Each key
+// is a dotted path into the response body such as "msg.data", and each
+// value what is expected there:
+//
+//	number, string, bool, null  等于这个值，数字按数值比较
+//	"$sent.<path>"              等于请求 body 中 path 处的值
+//	"$exists"                   存在，值不限
+//	"$sequence"                 整数，同一个 session 内每个响应恰好加一
+//
+// Whatever the expect says, a response with a "code" field fails unless
+// the code is 0.
+type Expect map[string]interface{}
+
+// defaultExpects apply to routes the scenario has no expect for. Every
+// server in echo/ answers hello with the request body and the number of
+// requests of the session so far.
+var defaultExpects = map[string]Expect{
+	api.RouteHello: {"code": 0, "msg.data": "$sent.data", "msg.serverReqId": "$sequence"},
+}
+
+type checkKind int
+
+const (
+	checkEqual checkKind = iota
+	checkSent
+	checkExists
+	checkSequence
+	checkCode // code 存在时必须为 0
+)
+
+// check is one compiled entry of an Expect.
+type check struct {
+	name  string // "<route> <path>"，统计违例用
+	path  []string
+	kind  checkKind
+	value interface{} // checkEqual
+	sent  []string    // checkSent
+}
+
+// assertions are the checks of one route. Sequence checks run on the
+// read goroutine as responses arrive, since pipelined requests complete
+// in any order; the others run when the request returns.
+type assertions struct {
+	checks   []*check
+	sequence []*check
+}
+
+// Violation is a response that failed a check.
+type Violation struct {
+	Check string
+	Got   interface{}
+	Want  interface{}
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("%s: got %v, want %v", v.Check, v.Got, v.Want)
+}
+
+// compileExpect compiles the expect of route.
+func compileExpect(route string, exp Expect) (*assertions, error) {
+	a := &assertions{}
+	keys := make([]string, 0, len(exp))
+	for key := range exp {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("empty path")
+		}
+		c := &check{name: route + " " + key, path: strings.Split(key, "."), kind: checkEqual, value: exp[key]}
+		if s, ok := c.value.(string); ok && strings.HasPrefix(s, "$") {
+			switch {
+			case s == "$exists":
+				c.kind = checkExists
+			case s == "$sequence":
+				c.kind = checkSequence
+			case strings.HasPrefix(s, "$sent.") && len(s) > len("$sent."):
+				c.kind = checkSent
+				c.sent = strings.Split(strings.TrimPrefix(s, "$sent."), ".")
+			default:
+				return nil, fmt.Errorf("%s: unknown expectation %q", key, s)
+			}
+		}
+		if c.kind == checkSequence {
+			a.sequence = append(a.sequence, c)
+		} else {
+			a.checks = append(a.checks, c)
+		}
+	}
+	if _, ok := exp["code"]; !ok {
+		a.checks = append(a.checks, &check{name: route + " code", path: []string{"code"}, kind: checkCode})
+	}
+	return a, nil
+}
+
+// expectFor returns the expect of route: an exact key of the scenario's
+// expect, else the first pattern that matches it in sorted order, else the
+// default one.
+func (sc *Scenario) expectFor(route string) Expect {
+	if exp, ok := sc.Expect[route]; ok {
+		return exp
+	}
+	patterns := make([]string, 0, len(sc.Expect))
+	for pattern := range sc.Expect {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, route); ok {
+			return sc.Expect[pattern]
+		}
+	}
+	return defaultExpects[route]
+}
+
+// verify runs the checks that need the request body, and returns what
+// failed.
+func (a *assertions) verify(sent, res interface{}) []*Violation {
+	var failed []*Violation
+	for _, c := range a.checks {
+		got, ok := lookup(res, c.path)
+		switch c.kind {
+		case checkEqual:
+			if !ok || !equal(got, c.value) {
+				failed = append(failed, &Violation{Check: c.name, Got: got, Want: c.value})
+			}
+		case checkSent:
+			want, _ := lookup(sent, c.sent)
+			if !ok || !equal(got, want) {
+				failed = append(failed, &Violation{Check: c.name, Got: got, Want: want})
+			}
+		case checkExists:
+			if !ok {
+				failed = append(failed, &Violation{Check: c.name, Got: nil, Want: "a value"})
+			}
+		case checkCode:
+			if ok && !equal(got, 0) {
+				failed = append(failed, &Violation{Check: c.name, Got: got, Want: 0})
+			}
+		}
+	}
+	return failed
+}
+
+// lookup returns the value at path in v.
+func lookup(v interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// equal compares numbers by value whatever their type, since each codec
+// decodes them differently, and everything else deeply.
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// sequence follows a counter such as serverReqId, which the server
+// increases by one for every request of a session.
+type sequence struct {
+	mu   sync.Mutex
+	last int64
+	// 恢复 session 时断线前在途的请求可能已经计数但响应丢失，下一个值只要更大
+	resumed bool
+	// 超时或被取消的请求，服务端仍然计数，但响应不会被解码，允许跳过这么多个值
+	skips int64
+}
+
+// reset starts following the counter of a new or resumed session.
+func (s *sequence) reset(resumed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !resumed {
+		s.last = 0
+	}
+	s.resumed = resumed
+	s.skips = 0
+}
+
+// skip allows one value to be missing, for a request whose response will
+// not be seen.
+func (s *sequence) skip() {
+	s.mu.Lock()
+	s.skips++
+	s.mu.Unlock()
+}
+
+// next checks the value of the counter in the next response.
+func (s *sequence) next(name string, v interface{}, ok bool) *Violation {
+	f, isNumber := number(v)
+	if !ok || !isNumber || f != math.Trunc(f) {
+		return &Violation{Check: name, Got: v, Want: "an integer"}
+	}
+	id := int64(f)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.last
+	s.last = id
+	switch {
+	case s.resumed && id > last:
+	case id == last+1:
+	case id > last+1 && id-last-1 <= s.skips:
+		s.skips -= id - last - 1
+	default:
+		s.resumed = false
+		return &Violation{Check: name, Got: id, Want: last + 1}
+	}
+	s.resumed = false
+	return nil
+}
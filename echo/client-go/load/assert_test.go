@@ -0,0 +1,125 @@
+package load
+
+import (
+	"testing"
+)
+
+func TestSequence(t *testing.T) {
+	// 每一步是 next 的值、"skip"、"reset" 或 "resume"；want 为 true 的 next 应当违例
+	type step struct {
+		op   interface{}
+		want bool
+	}
+	next := func(v interface{}) step { return step{op: v} }
+	bad := func(v interface{}) step { return step{op: v, want: true} }
+	skip := step{op: "skip"}
+	reset := step{op: "reset"}
+	resume := step{op: "resume"}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"in order", []step{next(1), next(2), next(int64(3)), next(4.0)}},
+		{"starts at 1", []step{bad(2)}},
+		{"gap", []step{next(1), bad(3), next(4)}},
+		{"repeat", []step{next(1), next(2), bad(2), next(3)}},
+		{"backwards", []step{next(1), next(2), bad(1), next(2)}},
+		{"not an integer", []step{next(1), bad(2.5), bad("3"), bad(nil)}},
+		// 超时的请求服务端照样计数，允许跳过相应个数的值
+		{"skip", []step{next(1), skip, next(3), bad(5)}},
+		{"skips used one by one", []step{next(1), skip, skip, next(3), next(5), bad(7)}},
+		{"two skips at once", []step{next(1), skip, skip, next(4)}},
+		{"too many missing", []step{next(1), skip, bad(4)}},
+		{"skip without gap stays", []step{next(1), skip, next(2), next(4)}},
+		// 新 session 从 1 重新开始
+		{"reset", []step{next(1), next(2), reset, next(1), next(2)}},
+		{"reset must restart", []step{next(1), next(2), reset, bad(3)}},
+		{"reset drops skips", []step{next(1), skip, reset, next(1), bad(3)}},
+		// 恢复的 session 第一个值只要更大，之后恢复逐个加一
+		{"resume", []step{next(1), next(2), resume, next(5), next(6), bad(8)}},
+		{"resume without loss", []step{next(1), resume, next(2), next(3)}},
+		{"resume must increase", []step{next(1), next(2), resume, bad(2), next(3)}},
+		{"resume drops skips", []step{next(1), skip, resume, next(3), bad(5)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s sequence
+			for i, st := range tt.steps {
+				switch st.op {
+				case "skip":
+					s.skip()
+				case "reset":
+					s.reset(false)
+				case "resume":
+					s.reset(true)
+				default:
+					v := s.next("hello msg.serverReqId", st.op, st.op != nil)
+					if (v != nil) != st.want {
+						t.Fatalf("step %d: next(%v) = %v, want violation %v", i, st.op, v, st.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	a, err := compileExpect("r", Expect{
+		"msg.data":  "$sent.data",
+		"msg.level": 3,
+		"msg.ok":    true,
+		"msg.id":    "$exists",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := map[string]interface{}{"data": "world1"}
+	msg := func(fields map[string]interface{}) map[string]interface{} {
+		m := map[string]interface{}{"data": "world1", "level": 3.0, "ok": true, "id": "x"}
+		for k, v := range fields {
+			if v == nil {
+				delete(m, k)
+			} else {
+				m[k] = v
+			}
+		}
+		return map[string]interface{}{"msg": m}
+	}
+	tests := []struct {
+		name string
+		res  map[string]interface{}
+		want []string // 失败的检查项
+	}{
+		{"ok", msg(nil), nil},
+		// 数字按数值比较，与编码解出来的类型无关
+		{"int64 level", msg(map[string]interface{}{"level": int64(3)}), nil},
+		{"uint8 level", msg(map[string]interface{}{"level": uint8(3)}), nil},
+		{"wrong data", msg(map[string]interface{}{"data": "world2"}), []string{"r msg.data"}},
+		{"missing id", msg(map[string]interface{}{"id": nil}), []string{"r msg.id"}},
+		{"string level", msg(map[string]interface{}{"level": "3"}), []string{"r msg.level"}},
+		{"code", func() map[string]interface{} { m := msg(nil); m["code"] = 500.0; return m }(), []string{"r code"}},
+		{"code 0", func() map[string]interface{} { m := msg(nil); m["code"] = 0.0; return m }(), nil},
+		{"not a map", map[string]interface{}{"msg": "text"}, []string{"r msg.data", "r msg.id", "r msg.level", "r msg.ok"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, v := range a.verify(sent, tt.res) {
+				got = append(got, v.Check)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("violations %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("violations %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	if _, err := compileExpect("r", Expect{"msg": "$unknown"}); err == nil {
+		t.Error("unknown expectation accepted")
+	}
+}
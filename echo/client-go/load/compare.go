@@ -0,0 +1,230 @@
+package load
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Target is a server to compare.
+type Target struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+func (t Target) String() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+// ParseTargets reads targets from a JSON file when spec ends with .json,
+// a list of {"name", "host", "port"}, or else from a comma-separated list
+// of name=host:port; the port defaults to 3010.
+func ParseTargets(spec string) ([]Target, error) {
+	var targets []Target
+	if strings.HasSuffix(spec, ".json") {
+		data, err := os.ReadFile(spec)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &targets); err != nil {
+			return nil, fmt.Errorf("%s: %w", spec, err)
+		}
+	} else {
+		for _, item := range strings.Split(spec, ",") {
+			name, addr, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				return nil, fmt.Errorf("target %q is not name=host:port", item)
+			}
+			t := Target{Name: name, Host: addr, Port: 3010}
+			if host, port, err := net.SplitHostPort(addr); err == nil {
+				t.Host = host
+				if t.Port, err = strconv.Atoi(port); err != nil {
+					return nil, fmt.Errorf("target %s: bad port %q", name, port)
+				}
+			}
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets")
+	}
+	seen := make(map[string]bool)
+	for i, t := range targets {
+		if t.Name == "" || t.Host == "" || t.Port <= 0 {
+			return nil, fmt.Errorf("target %d: name, host and port are required", i+1)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("target %s: duplicate name", t.Name)
+		}
+		seen[t.Name] = true
+	}
+	return targets, nil
+}
+
+// Phases are how long each target of a comparison runs. Only Measure is
+// reported; the rest of the time robots connect and the server warms up,
+// or the server rests before the next target.
+type Phases struct {
+	Warmup   time.Duration
+	Measure  time.Duration
+	Cooldown time.Duration
+}
+
+// Comparison is the result of running the same scenario against several
+// targets.
+type Comparison struct {
+	Params   Params          `json:"params"` // host、port 见各个目标
+	Warmup   float64         `json:"warmup"` // 秒
+	Measure  float64         `json:"measure"`
+	Cooldown float64         `json:"cooldown"`
+	Passed   bool            `json:"passed"` // 所有目标都通过了响应检查
+	Targets  []*TargetResult `json:"targets"`
+}
+
+// TargetResult is the result of one target of a comparison.
+type TargetResult struct {
+	Target Target  `json:"target"`
+	Report *Report `json:"report"` // 只包含测量阶段
+	// 包括预热在内整个运行期间的连接统计，预热时连不上的也能看到
+	Connections ConnectionReport `json:"connections"`
+}
+
+// Compare runs the scenario with robots robots against each target in
+// turn, and reports the measure phase of each. r.Stats is not used: each
+// target gets its own stats, which progress, when not nil, receives on
+// its own goroutine along with a context that ends with the target's run.
+// Compare stops after the current target when ctx is done.
+func (r *Runner) Compare(ctx context.Context, robots int, targets []Target, phases Phases, params Params, progress func(context.Context, Target, *Stats)) *Comparison {
+	cmp := &Comparison{
+		Params:   params,
+		Warmup:   phases.Warmup.Seconds(),
+		Measure:  phases.Measure.Seconds(),
+		Cooldown: phases.Cooldown.Seconds(),
+		Passed:   true,
+		Targets:  []*TargetResult{},
+	}
+	cmp.Params.Host, cmp.Params.Port = "", 0
+	for i, t := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+		if i > 0 && phases.Cooldown > 0 {
+			logger.Info("Cooling down", "next", t.Name, "duration", phases.Cooldown)
+			if !sleep(ctx, phases.Cooldown) {
+				break
+			}
+		}
+		res := r.runTarget(ctx, robots, t, phases, params, progress)
+		if res == nil {
+			break
+		}
+		cmp.Targets = append(cmp.Targets, res)
+		if !res.Report.Passed {
+			cmp.Passed = false
+		}
+	}
+	return cmp
+}
+
+// runTarget runs one target of a comparison. It returns nil when ctx is
+// done before the warm-up ends.
+func (r *Runner) runTarget(ctx context.Context, robots int, t Target, phases Phases, params Params, progress func(context.Context, Target, *Stats)) *TargetResult {
+	stats := &Stats{}
+	run := *r
+	run.Stats = stats
+	run.Options.Host, run.Options.Port = t.Host, t.Port
+
+	runCtx, cancel := context.WithTimeout(ctx, phases.Warmup+phases.Measure)
+	defer cancel()
+	if progress != nil {
+		go progress(runCtx, t, stats)
+	}
+
+	// 预热结束和停止的瞬间各取一次快照，之后收尾期间的请求不算在内
+	started := make(chan *Snapshot, 1)
+	warmup := time.AfterFunc(phases.Warmup, func() {
+		logger.Info("Measuring", "target", t.Name, "duration", phases.Measure)
+		started <- stats.Snapshot()
+	})
+	stopped := make(chan *Snapshot, 1)
+	go func() {
+		<-runCtx.Done()
+		stopped <- stats.Snapshot()
+	}()
+
+	logger.Info("Warming up", "target", t.Name, "addr", t.String(), "robots", robots, "duration", phases.Warmup)
+	run.Run(runCtx, robots)
+	cancel()
+	end := <-stopped
+	if warmup.Stop() {
+		// 运行在预热结束之前就停止了：被中断，或者机器人全部停止（比如连不上）
+		if ctx.Err() != nil {
+			return nil
+		}
+		logger.Warn("Robots stopped during warm-up", "target", t.Name)
+		started <- &Snapshot{Time: end.Time}
+	}
+	begin := <-started
+	if end.Time.Before(begin.Time) {
+		end.Time = begin.Time
+	}
+
+	params.Host, params.Port = t.Host, t.Port
+	report := NewReport(params, end.Sub(begin), begin.Time, nil)
+	return &TargetResult{
+		Target: t,
+		Report: report,
+		Connections: ConnectionReport{
+			Connects:          end.Connects,
+			ConnectFailures:   end.ConnectFailures,
+			Disconnects:       end.Disconnects,
+			HeartbeatTimeouts: end.HeartbeatTimeouts,
+		},
+	}
+}
+
+// WriteJSON writes the comparison to path.
+func (cmp *Comparison) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(cmp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// WriteCSV writes one row per target to path.
+func (cmp *Comparison) WriteCSV(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	header := []string{"target", "host", "port", "requests", "success", "fail", "rps", "error_rate"}
+	for _, cause := range seriesCauses {
+		header = append(header, "err_"+cause)
+	}
+	header = append(header, "mean_ms", "p50_ms", "p90_ms", "p99_ms", "p999_ms", "max_ms", "violations", "passed", "connects", "connect_failures", "disconnects")
+	rows := [][]string{header}
+	for _, res := range cmp.Targets {
+		rp := res.Report
+		row := []string{res.Target.Name, res.Target.Host, strconv.Itoa(res.Target.Port), itoa(rp.Requests), itoa(rp.Success), itoa(rp.Fail), ftoa(rp.Throughput), ftoa(rp.ErrorRate)}
+		for _, cause := range seriesCauses {
+			row = append(row, itoa(rp.Errors[cause]))
+		}
+		row = append(row, rp.Latency.fields()...)
+		row = append(row, itoa(rp.Violations), strconv.FormatBool(rp.Passed),
+			itoa(res.Connections.Connects), itoa(res.Connections.ConnectFailures), itoa(res.Connections.Disconnects))
+		rows = append(rows, row)
+	}
+	if err := csv.NewWriter(f).WriteAll(rows); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
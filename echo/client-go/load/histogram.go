@@ -0,0 +1,146 @@
+package load
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Latencies are recorded in microseconds into log-linear buckets, like an
+// HDR histogram: values below 128µs exactly, larger ones in 64 buckets per
+// power of two, so any value is off by less than 1/64 (1.6%).
+const (
+	subBucketBits = 6
+	subBuckets    = 1 << subBucketBits
+	linearMax     = 2 * subBuckets            // 128µs 以内每微秒一个桶
+	histBuckets   = linearMax + 40*subBuckets // 足够表示 2^46µs
+	maxRecordable = int64(1)<<(subBucketBits+41) - 1
+)
+
+// Histogram is a latency histogram that many goroutines record into.
+type Histogram struct {
+	counts [histBuckets]atomic.Int64
+	count  atomic.Int64
+	sum    atomic.Int64 // 微秒
+	max    atomic.Int64 // 微秒
+}
+
+// Record adds one latency.
+func (h *Histogram) Record(d time.Duration) {
+	us := d.Microseconds()
+	if us < 0 {
+		us = 0
+	}
+	if us > maxRecordable {
+		us = maxRecordable
+	}
+	h.counts[bucketOf(us)].Add(1)
+	h.count.Add(1)
+	h.sum.Add(us)
+	for {
+		max := h.max.Load()
+		if us <= max || h.max.CompareAndSwap(max, us) {
+			return
+		}
+	}
+}
+
+// Snapshot copies the histogram.
+func (h *Histogram) Snapshot() *HistogramSnapshot {
+	s := &HistogramSnapshot{
+		Counts: make([]int64, histBuckets),
+		Count:  h.count.Load(),
+		Sum:    h.sum.Load(),
+		Max:    h.max.Load(),
+	}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+	}
+	return s
+}
+
+// HistogramSnapshot is a copy of a histogram, or the difference of two
+// copies for an interval.
+type HistogramSnapshot struct {
+	Counts []int64
+	Count  int64
+	Sum    int64 // 微秒
+	Max    int64 // 微秒；区间快照中为最高非空桶的上界
+}
+
+// Sub returns what was recorded between prev and s.
+func (s *HistogramSnapshot) Sub(prev *HistogramSnapshot) *HistogramSnapshot {
+	if prev == nil {
+		return s
+	}
+	d := &HistogramSnapshot{
+		Counts: make([]int64, histBuckets),
+		Count:  s.Count - prev.Count,
+		Sum:    s.Sum - prev.Sum,
+	}
+	for i := range s.Counts {
+		d.Counts[i] = s.Counts[i] - prev.Counts[i]
+		if d.Counts[i] > 0 {
+			d.Max = bucketMax(i)
+		}
+	}
+	if d.Max > s.Max {
+		d.Max = s.Max
+	}
+	return d
+}
+
+// Quantile returns the latency at or below which a fraction q of the
+// recorded values fall, as the upper bound of its bucket.
+func (s *HistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := int64(q*float64(s.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range s.Counts {
+		seen += n
+		if seen >= rank {
+			us := bucketMax(i)
+			if us > s.Max {
+				us = s.Max
+			}
+			return time.Duration(us) * time.Microsecond
+		}
+	}
+	return time.Duration(s.Max) * time.Microsecond
+}
+
+// Mean returns the average latency.
+func (s *HistogramSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return time.Duration(s.Sum/s.Count) * time.Microsecond
+}
+
+// MaxLatency returns the largest latency.
+func (s *HistogramSnapshot) MaxLatency() time.Duration {
+	return time.Duration(s.Max) * time.Microsecond
+}
+
+func bucketOf(us int64) int {
+	if us < linearMax {
+		return int(us)
+	}
+	shift := bits.Len64(uint64(us)) - (subBucketBits + 1)
+	return linearMax + (shift-1)*subBuckets + int(us>>shift) - subBuckets
+}
+
+// bucketMax is the largest value that falls into bucket i.
+func bucketMax(i int) int64 {
+	if i < linearMax {
+		return int64(i)
+	}
+	shift := (i-linearMax)/subBuckets + 1
+	top := int64((i-linearMax)%subBuckets + subBuckets)
+	return (top+1)<<shift - 1
+}
@@ -0,0 +1,116 @@
+package load
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuantile(t *testing.T) {
+	us := func(n int64) time.Duration { return time.Duration(n) * time.Microsecond }
+	tests := []struct {
+		name   string
+		values []time.Duration
+		want   map[float64]time.Duration
+		mean   time.Duration
+	}{
+		{"empty", nil, map[float64]time.Duration{0.5: 0, 0.99: 0}, 0},
+		// 128µs 以内每微秒一个桶，百分位是精确的
+		{"1..100µs", rangeOf(1, 100, us), map[float64]time.Duration{
+			0: us(1), 0.01: us(1), 0.5: us(50), 0.9: us(90), 0.99: us(99), 0.999: us(100), 1: us(100),
+		}, us(50)},
+		// 只有一个值时任何百分位都是它本身：桶的上界不超过最大值
+		{"single", []time.Duration{us(1000)}, map[float64]time.Duration{0.5: us(1000), 0.999: us(1000)}, us(1000)},
+		// 1% 的慢请求只影响 p99 以上
+		{"tail", append(repeat(us(100), 99), 2*time.Second), map[float64]time.Duration{
+			0.5: us(100), 0.99: us(100), 0.999: 2 * time.Second,
+		}, us(100*99+2000000) / 100},
+		// 负数按 0 记录，超出范围的按最大可记录值
+		{"clamped", []time.Duration{-time.Second, 100000 * time.Hour}, map[float64]time.Duration{
+			0.5: 0, 1: us(maxRecordable),
+		}, us(maxRecordable / 2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h Histogram
+			for _, v := range tt.values {
+				h.Record(v)
+			}
+			s := h.Snapshot()
+			for q, want := range tt.want {
+				if got := s.Quantile(q); got != want {
+					t.Errorf("Quantile(%v) = %v, want %v", q, got, want)
+				}
+			}
+			if got := s.Mean(); got != tt.mean {
+				t.Errorf("Mean = %v, want %v", got, tt.mean)
+			}
+		})
+	}
+}
+
+func rangeOf(from, to int64, unit func(int64) time.Duration) []time.Duration {
+	var out []time.Duration
+	for n := from; n <= to; n++ {
+		out = append(out, unit(n))
+	}
+	return out
+}
+
+func repeat(d time.Duration, n int) []time.Duration {
+	out := make([]time.Duration, n)
+	for i := range out {
+		out[i] = d
+	}
+	return out
+}
+
+func TestBucketPrecision(t *testing.T) {
+	// 每个值落在上界不小于它、误差小于 1/64 的桶里，桶的编号随值单调增加
+	prev := -1
+	for _, us := range []int64{0, 1, 127, 128, 129, 255, 256, 1000, 4095, 4096, 65537, 1e6, 1e9, 1e12, maxRecordable} {
+		i := bucketOf(us)
+		if i < prev || i >= histBuckets {
+			t.Fatalf("bucketOf(%d) = %d after %d", us, i, prev)
+		}
+		prev = i
+		top := bucketMax(i)
+		if top < us || float64(top-us) > float64(us)/subBuckets {
+			t.Errorf("bucketOf(%d) has upper bound %d", us, top)
+		}
+		if i > 0 && bucketMax(i-1) >= us {
+			t.Errorf("value %d also fits bucket %d below %d", us, i-1, i)
+		}
+	}
+}
+
+func TestHistogramSub(t *testing.T) {
+	var h Histogram
+	for i := 0; i < 100; i++ {
+		h.Record(5 * time.Second)
+	}
+	before := h.Snapshot()
+	for i := 1; i <= 10; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	d := h.Snapshot().Sub(before)
+
+	// 区间里只有后来的 10 个值，之前的 5 秒不出现在区间的百分位里
+	if d.Count != 10 {
+		t.Fatalf("Count = %d, want 10", d.Count)
+	}
+	if got := d.Mean(); got != 5500*time.Microsecond {
+		t.Errorf("Mean = %v, want 5.5ms", got)
+	}
+	for q, want := range map[float64]time.Duration{0.5: 5 * time.Millisecond, 1: 10 * time.Millisecond} {
+		got := d.Quantile(q)
+		if got < want || float64(got-want) > float64(want)/subBuckets {
+			t.Errorf("Quantile(%v) = %v, want %v within 1/64", q, got, want)
+		}
+	}
+	if got := d.MaxLatency(); got < 10*time.Millisecond || got >= 5*time.Second {
+		t.Errorf("interval max = %v, want about 10ms", got)
+	}
+	if got := before.Sub(nil); got != before {
+		t.Error("Sub(nil) did not return the snapshot itself")
+	}
+}
@@ -0,0 +1,113 @@
+package load
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// lateThreshold is how far behind schedule an arrival may start before
+// it counts as the generator not keeping up.
+const lateThreshold = 10 * time.Millisecond
+
+// runOpen runs the open model: every robot runs its steps once, then the
+// arrivals start iterations on the robots in turn, at the scheduled times
+// and whether or not earlier iterations have finished.
+func (r *Runner) runOpen(ctx context.Context, robots int) {
+	// 所有机器人完成 steps（通常是连接和登录）之后才开始计时
+	pools := make(map[*Profile][]*robot)
+	var mu sync.Mutex
+	var setup sync.WaitGroup
+	all := make([]*robot, 0, robots)
+	for i := 0; i < robots; i++ {
+		rb := r.newRobot(i + 1)
+		all = append(all, rb)
+		setup.Add(1)
+		go func() {
+			defer setup.Done()
+			if err := rb.steps(ctx, rb.profile.Steps, rb.execution(time.Now())); err != nil {
+				logger.Error("Robot stopped", "robot", rb.index, "uid", rb.uid, "profile", rb.profile.Name, "err", err)
+				return
+			}
+			mu.Lock()
+			pools[rb.profile] = append(pools[rb.profile], rb)
+			mu.Unlock()
+		}()
+	}
+	setup.Wait()
+	defer func() {
+		for _, rb := range all {
+			rb.cli.Disconnect()
+		}
+	}()
+	if ctx.Err() != nil {
+		return
+	}
+	ready := 0
+	for _, pool := range pools {
+		ready += len(pool)
+	}
+	logger.Info("Starting arrivals", "robots", ready, "of", robots)
+
+	var running sync.WaitGroup
+	next := make(map[*Profile]int)
+	sched := newSchedule(r.Scenario.Arrival)
+	start := time.Now()
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	for k := 0; ; k++ {
+		offset, ok := sched.next()
+		if !ok {
+			<-ctx.Done()
+			break
+		}
+		intended := start.Add(offset)
+		if wait := time.Until(intended); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		lag := time.Since(intended)
+		r.Stats.Arrivals.Add(1)
+		r.Stats.observeLag(lag)
+		if lag > time.Second {
+			sampledLogger.Warn("Load generator falling behind schedule", "lag", lag)
+		}
+
+		// 按权重轮流分给各个 profile，profile 内按机器人轮流
+		profile := r.Scenario.pick(k)
+		pool := pools[profile]
+		if len(pool) == 0 {
+			r.Stats.countDropped(profile)
+			continue
+		}
+		rb := pool[next[profile]%len(pool)]
+		next[profile]++
+		select {
+		case rb.iterations <- struct{}{}:
+		default:
+			r.Stats.countDropped(profile)
+			continue
+		}
+		// iteration 的计划开始时间就是到达时间，发生器的滞后也算在延迟里
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			defer func() { <-rb.iterations }()
+			if err := rb.steps(ctx, rb.profile.Iteration, rb.execution(intended)); err != nil {
+				sampledLogger.Warn("Iteration stopped", "robot", rb.index, "uid", rb.uid, "profile", rb.profile.Name, "err", err)
+			}
+		}()
+	}
+	running.Wait()
+	for _, rb := range all {
+		rb.async.Wait()
+	}
+}
@@ -0,0 +1,333 @@
+package load
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Params are the run parameters recorded in a report.
+type Params struct {
+	Scenario    string  `json:"scenario"`
+	File        string  `json:"file,omitempty"` // 场景文件，内置场景为空
+	Host        string  `json:"host"`
+	Port        int     `json:"port"`
+	Robots      int     `json:"robots"`
+	Pipeline    int     `json:"pipeline,omitempty"`
+	Rate        float64 `json:"rate,omitempty"` // 开放模型的速率，闭合模型为 0
+	RateProfile string  `json:"rate_profile,omitempty"`
+	RatePeriod  int     `json:"rate_period,omitempty"` // 秒
+	Duration    int     `json:"duration,omitempty"`    // 秒，0 表示运行到收到信号
+	Codec       string  `json:"codec,omitempty"`       // 为空时由服务端决定
+	Heartbeat   int     `json:"heartbeat,omitempty"`   // 秒，0 表示由服务端决定
+	Reconnect   bool    `json:"reconnect"`
+	// 关闭了响应检查时 passed 没有意义
+	SkipAssertions bool `json:"skip_assertions,omitempty"`
+}
+
+// Report is the machine-readable result of a run.
+type Report struct {
+	Params   Params  `json:"params"`
+	Start    float64 `json:"start"` // unix 秒
+	End      float64 `json:"end"`
+	Duration float64 `json:"duration"` // 秒
+
+	Requests   int64            `json:"requests"`
+	Success    int64            `json:"success"`
+	Fail       int64            `json:"fail"`
+	Cancelled  int64            `json:"cancelled"`
+	Throughput float64          `json:"throughput"` // 每秒请求数
+	ErrorRate  float64          `json:"error_rate"` // 失败所占的比例，0～1
+	Errors     map[string]int64 `json:"errors"`     // 按原因分的失败
+	Latency    Latency          `json:"latency"`    // 所有路由合在一起
+
+	// 响应检查：Passed 为 false 时这次运行的结果不可信，开放模型中丢弃了到达也不算通过
+	Passed          bool             `json:"passed"`
+	Violations      int64            `json:"violations"`
+	ViolationChecks map[string]int64 `json:"violation_checks"` // 按检查项分
+
+	Routes []RouteReport `json:"routes"`
+
+	Notifies     int64 `json:"notifies"`
+	Pushes       int64 `json:"pushes"`
+	PushTimeouts int64 `json:"push_timeouts"`
+
+	Connections ConnectionReport `json:"connections"`
+	Arrival     *ArrivalReport   `json:"arrival,omitempty"` // 只有开放模型有
+
+	Series []Point `json:"series"`
+}
+
+// RouteReport is the part of a Report for one route.
+type RouteReport struct {
+	Route      string  `json:"route"`
+	Requests   int64   `json:"requests"`
+	Success    int64   `json:"success"`
+	Fail       int64   `json:"fail"`
+	Throughput float64 `json:"throughput"`
+	ErrorRate  float64 `json:"error_rate"`
+	Latency    Latency `json:"latency"`
+}
+
+// Latency are the percentiles of a histogram in milliseconds.
+type Latency struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+	Max  float64 `json:"max"`
+}
+
+// ConnectionReport are the connection counters of a Report.
+type ConnectionReport struct {
+	Connects          int64 `json:"connects"`
+	ConnectFailures   int64 `json:"connect_failures"`
+	Disconnects       int64 `json:"disconnects"`
+	HeartbeatTimeouts int64 `json:"heartbeat_timeouts"`
+}
+
+// ArrivalReport are the arrival counters of an open-model Report.
+type ArrivalReport struct {
+	Arrivals int64   `json:"arrivals"`
+	Dropped  int64   `json:"dropped"`
+	Late     int64   `json:"late"`
+	MaxLag   float64 `json:"max_lag"` // 毫秒
+}
+
+// Point is one interval of the time series of a Report.
+type Point struct {
+	Timestamp       int64            `json:"timestamp"` // 区间结束时的 unix 秒
+	Requests        int64            `json:"requests"`
+	Success         int64            `json:"success"`
+	Fail            int64            `json:"fail"`
+	Throughput      float64          `json:"throughput"`
+	ErrorRate       float64          `json:"error_rate"`
+	Errors          map[string]int64 `json:"errors,omitempty"`
+	Latency         Latency          `json:"latency"`
+	Violations      int64            `json:"violations"`
+	Connects        int64            `json:"connects"`
+	ConnectFailures int64            `json:"connect_failures"`
+	Disconnects     int64            `json:"disconnects"`
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func latencyOf(h *HistogramSnapshot) Latency {
+	return Latency{
+		Mean: ms(h.Mean()),
+		P50:  ms(h.Quantile(0.5)),
+		P90:  ms(h.Quantile(0.9)),
+		P99:  ms(h.Quantile(0.99)),
+		P999: ms(h.Quantile(0.999)),
+		Max:  ms(h.MaxLatency()),
+	}
+}
+
+func perSecond(n int64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(n) / seconds
+}
+
+func ratio(fail, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(fail) / float64(total)
+}
+
+// NewPoint describes the interval d, the difference of two snapshots
+// taken seconds apart.
+func NewPoint(d *Snapshot, seconds float64) Point {
+	p := Point{
+		Timestamp:       d.Time.Unix(),
+		Requests:        d.Requests,
+		Success:         d.Success,
+		Fail:            d.Fail,
+		Throughput:      perSecond(d.Requests, seconds),
+		ErrorRate:       ratio(d.Fail, d.Success+d.Fail),
+		Latency:         latencyOf(d.Latency()),
+		Violations:      d.Violations,
+		Connects:        d.Connects,
+		ConnectFailures: d.ConnectFailures,
+		Disconnects:     d.Disconnects,
+	}
+	if len(d.Errors) > 0 {
+		p.Errors = d.Errors
+	}
+	return p
+}
+
+// NewReport builds the report of what snap describes: the snapshot of a
+// run that started at start, or the difference between snap.Time and an
+// earlier snapshot taken at start.
+func NewReport(params Params, snap *Snapshot, start time.Time, series []Point) *Report {
+	elapsed := snap.Time.Sub(start).Seconds()
+	r := &Report{
+		Params:          params,
+		Start:           float64(start.UnixMilli()) / 1000,
+		End:             float64(snap.Time.UnixMilli()) / 1000,
+		Duration:        elapsed,
+		Requests:        snap.Requests,
+		Success:         snap.Success,
+		Fail:            snap.Fail,
+		Cancelled:       snap.Cancelled,
+		Throughput:      perSecond(snap.Requests, elapsed),
+		ErrorRate:       ratio(snap.Fail, snap.Success+snap.Fail),
+		Errors:          snap.Errors,
+		Latency:         latencyOf(snap.Latency()),
+		Passed:          snap.Passed(),
+		Violations:      snap.Violations,
+		ViolationChecks: snap.ViolationChecks,
+		Routes:          []RouteReport{},
+		Notifies:        snap.Notifies,
+		Pushes:          snap.Pushes,
+		PushTimeouts:    snap.PushTimeouts,
+		Connections: ConnectionReport{
+			Connects:          snap.Connects,
+			ConnectFailures:   snap.ConnectFailures,
+			Disconnects:       snap.Disconnects,
+			HeartbeatTimeouts: snap.HeartbeatTimeouts,
+		},
+		Series: series,
+	}
+	if r.Series == nil {
+		r.Series = []Point{}
+	}
+	for _, route := range snap.RouteNames() {
+		rs := snap.Routes[route]
+		total := rs.Success + rs.Fail
+		r.Routes = append(r.Routes, RouteReport{
+			Route:      route,
+			Requests:   total,
+			Success:    rs.Success,
+			Fail:       rs.Fail,
+			Throughput: perSecond(total, elapsed),
+			ErrorRate:  ratio(rs.Fail, total),
+			Latency:    latencyOf(rs.Latency),
+		})
+	}
+	if snap.Arrivals > 0 {
+		r.Arrival = &ArrivalReport{
+			Arrivals: snap.Arrivals,
+			Dropped:  snap.Dropped,
+			Late:     snap.Late,
+			MaxLag:   ms(snap.MaxLag),
+		}
+	}
+	return r
+}
+
+// WriteJSON writes the report to path.
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// WriteRoutesCSV writes one row per route, and a row "(all)" for all of
+// them, to path.
+func (r *Report) WriteRoutesCSV(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{{"route", "requests", "success", "fail", "rps", "error_rate", "mean_ms", "p50_ms", "p90_ms", "p99_ms", "p999_ms", "max_ms"}}
+	row := func(route string, requests, success, fail int64, rps, errorRate float64, l Latency) {
+		rows = append(rows, append([]string{route, itoa(requests), itoa(success), itoa(fail), ftoa(rps), ftoa(errorRate)}, l.fields()...))
+	}
+	for _, rr := range r.Routes {
+		row(rr.Route, rr.Requests, rr.Success, rr.Fail, rr.Throughput, rr.ErrorRate, rr.Latency)
+	}
+	row("(all)", r.Success+r.Fail, r.Success, r.Fail, r.Throughput, r.ErrorRate, r.Latency)
+	if err := csv.NewWriter(f).WriteAll(rows); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SeriesWriter appends the points of the time series to a CSV file as
+// they are measured, so a run that is killed still leaves its series.
+type SeriesWriter struct {
+	f *os.File
+	w *csv.Writer
+}
+
+// seriesCauses are the failure causes that get a column in the series.
+var seriesCauses = []string{CauseTimeout, CauseConnectionLost, CauseNotConnected, CauseAssertion, CauseDropped, CauseOther}
+
+// CreateSeries creates the CSV file at path and writes its header.
+func CreateSeries(path string) (*SeriesWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &SeriesWriter{f: f, w: csv.NewWriter(f)}
+	header := []string{"timestamp", "requests", "success", "fail", "rps", "error_rate"}
+	for _, cause := range seriesCauses {
+		header = append(header, "err_"+cause)
+	}
+	header = append(header, "mean_ms", "p50_ms", "p90_ms", "p99_ms", "p999_ms", "max_ms", "violations", "connects", "connect_failures", "disconnects")
+	if err := s.write(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Write appends p.
+func (s *SeriesWriter) Write(p Point) error {
+	row := []string{strconv.FormatInt(p.Timestamp, 10), itoa(p.Requests), itoa(p.Success), itoa(p.Fail), ftoa(p.Throughput), ftoa(p.ErrorRate)}
+	for _, cause := range seriesCauses {
+		row = append(row, itoa(p.Errors[cause]))
+	}
+	row = append(row, p.Latency.fields()...)
+	row = append(row, itoa(p.Violations), itoa(p.Connects), itoa(p.ConnectFailures), itoa(p.Disconnects))
+	return s.write(row)
+}
+
+// write writes row and flushes it to the file.
+func (s *SeriesWriter) write(row []string) error {
+	if err := s.w.Write(row); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// Close closes the file.
+func (s *SeriesWriter) Close() error {
+	return s.f.Close()
+}
+
+func (l Latency) fields() []string {
+	return []string{ftoa(l.Mean), ftoa(l.P50), ftoa(l.P90), ftoa(l.P99), ftoa(l.P999), ftoa(l.Max)}
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
+
+// SortedKeys returns the keys of counts, such as failure causes, in order.
+func SortedKeys(counts map[string]int64) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
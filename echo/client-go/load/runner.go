@@ -0,0 +1,447 @@
+package load
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"client-go/client"
+	"client-go/logging"
+)
+
+var (
+	logger = logging.For("robot")
+	// 上千个机器人的逐条日志会占满 CPU，按秒采样
+	sampledLogger = logging.Sampled(logger, 10, 100)
+)
+
+// mailboxSize is how many unconsumed pushes a robot keeps per wait_push
+// route; older ones are dropped.
+const mailboxSize = 64
+
+const (
+	defaultRequestTimeout = 30 * time.Second
+	defaultPushTimeout    = 10 * time.Second
+)
+
+// Runner runs a scenario with a number of robots.
+type Runner struct {
+	Scenario *Scenario
+	// Options 是每个机器人的客户端配置，UserId 由 Runner 生成
+	Options client.ClientOptions
+	Stats   *Stats
+
+	// connect 步骤失败后的重试次数和间隔
+	ConnectRetries int
+	RetryInterval  time.Duration
+
+	// SkipAssertions 关闭响应检查，见 Expect
+	SkipAssertions bool
+}
+
+// Run starts robots robots and returns when all of them have finished
+// their steps or ctx is done. With an arrival schedule the robots run
+// their steps once and then iterations as they arrive, until ctx is done.
+func (r *Runner) Run(ctx context.Context, robots int) {
+	if r.Scenario.Arrival != nil {
+		r.runOpen(ctx, robots)
+		return
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < robots; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			r.newRobot(index).run(ctx)
+		}(i + 1)
+	}
+	wg.Wait()
+}
+
+// robot is one simulated player running a profile.
+type robot struct {
+	runner  *Runner
+	profile *Profile
+	stats   *Stats
+	index   int
+	uid     string
+	cli     *client.PinusTcpClient
+	seq     atomic.Int64 // 已发出的消息数，即模板中的 .Seq
+
+	inflight   chan struct{} // 异步请求的信号量
+	iterations chan struct{} // 开放模型下正在执行的 iteration
+	async      sync.WaitGroup
+	// 每个 wait_push 模式一个信箱，在连接之前订阅，不会错过步骤开始前到达的推送
+	mailboxes map[string]chan *client.Push
+	// 每个 $sequence 检查一个，握手时重置
+	sequences map[string]*sequence
+}
+
+func (r *Runner) newRobot(index int) *robot {
+	profile := r.Scenario.pick(index - 1)
+	rb := &robot{
+		runner:     r,
+		profile:    profile,
+		stats:      r.Stats,
+		index:      index,
+		uid:        generateRandomID(),
+		inflight:   make(chan struct{}, profile.maxInflight),
+		iterations: make(chan struct{}, profile.maxInflight),
+		mailboxes:  make(map[string]chan *client.Push),
+		sequences:  make(map[string]*sequence),
+	}
+
+	opts := r.Options
+	opts.UserId = rb.uid
+	rb.cli = client.NewPinusTcpClient(opts)
+	rb.cli.OnEvent(func(ev client.Event) {
+		switch ev.Type {
+		case client.EventDisconnected:
+			if !errors.Is(ev.Err, client.ErrClosed) {
+				rb.stats.Disconnects.Add(1)
+				sampledLogger.Info("Disconnected", "robot", rb.index, "uid", rb.uid, "err", ev.Err)
+			}
+		case client.EventHeartbeatTimeout:
+			rb.stats.HeartbeatTimeouts.Add(1)
+		case client.EventHandshake:
+			for _, seq := range rb.sequences {
+				seq.reset(ev.Resumed)
+			}
+		}
+	})
+	if !r.SkipAssertions {
+		rb.watchSequences()
+	}
+	rb.cli.OnPush("*", func(*client.Push) { rb.stats.Pushes.Add(1) })
+	// 开放模型的 iteration 里也可能等待推送
+	for _, steps := range [][]*Step{profile.Steps, profile.Iteration} {
+		walkSteps(steps, func(st *Step) {
+			if st.Op == OpWaitPush && rb.mailboxes[st.Route] == nil {
+				rb.subscribe(st.Route)
+			}
+		})
+	}
+	return rb
+}
+
+// watchSequences checks the $sequence expectations of every response in
+// the order the responses arrive.
+func (rb *robot) watchSequences() {
+	all := rb.runner.Scenario.assertions
+	for _, a := range all {
+		for _, c := range a.sequence {
+			rb.sequences[c.name] = &sequence{}
+		}
+	}
+	if len(rb.sequences) == 0 {
+		return
+	}
+	rb.cli.OnResponse(func(route string, body interface{}) {
+		a := all[route]
+		if a == nil {
+			return
+		}
+		for _, c := range a.sequence {
+			got, ok := lookup(body, c.path)
+			if v := rb.sequences[c.name].next(c.name, got, ok); v != nil {
+				rb.violation(route, v)
+			}
+		}
+	})
+}
+
+func (rb *robot) violation(route string, v *Violation) {
+	rb.stats.countViolation(v)
+	sampledLogger.Warn("Assertion failed", "robot", rb.index, "uid", rb.uid, "route", route, "check", v.Check, "got", v.Got, "want", v.Want)
+}
+
+func (rb *robot) subscribe(pattern string) {
+	box := make(chan *client.Push, mailboxSize)
+	rb.mailboxes[pattern] = box
+	rb.cli.OnPush(pattern, func(p *client.Push) {
+		for {
+			select {
+			case box <- p:
+				return
+			default:
+			}
+			// 信箱满了，丢掉最旧的
+			select {
+			case <-box:
+			default:
+			}
+		}
+	})
+}
+
+func (rb *robot) run(ctx context.Context) {
+	defer rb.cli.Disconnect()
+	if err := rb.steps(ctx, rb.profile.Steps, rb.execution(time.Now())); err != nil {
+		logger.Error("Robot stopped", "robot", rb.index, "uid", rb.uid, "profile", rb.profile.Name, "err", err)
+	}
+	rb.async.Wait()
+}
+
+// execution is one run of a list of steps: the steps of a robot, or one
+// iteration of the open model.
+//
+// Latency is measured from when a request was meant to be sent, so that
+// a stalled server cannot hide its stall by delaying the robot's next
+// request (coordinated omission). clock is that schedule: sleep steps
+// advance it by the think time and then sleep until it, catching up
+// after a stall instead of drifting; connect, wait_push and disconnect
+// are not scheduled and restart it from now.
+type execution struct {
+	vars  Vars
+	clock time.Time
+	// chained 表示自上次 sleep 以来已经等过响应，之后的请求依赖那个
+	// 响应，从实际发出时刻计时
+	chained bool
+}
+
+func (rb *robot) execution(start time.Time) *execution {
+	return &execution{vars: Vars{Robot: rb.index, UID: rb.uid}, clock: start}
+}
+
+// intended returns when the next request was meant to be sent.
+func (ex *execution) intended() time.Time {
+	if ex.chained {
+		return time.Now()
+	}
+	return ex.clock
+}
+
+func (ex *execution) resync() {
+	ex.clock = time.Now()
+	ex.chained = false
+}
+
+// steps runs steps in order. Failed requests and push timeouts are
+// counted and do not stop the robot; a failed connect does.
+func (rb *robot) steps(ctx context.Context, steps []*Step, ex *execution) error {
+	for _, st := range steps {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := rb.step(ctx, st, ex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rb *robot) step(ctx context.Context, st *Step, ex *execution) error {
+	switch st.Op {
+	case OpConnect:
+		defer ex.resync()
+		return rb.connect(ctx)
+	case OpRequest:
+		body, err := rb.body(st, &ex.vars)
+		if err != nil {
+			return err
+		}
+		intended := ex.intended()
+		if !st.Async {
+			rb.request(ctx, st, body, intended)
+			ex.chained = true
+			return nil
+		}
+		// 等待信号量的时间也算在延迟里
+		select {
+		case rb.inflight <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		rb.async.Add(1)
+		go func() {
+			defer rb.async.Done()
+			defer func() { <-rb.inflight }()
+			rb.request(ctx, st, body, intended)
+		}()
+	case OpNotify:
+		body, err := rb.body(st, &ex.vars)
+		if err != nil {
+			return err
+		}
+		rb.stats.Notifies.Add(1)
+		if err := rb.cli.Notify(st.Route, body); err != nil {
+			sampledLogger.Warn("Notify failed", "robot", rb.index, "uid", rb.uid, "route", st.Route, "err", err)
+		}
+	case OpWait:
+		rb.async.Wait()
+		ex.chained = true
+	case OpWaitPush:
+		rb.waitPush(ctx, st)
+		ex.resync()
+	case OpSleep:
+		ex.clock = ex.clock.Add(thinkTime(st))
+		ex.chained = false
+		sleep(ctx, time.Until(ex.clock))
+	case OpLoop:
+		iter := ex.vars.Iter
+		defer func() { ex.vars.Iter = iter }()
+		for i := 0; st.Count == 0 || i < st.Count; i++ {
+			if ctx.Err() != nil {
+				return nil
+			}
+			ex.vars.Iter = i
+			if err := rb.steps(ctx, st.Steps, ex); err != nil {
+				return err
+			}
+		}
+	case OpDisconnect:
+		rb.async.Wait()
+		rb.cli.Disconnect()
+		ex.resync()
+	}
+	return nil
+}
+
+func (rb *robot) connect(ctx context.Context) error {
+	retries := rb.runner.ConnectRetries
+	for attempt := 1; ; attempt++ {
+		err := rb.cli.Connect()
+		if err == nil {
+			rb.stats.Connects.Add(1)
+			sampledLogger.Info("Robot connected", "robot", rb.index, "uid", rb.uid, "profile", rb.profile.Name)
+			return nil
+		}
+		sampledLogger.Warn("Connection attempt failed", "robot", rb.index, "attempt", attempt, "max", retries+1, "err", err)
+		if attempt > retries {
+			rb.stats.ConnectFailures.Add(1)
+			return fmt.Errorf("failed to connect after %d attempts: %w", attempt, err)
+		}
+		if !sleep(ctx, rb.runner.RetryInterval) {
+			return nil
+		}
+	}
+}
+
+func (rb *robot) body(st *Step, vars *Vars) (interface{}, error) {
+	vars.Seq = rb.seq.Add(1)
+	body, err := st.body(vars)
+	if err != nil {
+		return nil, fmt.Errorf("route %s: render body: %w", st.Route, err)
+	}
+	return body, nil
+}
+
+// request sends one request and records its outcome, with the latency
+// counted from intended.
+func (rb *robot) request(ctx context.Context, st *Step, body interface{}, intended time.Time) {
+	rb.stats.Requests.Add(1)
+	rs := rb.stats.Route(st.Route)
+	timeout := time.Duration(st.Timeout)
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	res, err := rb.cli.RequestContext(reqCtx, st.Route, body)
+	cancel()
+	if err != nil && ctx.Err() != nil {
+		rb.stats.Cancelled.Add(1)
+		return
+	}
+	a := rb.runner.Scenario.assertions[st.Route]
+	if err != nil {
+		cause := errorCause(err)
+		rb.stats.Fail.Add(1)
+		rs.Fail.Add(1)
+		rb.stats.errors.add(cause)
+		if cause == CauseTimeout && a != nil {
+			// 服务端可能已经处理了这个请求，它的计数不会出现在响应里
+			for _, c := range a.sequence {
+				if seq := rb.sequences[c.name]; seq != nil {
+					seq.skip()
+				}
+			}
+		}
+		sampledLogger.Warn("Request failed", "robot", rb.index, "uid", rb.uid, "route", st.Route, "err", err)
+		return
+	}
+	if a != nil && !rb.runner.SkipAssertions {
+		if failed := a.verify(body, res); len(failed) > 0 {
+			rb.stats.Fail.Add(1)
+			rs.Fail.Add(1)
+			rb.stats.errors.add(CauseAssertion)
+			for _, v := range failed {
+				rb.violation(st.Route, v)
+			}
+			return
+		}
+	}
+	rb.stats.Success.Add(1)
+	rs.Success.Add(1)
+	rs.Latency.Record(time.Since(intended))
+	sampledLogger.Debug("Response", "robot", rb.index, "uid", rb.uid, "route", st.Route, "sent", body, "received", res)
+}
+
+func (rb *robot) waitPush(ctx context.Context, st *Step) {
+	timeout := time.Duration(st.Timeout)
+	if timeout <= 0 {
+		timeout = defaultPushTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case p := <-rb.mailboxes[st.Route]:
+		sampledLogger.Debug("Push", "robot", rb.index, "uid", rb.uid, "route", p.Route, "body", p.Body)
+	case <-timer.C:
+		rb.stats.PushTimeouts.Add(1)
+		sampledLogger.Warn("Push timeout", "robot", rb.index, "uid", rb.uid, "route", st.Route, "timeout", timeout)
+	case <-ctx.Done():
+	}
+}
+
+// thinkTime draws the duration of a sleep step.
+func thinkTime(st *Step) time.Duration {
+	mean := float64(st.Duration)
+	var d float64
+	switch st.Dist {
+	case "uniform":
+		d = float64(st.Min) + rand.Float64()*float64(st.Max-st.Min)
+	case "exponential":
+		d = rand.ExpFloat64() * mean
+	case "normal":
+		d = rand.NormFloat64()*float64(st.Stddev) + mean
+	default:
+		d = mean
+	}
+	return time.Duration(math.Max(d, 0))
+}
+
+// sleep waits for d and reports false if ctx was done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func walkSteps(steps []*Step, fn func(*Step)) {
+	for _, st := range steps {
+		fn(st)
+		walkSteps(st.Steps, fn)
+	}
+}
+
+func generateRandomID() string {
+	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 13)
+	for i := range b {
+		b[i] = charset[rand.Intn(len(charset))]
+	}
+	return string(b)
+}
@@ -0,0 +1,218 @@
+package load
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"client-go/client"
+	"client-go/logging"
+	"client-go/protocol"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetLevel("", slog.LevelError)
+	os.Exit(m.Run())
+}
+
+// echoServer is an in-process server that answers the handshake, echoes
+// every request and, for requests to pushRoute, pushes the request body on
+// pushRoute+".push" right after the response.
+type echoServer struct {
+	ln        net.Listener
+	pushRoute string
+}
+
+func startEchoServer(t *testing.T, pushRoute string) *echoServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &echoServer{ln: ln, pushRoute: pushRoute}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *echoServer) options() client.ClientOptions {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return client.ClientOptions{Host: addr.IP.String(), Port: addr.Port}
+}
+
+func (s *echoServer) serve(conn net.Conn) {
+	defer conn.Close()
+	head := make([]byte, protocol.HEAD_SIZE)
+	for {
+		if _, err := io.ReadFull(conn, head); err != nil {
+			return
+		}
+		body := make([]byte, protocol.HeadHandler(head))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		switch head[0] {
+		case protocol.TYPE_HANDSHAKE:
+			resp, _ := json.Marshal(map[string]interface{}{"code": 200, "sys": map[string]interface{}{}})
+			conn.Write(protocol.EncodePackage(protocol.TYPE_HANDSHAKE, resp))
+		case protocol.TYPE_DATA:
+			msg, err := protocol.DecodeMessage(body)
+			if err != nil {
+				return
+			}
+			resp, _ := protocol.EncodeMessage(msg.ID, protocol.TYPE_RESPONSE, false, "", 0, msg.Body)
+			out := protocol.EncodePackage(protocol.TYPE_DATA, resp)
+			if msg.Route == s.pushRoute {
+				push, _ := protocol.EncodeMessage(0, protocol.TYPE_PUSH, false, s.pushRoute+".push", 0, msg.Body)
+				out = append(out, protocol.EncodePackage(protocol.TYPE_DATA, push)...)
+			}
+			conn.Write(out)
+		}
+	}
+}
+
+func TestOpenIterationWaitPush(t *testing.T) {
+	s := startEchoServer(t, "area.move")
+	sc := &Scenario{
+		Name:    "push",
+		Arrival: ConstantRate(200),
+		Profiles: []*Profile{{
+			Name:  "mover",
+			Steps: []*Step{{Op: OpConnect}},
+			Iteration: []*Step{
+				{Op: OpRequest, Route: "area.move", Body: map[string]interface{}{"x": "{{.Seq}}"}},
+				{Op: OpWaitPush, Route: "area.move.push", Timeout: Duration(50 * time.Millisecond)},
+			},
+		}},
+	}
+	if err := sc.compile(); err != nil {
+		t.Fatal(err)
+	}
+	stats := &Stats{}
+	r := &Runner{Scenario: sc, Options: s.options(), Stats: stats}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	r.Run(ctx, 4)
+
+	snap := stats.Snapshot()
+	if snap.Arrivals == 0 || snap.Success == 0 {
+		t.Fatalf("arrivals %d, success %d, want some", snap.Arrivals, snap.Success)
+	}
+	// 迭代里的 wait_push 必须收到推送，而不是在空信箱上等到超时
+	if snap.PushTimeouts != 0 {
+		t.Fatalf("%d push timeouts, want 0", snap.PushTimeouts)
+	}
+	if snap.Pushes == 0 {
+		t.Fatal("no pushes received")
+	}
+}
+
+// runFor runs sc with robots robots against s for d and returns the stats.
+func runFor(t *testing.T, s *echoServer, sc *Scenario, robots int, d time.Duration) *Snapshot {
+	t.Helper()
+	if err := sc.compile(); err != nil {
+		t.Fatal(err)
+	}
+	stats := &Stats{}
+	r := &Runner{Scenario: sc, Options: s.options(), Stats: stats}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	r.Run(ctx, robots)
+	return stats.Snapshot()
+}
+
+func TestOpenDropsAreFailures(t *testing.T) {
+	s := startEchoServer(t, "")
+	// 每个 iteration 占用机器人 50ms，200/s 的到达有一大半赶上 max_inflight 已满
+	snap := runFor(t, s, &Scenario{
+		Name:    "drop",
+		Arrival: ConstantRate(200),
+		Profiles: []*Profile{{
+			Name:        "slow",
+			MaxInflight: 1,
+			Steps:       []*Step{{Op: OpConnect}},
+			Iteration: []*Step{
+				{Op: OpNotify, Route: "area.enter"},
+				{Op: OpRequest, Route: "area.move", Body: map[string]interface{}{"x": 1}},
+				{Op: OpSleep, Duration: Duration(50 * time.Millisecond)},
+			},
+		}},
+	}, 1, 300*time.Millisecond)
+
+	if snap.Dropped == 0 {
+		t.Fatalf("no arrivals dropped of %d", snap.Arrivals)
+	}
+	if got := snap.Errors[CauseDropped]; got != snap.Dropped {
+		t.Fatalf("%d failures with cause %s, want %d", got, CauseDropped, snap.Dropped)
+	}
+	if snap.Fail != snap.Dropped {
+		t.Fatalf("%d failures, want the %d drops", snap.Fail, snap.Dropped)
+	}
+	// 算在 iteration 第一个请求的路由上，notify 不算
+	if got := snap.Routes["area.move"].Fail; got != snap.Dropped {
+		t.Fatalf("area.move has %d failures, want %d", got, snap.Dropped)
+	}
+	if snap.Passed() {
+		t.Fatal("run with dropped arrivals passed")
+	}
+}
+
+func TestOpenDefaultInflight(t *testing.T) {
+	sc := &Scenario{
+		Profiles: []*Profile{{Steps: []*Step{{Op: OpConnect}}, Iteration: []*Step{{Op: OpWait}}}},
+	}
+	if err := sc.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sc.Profiles[0].maxInflight; got != 1 {
+		t.Fatalf("closed model max_inflight %d, want 1", got)
+	}
+	// 编译之后再切换到开放模型，默认值随之变化
+	if err := sc.SetArrival(ConstantRate(1)); err != nil {
+		t.Fatal(err)
+	}
+	if got := sc.Profiles[0].maxInflight; got != OpenMaxInflight {
+		t.Fatalf("open model max_inflight %d, want %d", got, OpenMaxInflight)
+	}
+}
+
+func TestLatencyFromIntendedTime(t *testing.T) {
+	s := startEchoServer(t, "")
+	sc := &Scenario{Profiles: []*Profile{{Steps: []*Step{
+		{Op: OpConnect},
+		{Op: OpRequest, Route: "echo", Body: map[string]interface{}{"n": 1}},
+	}}}}
+	if err := sc.compile(); err != nil {
+		t.Fatal(err)
+	}
+	stats := &Stats{}
+	r := &Runner{Scenario: sc, Options: s.options(), Stats: stats}
+	rb := r.newRobot(1)
+	defer rb.cli.Disconnect()
+	ctx := context.Background()
+	if err := rb.connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 本该 200ms 前发出的请求：等待发出的时间也算在延迟里，不被协调遗漏掩盖
+	rb.request(ctx, sc.Profiles[0].Steps[1], map[string]interface{}{"n": 1}, time.Now().Add(-200*time.Millisecond))
+	h := stats.Route("echo").Latency.Snapshot()
+	if h.Count != 1 {
+		t.Fatalf("%d latencies recorded, want 1", h.Count)
+	}
+	if got := h.Quantile(0.5); got < 200*time.Millisecond {
+		t.Fatalf("latency %v, want at least the 200ms since the intended time", got)
+	}
+}
@@ -0,0 +1,316 @@
+// Package load runs robots that drive a server through scenarios: each
+// robot follows the steps of a profile, and profiles are mixed by weight.
+package load
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"client-go/api"
+)
+
+// Scenario is a load test: several robot profiles mixed by weight.
+type Scenario struct {
+	Name     string     `json:"name"`
+	Profiles []*Profile `json:"profiles"`
+	// Arrival 设置时按开放模型运行：机器人执行完 steps 后，按到达速率
+	// 启动 iteration，不等之前的 iteration 结束
+	Arrival *Arrival `json:"arrival,omitempty"`
+	// Expect 按路由（path.Match 模式）检查响应，见 assert.go
+	Expect map[string]Expect `json:"expect,omitempty"`
+
+	assertions map[string]*assertions // 每个请求路由的检查
+}
+
+// Profile is what one kind of robot does.
+type Profile struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight,omitempty"` // 默认 1
+	// MaxInflight 限制一个机器人同时在途的异步请求数，满了之后下一个异步请求等待；
+	// 开放模型下同时也限制一个机器人同时执行的 iteration 数，满了之后的到达被丢弃并算作失败。
+	// 默认 1，开放模型默认 OpenMaxInflight
+	MaxInflight int     `json:"max_inflight,omitempty"`
+	Steps       []*Step `json:"steps"`
+	// Iteration 是开放模型下每次到达执行的步骤，steps 此时只在开始时执行一次
+	Iteration []*Step `json:"iteration,omitempty"`
+
+	maxInflight int    // 带默认值的 MaxInflight
+	dropRoute   string // 丢弃的到达算作这个路由的失败：iteration 的第一个请求
+}
+
+// OpenMaxInflight is the default max_inflight of the open model. It is
+// high so that arrivals queue up as latency on a slow server instead of
+// being dropped, which would turn the open model back into a closed one.
+const OpenMaxInflight = 64
+
+// Step ops.
+const (
+	OpConnect    = "connect"    // 连接并握手
+	OpRequest    = "request"    // 发送请求；async 时不等响应
+	OpNotify     = "notify"     // 发送通知
+	OpWait       = "wait"       // 等待所有异步请求完成
+	OpWaitPush   = "wait_push"  // 等待匹配 route 的推送
+	OpSleep      = "sleep"      // 思考时间
+	OpLoop       = "loop"       // 重复 steps，count 为 0 时一直重复
+	OpDisconnect = "disconnect" // 断开连接
+)
+
+// Step is one action of a robot. Which fields apply depends on Op.
+type Step struct {
+	Op string `json:"op"`
+
+	// request / notify / wait_push。wait_push 的 route 是 path.Match 模式
+	Route   string      `json:"route,omitempty"`
+	Body    interface{} `json:"body,omitempty"` // 字符串中可以使用模板，见 template.go；代码中也可以用 api 包的请求类型
+	Timeout Duration    `json:"timeout,omitempty"`
+	Async   bool        `json:"async,omitempty"`
+
+	// sleep：dist 为 constant（默认，睡 duration）、uniform（min～max）、
+	// exponential（均值 duration）或 normal（均值 duration，标准差 stddev）
+	Dist     string   `json:"dist,omitempty"`
+	Duration Duration `json:"duration,omitempty"`
+	Min      Duration `json:"min,omitempty"`
+	Max      Duration `json:"max,omitempty"`
+	Stddev   Duration `json:"stddev,omitempty"`
+
+	// loop
+	Count int     `json:"count,omitempty"`
+	Steps []*Step `json:"steps,omitempty"`
+
+	body payload
+}
+
+// Duration is a time.Duration written as a string such as "1.5s" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1s\": %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadScenario reads and checks a JSON scenario file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sc Scenario
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if sc.Name == "" {
+		sc.Name = strings.TrimSuffix(filepath.Base(path), ".json")
+	}
+	if err := sc.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &sc, nil
+}
+
+// EchoScenario is the default robot: every second it sends pipeline
+// hello requests without waiting for each other, with at most pipeline
+// of them in flight.
+func EchoScenario(pipeline int) *Scenario {
+	if pipeline < 1 {
+		pipeline = 1
+	}
+	sc := &Scenario{
+		Name: "echo",
+		Profiles: []*Profile{{
+			Name:        "echo",
+			MaxInflight: pipeline,
+			Steps: []*Step{
+				{Op: OpConnect},
+				{Op: OpLoop, Steps: []*Step{
+					{Op: OpLoop, Count: pipeline, Steps: []*Step{
+						{Op: OpRequest, Route: api.RouteHello, Body: &api.HelloRequest{Data: "world{{.Seq}}"}, Async: true},
+					}},
+					{Op: OpSleep, Duration: Duration(time.Second)},
+				}},
+			},
+		}},
+	}
+	if err := sc.compile(); err != nil {
+		panic(err)
+	}
+	return sc
+}
+
+// OpenEchoScenario is the open-model counterpart of EchoScenario: robots
+// connect, then every arrival sends one hello request, with at most
+// maxInflight of them running per robot; 0 means OpenMaxInflight.
+func OpenEchoScenario(maxInflight int, arrival *Arrival) *Scenario {
+	sc := &Scenario{
+		Name:    "echo",
+		Arrival: arrival,
+		Profiles: []*Profile{{
+			Name:        "echo",
+			MaxInflight: maxInflight,
+			Steps:       []*Step{{Op: OpConnect}},
+			Iteration: []*Step{
+				{Op: OpRequest, Route: api.RouteHello, Body: &api.HelloRequest{Data: "world{{.Seq}}"}},
+			},
+		}},
+	}
+	if err := sc.compile(); err != nil {
+		panic(err)
+	}
+	return sc
+}
+
+// compile checks the scenario and prepares the payload templates.
+func (sc *Scenario) compile() error {
+	if len(sc.Profiles) == 0 {
+		return fmt.Errorf("no profiles")
+	}
+	for i, p := range sc.Profiles {
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("profile%d", i+1)
+		}
+		if p.Weight < 0 {
+			return fmt.Errorf("profile %s: negative weight", p.Name)
+		}
+		if p.Weight == 0 {
+			p.Weight = 1
+		}
+		// 不改写 MaxInflight，SetArrival 重新编译时还能换成开放模型的默认值
+		p.maxInflight = p.MaxInflight
+		if p.maxInflight <= 0 {
+			p.maxInflight = 1
+			if sc.Arrival != nil {
+				p.maxInflight = OpenMaxInflight
+			}
+		}
+		if err := compileSteps(p.Steps); err != nil {
+			return fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+		if err := compileSteps(p.Iteration); err != nil {
+			return fmt.Errorf("profile %s: iteration: %w", p.Name, err)
+		}
+		if sc.Arrival != nil && len(p.Iteration) == 0 {
+			return fmt.Errorf("profile %s: open model needs an iteration", p.Name)
+		}
+		p.dropRoute = ""
+		walkSteps(p.Iteration, func(st *Step) {
+			if st.Op == OpRequest && p.dropRoute == "" {
+				p.dropRoute = st.Route
+			}
+		})
+	}
+	if sc.Arrival != nil {
+		if err := sc.Arrival.check(); err != nil {
+			return fmt.Errorf("arrival: %w", err)
+		}
+	}
+	for pattern, exp := range sc.Expect {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("expect %s: %w", pattern, err)
+		}
+		if _, err := compileExpect(pattern, exp); err != nil {
+			return fmt.Errorf("expect %s: %w", pattern, err)
+		}
+	}
+	sc.assertions = make(map[string]*assertions)
+	for _, p := range sc.Profiles {
+		for _, steps := range [][]*Step{p.Steps, p.Iteration} {
+			walkSteps(steps, func(st *Step) {
+				if st.Op == OpRequest && sc.assertions[st.Route] == nil {
+					// 上面已经检查过，不会出错
+					sc.assertions[st.Route], _ = compileExpect(st.Route, sc.expectFor(st.Route))
+				}
+			})
+		}
+	}
+	return nil
+}
+
+// SetArrival switches the scenario to the open model with schedule a.
+func (sc *Scenario) SetArrival(a *Arrival) error {
+	sc.Arrival = a
+	return sc.compile()
+}
+
+func compileSteps(steps []*Step) error {
+	for i, st := range steps {
+		if err := st.compile(); err != nil {
+			return fmt.Errorf("steps[%d] (%s): %w", i, st.Op, err)
+		}
+	}
+	return nil
+}
+
+func (st *Step) compile() error {
+	switch st.Op {
+	case OpConnect, OpWait, OpDisconnect:
+	case OpRequest, OpNotify:
+		if st.Route == "" {
+			return fmt.Errorf("route is required")
+		}
+		plain, err := plainBody(st.Body)
+		if err != nil {
+			return err
+		}
+		body, err := compilePayload(plain)
+		if err != nil {
+			return err
+		}
+		st.body = body
+	case OpWaitPush:
+		if st.Route == "" {
+			return fmt.Errorf("route is required")
+		}
+	case OpSleep:
+		switch st.Dist {
+		case "", "constant", "exponential":
+		case "uniform":
+			if st.Max < st.Min {
+				return fmt.Errorf("max is less than min")
+			}
+		case "normal":
+		default:
+			return fmt.Errorf("unknown dist %q", st.Dist)
+		}
+	case OpLoop:
+		if st.Count < 0 {
+			return fmt.Errorf("negative count")
+		}
+		return compileSteps(st.Steps)
+	default:
+		return fmt.Errorf("unknown op")
+	}
+	return nil
+}
+
+// pick returns the profile of robot i. Robots are dealt out in turns of
+// the total weight, so the profiles get them in proportion to their weights.
+func (sc *Scenario) pick(i int) *Profile {
+	total := 0
+	for _, p := range sc.Profiles {
+		total += p.Weight
+	}
+	slot := i % total
+	for _, p := range sc.Profiles {
+		if slot < p.Weight {
+			return p
+		}
+		slot -= p.Weight
+	}
+	return sc.Profiles[len(sc.Profiles)-1]
+}
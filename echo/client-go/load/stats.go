@@ -0,0 +1,288 @@
+package load
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"client-go/client"
+)
+
+// Stats are the counters of a run, updated by all robots.
+type Stats struct {
+	Requests     atomic.Int64
+	Success      atomic.Int64
+	Fail         atomic.Int64
+	Notifies     atomic.Int64
+	Pushes       atomic.Int64 // 收到的推送
+	PushTimeouts atomic.Int64 // wait_push 超时
+
+	Cancelled atomic.Int64 // 运行结束时仍在途、被取消的请求，不算失败
+
+	// 开放模型
+	Arrivals atomic.Int64 // 按计划到达的 iteration
+	Dropped  atomic.Int64 // 轮到的机器人已有 max_inflight 个 iteration 在执行或未连接，被丢弃的到达，同时算作失败
+	Late     atomic.Int64 // 发生器自身跟不上，晚于计划时间 lateThreshold 以上才开始的到达
+	MaxLag   atomic.Int64 // 最大的滞后（纳秒）
+
+	Connects          atomic.Int64 // 成功的 connect 步骤
+	ConnectFailures   atomic.Int64 // 重试用尽仍失败的 connect 步骤
+	Disconnects       atomic.Int64 // 连接断开，不含主动关闭
+	HeartbeatTimeouts atomic.Int64
+
+	Violations atomic.Int64 // 响应检查失败的次数，一个响应可能有多项失败
+
+	routes     sync.Map // route -> *RouteStats
+	errors     counters // 按原因分的失败
+	violations counters // 按检查项分的违例
+}
+
+// counters are counters by name.
+type counters struct {
+	m sync.Map // name -> *atomic.Int64
+}
+
+func (c *counters) add(name string) {
+	n, ok := c.m.Load(name)
+	if !ok {
+		n, _ = c.m.LoadOrStore(name, new(atomic.Int64))
+	}
+	n.(*atomic.Int64).Add(1)
+}
+
+func (c *counters) load() map[string]int64 {
+	all := make(map[string]int64)
+	c.m.Range(func(k, v interface{}) bool {
+		all[k.(string)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	return all
+}
+
+// Causes of failed requests, see errorCause.
+const (
+	CauseTimeout        = "timeout"
+	CauseConnectionLost = "connection_lost"
+	CauseNotConnected   = "not_connected"
+	CauseAssertion      = "assertion" // 收到了响应，但没有通过检查
+	CauseDropped        = "dropped"   // 开放模型中没有发出的到达
+	CauseOther          = "other"
+)
+
+// errorCause classifies the error of a failed request.
+func errorCause(err error) string {
+	switch {
+	case errors.Is(err, client.ErrRequestTimeout), errors.Is(err, context.DeadlineExceeded):
+		return CauseTimeout
+	case errors.Is(err, client.ErrConnectionLost):
+		return CauseConnectionLost
+	case errors.Is(err, client.ErrNotConnected):
+		return CauseNotConnected
+	}
+	return CauseOther
+}
+
+// countDropped counts an arrival of profile that could not start. It is
+// a failure of the first request of the iteration: the request was due
+// and got no response, however slow.
+func (s *Stats) countDropped(profile *Profile) {
+	s.Dropped.Add(1)
+	s.Fail.Add(1)
+	s.errors.add(CauseDropped)
+	if profile.dropRoute != "" {
+		s.Route(profile.dropRoute).Fail.Add(1)
+	}
+}
+
+// countViolation counts a failed check.
+func (s *Stats) countViolation(v *Violation) {
+	s.Violations.Add(1)
+	s.violations.add(v.Check)
+}
+
+// RouteStats are the outcomes and latencies of the requests of one route.
+// Latency is measured from the intended send time, see Runner.
+type RouteStats struct {
+	Success atomic.Int64
+	Fail    atomic.Int64
+	Latency Histogram // 只记录成功的请求
+}
+
+// Route returns the stats of route, creating them on first use.
+func (s *Stats) Route(route string) *RouteStats {
+	if rs, ok := s.routes.Load(route); ok {
+		return rs.(*RouteStats)
+	}
+	rs, _ := s.routes.LoadOrStore(route, &RouteStats{})
+	return rs.(*RouteStats)
+}
+
+// Snapshot is the state of Stats at one moment. The difference of two
+// snapshots describes the interval between them.
+type Snapshot struct {
+	Time      time.Time
+	Requests  int64
+	Success   int64
+	Fail      int64
+	Cancelled int64
+	Errors    map[string]int64 // 按原因分的失败
+	Routes    map[string]*RouteSnapshot
+
+	Violations      int64
+	ViolationChecks map[string]int64 // 按检查项分
+
+	Notifies     int64
+	Pushes       int64
+	PushTimeouts int64
+
+	Arrivals int64
+	Dropped  int64
+	Late     int64
+	MaxLag   time.Duration // 到 Time 为止的最大值，区间快照中不相减
+
+	Connects          int64
+	ConnectFailures   int64
+	Disconnects       int64
+	HeartbeatTimeouts int64
+}
+
+// RouteSnapshot is the part of a Snapshot for one route.
+type RouteSnapshot struct {
+	Success int64
+	Fail    int64
+	Latency *HistogramSnapshot
+}
+
+// Snapshot copies the counters and histograms.
+func (s *Stats) Snapshot() *Snapshot {
+	snap := &Snapshot{
+		Time:              time.Now(),
+		Requests:          s.Requests.Load(),
+		Success:           s.Success.Load(),
+		Fail:              s.Fail.Load(),
+		Cancelled:         s.Cancelled.Load(),
+		Errors:            s.errors.load(),
+		Routes:            make(map[string]*RouteSnapshot),
+		Violations:        s.Violations.Load(),
+		ViolationChecks:   s.violations.load(),
+		Notifies:          s.Notifies.Load(),
+		Pushes:            s.Pushes.Load(),
+		PushTimeouts:      s.PushTimeouts.Load(),
+		Arrivals:          s.Arrivals.Load(),
+		Dropped:           s.Dropped.Load(),
+		Late:              s.Late.Load(),
+		MaxLag:            time.Duration(s.MaxLag.Load()),
+		Connects:          s.Connects.Load(),
+		ConnectFailures:   s.ConnectFailures.Load(),
+		Disconnects:       s.Disconnects.Load(),
+		HeartbeatTimeouts: s.HeartbeatTimeouts.Load(),
+	}
+	s.routes.Range(func(k, v interface{}) bool {
+		rs := v.(*RouteStats)
+		snap.Routes[k.(string)] = &RouteSnapshot{
+			Success: rs.Success.Load(),
+			Fail:    rs.Fail.Load(),
+			Latency: rs.Latency.Snapshot(),
+		}
+		return true
+	})
+	return snap
+}
+
+// Sub returns what happened between prev and s.
+func (s *Snapshot) Sub(prev *Snapshot) *Snapshot {
+	d := &Snapshot{
+		Time:              s.Time,
+		Requests:          s.Requests - prev.Requests,
+		Success:           s.Success - prev.Success,
+		Fail:              s.Fail - prev.Fail,
+		Cancelled:         s.Cancelled - prev.Cancelled,
+		Errors:            subCounts(s.Errors, prev.Errors),
+		Routes:            make(map[string]*RouteSnapshot, len(s.Routes)),
+		Violations:        s.Violations - prev.Violations,
+		ViolationChecks:   subCounts(s.ViolationChecks, prev.ViolationChecks),
+		Notifies:          s.Notifies - prev.Notifies,
+		Pushes:            s.Pushes - prev.Pushes,
+		PushTimeouts:      s.PushTimeouts - prev.PushTimeouts,
+		Arrivals:          s.Arrivals - prev.Arrivals,
+		Dropped:           s.Dropped - prev.Dropped,
+		Late:              s.Late - prev.Late,
+		MaxLag:            s.MaxLag,
+		Connects:          s.Connects - prev.Connects,
+		ConnectFailures:   s.ConnectFailures - prev.ConnectFailures,
+		Disconnects:       s.Disconnects - prev.Disconnects,
+		HeartbeatTimeouts: s.HeartbeatTimeouts - prev.HeartbeatTimeouts,
+	}
+	for route, rs := range s.Routes {
+		old := prev.Routes[route]
+		if old == nil {
+			d.Routes[route] = rs
+			continue
+		}
+		d.Routes[route] = &RouteSnapshot{
+			Success: rs.Success - old.Success,
+			Fail:    rs.Fail - old.Fail,
+			Latency: rs.Latency.Sub(old.Latency),
+		}
+	}
+	return d
+}
+
+// subCounts returns counts minus prev, leaving out what did not change.
+func subCounts(counts, prev map[string]int64) map[string]int64 {
+	d := make(map[string]int64, len(counts))
+	for key, n := range counts {
+		if n -= prev[key]; n != 0 {
+			d[key] = n
+		}
+	}
+	return d
+}
+
+// Passed reports whether the results can be trusted: every response
+// passed its checks and, in the open model, no arrival was dropped.
+func (s *Snapshot) Passed() bool {
+	return s.Violations == 0 && s.Dropped == 0
+}
+
+// RouteNames returns the routes in s in order.
+func (s *Snapshot) RouteNames() []string {
+	names := make([]string, 0, len(s.Routes))
+	for route := range s.Routes {
+		names = append(names, route)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Latency merges the histograms of all routes.
+func (s *Snapshot) Latency() *HistogramSnapshot {
+	all := &HistogramSnapshot{Counts: make([]int64, histBuckets)}
+	for _, rs := range s.Routes {
+		for i, n := range rs.Latency.Counts {
+			all.Counts[i] += n
+		}
+		all.Count += rs.Latency.Count
+		all.Sum += rs.Latency.Sum
+		if rs.Latency.Max > all.Max {
+			all.Max = rs.Latency.Max
+		}
+	}
+	return all
+}
+
+// observeLag records how late an arrival started.
+func (s *Stats) observeLag(lag time.Duration) {
+	if lag > lateThreshold {
+		s.Late.Add(1)
+	}
+	for {
+		max := s.MaxLag.Load()
+		if int64(lag) <= max || s.MaxLag.CompareAndSwap(max, int64(lag)) {
+			return
+		}
+	}
+}
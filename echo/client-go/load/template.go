@@ -0,0 +1,163 @@
+package load
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Vars are what payload templates can use, e.g. "world{{.Seq}}".
+type Vars struct {
+	Robot int    // 机器人编号，从 1 开始
+	UID   string // 机器人的 uid
+	Seq   int64  // 本机器人发出的第几条请求或通知，从 1 开始
+	Iter  int    // 最内层 loop 的第几次，从 0 开始
+}
+
+var templateFuncs = template.FuncMap{
+	// rand 返回 [min, max] 之间的随机整数
+	"rand": func(min, max int) int {
+		if max <= min {
+			return min
+		}
+		return min + rand.Intn(max-min+1)
+	},
+	// choice 随机返回一个参数
+	"choice": func(items ...string) string {
+		if len(items) == 0 {
+			return ""
+		}
+		return items[rand.Intn(len(items))]
+	},
+	// now 返回当前的 unix 毫秒
+	"now": func() int64 {
+		return time.Now().UnixMilli()
+	},
+}
+
+// payload renders a message body. Bodies without templates are built
+// once and shared.
+type payload func(v *Vars) (interface{}, error)
+
+// compilePayload compiles every string in body that contains "{{" as a
+// text/template. A string that is one action as a whole, such as
+// "{{rand 1 10}}", becomes a number or bool when the result is one.
+func compilePayload(body interface{}) (payload, error) {
+	if !hasTemplate(body) {
+		return func(*Vars) (interface{}, error) { return body, nil }, nil
+	}
+	switch v := body.(type) {
+	case string:
+		tmpl, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		whole := strings.HasPrefix(v, "{{") && strings.HasSuffix(v, "}}") && strings.Count(v, "{{") == 1
+		return func(vars *Vars) (interface{}, error) {
+			var b strings.Builder
+			if err := tmpl.Execute(&b, vars); err != nil {
+				return nil, err
+			}
+			if whole {
+				return scalar(b.String()), nil
+			}
+			return b.String(), nil
+		}, nil
+	case map[string]interface{}:
+		fields := make(map[string]payload, len(v))
+		for k, item := range v {
+			p, err := compilePayload(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			fields[k] = p
+		}
+		return func(vars *Vars) (interface{}, error) {
+			out := make(map[string]interface{}, len(fields))
+			for k, p := range fields {
+				item, err := p(vars)
+				if err != nil {
+					return nil, err
+				}
+				out[k] = item
+			}
+			return out, nil
+		}, nil
+	case []interface{}:
+		items := make([]payload, len(v))
+		for i, item := range v {
+			p, err := compilePayload(item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			items[i] = p
+		}
+		return func(vars *Vars) (interface{}, error) {
+			out := make([]interface{}, len(items))
+			for i, p := range items {
+				item, err := p(vars)
+				if err != nil {
+					return nil, err
+				}
+				out[i] = item
+			}
+			return out, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unexpected body type %T", body)
+}
+
+// plainBody turns a typed body, such as a request type of the api
+// package, into the maps and slices that scenario files decode to, so
+// that its strings can be templates too.
+func plainBody(body interface{}) (interface{}, error) {
+	switch body.(type) {
+	case nil, string, bool, float64, map[string]interface{}, []interface{}:
+		return body, nil
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+func hasTemplate(body interface{}) bool {
+	switch v := body.(type) {
+	case string:
+		return strings.Contains(v, "{{")
+	case map[string]interface{}:
+		for _, item := range v {
+			if hasTemplate(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if hasTemplate(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scalar turns the result of a whole-string action into a JSON scalar.
+func scalar(s string) interface{} {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	if s == "true" || s == "false" {
+		return s == "true"
+	}
+	return s
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Sampled returns a logger that, per message and per second, writes the
+// first records and then only every thereafter-th one. thereafter <= 0
+// drops everything after the first records.
+func Sampled(l *slog.Logger, first, thereafter int) *slog.Logger {
+	return slog.New(&sampler{
+		Handler:    l.Handler(),
+		first:      uint64(first),
+		thereafter: uint64(thereafter),
+		state:      &sampleState{counts: make(map[string]uint64)},
+	})
+}
+
+type sampler struct {
+	slog.Handler
+	first      uint64
+	thereafter uint64
+	state      *sampleState // shared by loggers derived with With
+}
+
+type sampleState struct {
+	mu     sync.Mutex
+	window int64
+	counts map[string]uint64
+}
+
+func (s *sampler) Handle(ctx context.Context, r slog.Record) error {
+	st := s.state
+	st.mu.Lock()
+	if sec := r.Time.Unix(); sec != st.window {
+		st.window = sec
+		clear(st.counts)
+	}
+	st.counts[r.Message]++
+	n := st.counts[r.Message]
+	st.mu.Unlock()
+
+	if n <= s.first || (s.thereafter > 0 && (n-s.first)%s.thereafter == 0) {
+		return s.Handler.Handle(ctx, r)
+	}
+	return nil
+}
+
+func (s *sampler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sampler{Handler: s.Handler.WithAttrs(attrs), first: s.first, thereafter: s.thereafter, state: s.state}
+}
+
+func (s *sampler) WithGroup(name string) slog.Handler {
+	return &sampler{Handler: s.Handler.WithGroup(name), first: s.first, thereafter: s.thereafter, state: s.state}
+}
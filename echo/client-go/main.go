@@ -1,119 +1,420 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"math/rand"
 	"os"
 	"os/signal"
-	"sync"
-	"sync/atomic"
+	"strconv"
 	"syscall"
 	"time"
 
 	"client-go/client"
+	"client-go/load"
+	"client-go/logging"
+	"client-go/tracing"
 )
 
-var (
-	totalRequests int64
-	successCount  int64
-	failCount     int64
-)
+var logger = logging.For("robot")
+
+// tracer 由所有机器人共用，TRACE_FILE 不设置时为空
+var tracer *tracing.Tracer
+
+// printStats prints the summary of snap, a run that started at start.
+func printStats(snap *load.Snapshot, start time.Time) {
+	// 统计是运行结果而不是日志，不受日志级别影响
+	elapsed := snap.Time.Sub(start)
+	fmt.Printf("\n========== 统计信息 ==========\n")
+	fmt.Printf("运行时间: %v\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("总请求数: %d\n", snap.Requests)
+	fmt.Printf("成功: %d\n", snap.Success)
+	fmt.Printf("失败: %d\n", snap.Fail)
+	for _, cause := range load.SortedKeys(snap.Errors) {
+		fmt.Printf("  %s: %d\n", cause, snap.Errors[cause])
+	}
+	if snap.Cancelled > 0 {
+		fmt.Printf("结束时取消: %d\n", snap.Cancelled)
+	}
+	if snap.Arrivals > 0 {
+		fmt.Printf("到达: %d（丢弃 %d，滞后超过 10ms %d，最大滞后 %v）\n", snap.Arrivals, snap.Dropped, snap.Late, snap.MaxLag.Round(time.Microsecond))
+	}
+	if snap.Dropped > 0 {
+		// 丢弃的到达已经算作失败，但延迟里没有它们，开放模型退化成了闭合模型
+		fmt.Printf("丢弃的到达: %d，结果不可信，调大 max_inflight（PIPELINE）\n", snap.Dropped)
+	}
+	fmt.Printf("通知: %d\n", snap.Notifies)
+	fmt.Printf("推送: %d（等待超时 %d）\n", snap.Pushes, snap.PushTimeouts)
+	fmt.Printf("连接: %d（失败 %d）\n", snap.Connects, snap.ConnectFailures)
+	fmt.Printf("断开连接: %d（心跳超时 %d）\n", snap.Disconnects, snap.HeartbeatTimeouts)
+	if snap.Violations > 0 {
+		fmt.Printf("响应检查失败: %d，结果不可信\n", snap.Violations)
+		for _, check := range load.SortedKeys(snap.ViolationChecks) {
+			fmt.Printf("  %s: %d\n", check, snap.ViolationChecks[check])
+		}
+	}
+	if len(snap.Routes) > 0 {
+		// 延迟从计划发送时间算起，只统计成功的请求
+		fmt.Printf("\n%-32s %9s %9s %7s %9s %9s %9s %9s %9s\n", "route", "count", "req/s", "err%", "p50", "p90", "p99", "p99.9", "max")
+		for _, route := range snap.RouteNames() {
+			printRoute(route, snap.Routes[route], elapsed)
+		}
+		if len(snap.Routes) > 1 {
+			all := &load.RouteSnapshot{Success: snap.Success, Fail: snap.Fail, Latency: snap.Latency()}
+			printRoute("(all)", all, elapsed)
+		}
+	}
+	fmt.Printf("==============================\n")
+}
+
+func printRoute(route string, rs *load.RouteSnapshot, elapsed time.Duration) {
+	h := rs.Latency
+	fmt.Printf("%-32s %9d %9.1f %7.2f %9v %9v %9v %9v %9v\n", route, rs.Success+rs.Fail,
+		float64(rs.Success+rs.Fail)/elapsed.Seconds(), errorRate(rs.Success, rs.Fail),
+		h.Quantile(0.5), h.Quantile(0.9), h.Quantile(0.99), h.Quantile(0.999), h.MaxLatency())
+}
+
+// reportEvery prints the throughput, error rate and latency of each
+// interval until ctx is done.
+func reportEvery(ctx context.Context, stats *load.Stats, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prev := stats.Snapshot()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		snap := stats.Snapshot()
+		d := snap.Sub(prev)
+		h := d.Latency()
+		fmt.Printf("[%s] %.1f req/s, 失败 %.2f%%, p50 %v, p99 %v, max %v\n", snap.Time.Format("15:04:05"),
+			float64(d.Requests)/snap.Time.Sub(prev.Time).Seconds(), errorRate(d.Success, d.Fail),
+			h.Quantile(0.5), h.Quantile(0.99), h.MaxLatency())
+		prev = snap
+	}
+}
+
+// recordSeries measures a point of the time series every second until ctx
+// is done and appends it to w, then sends all the points. The points are
+// taken on whole seconds so that they line up with the samples of
+// benchmark.sh.
+func recordSeries(ctx context.Context, stats *load.Stats, w *load.SeriesWriter) <-chan []load.Point {
+	result := make(chan []load.Point, 1)
+	go func() {
+		var points []load.Point
+		defer func() { result <- points }()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(time.Now().Truncate(time.Second).Add(time.Second))):
+		}
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		prev := stats.Snapshot()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			snap := stats.Snapshot()
+			p := load.NewPoint(snap.Sub(prev), snap.Time.Sub(prev.Time).Seconds())
+			prev = snap
+			points = append(points, p)
+			if err := w.Write(p); err != nil {
+				logger.Warn("Failed to write series", "err", err)
+			}
+		}
+	}()
+	return result
+}
+
+// writeReport writes the report files of snap, a run that started at
+// start: path.json, and path_routes.csv with one row per route.
+func writeReport(path string, params load.Params, snap *load.Snapshot, start time.Time, series []load.Point) {
+	report := load.NewReport(params, snap, start, series)
+	if err := report.WriteJSON(path + ".json"); err != nil {
+		logger.Error("Failed to write report", "path", path+".json", "err", err)
+	}
+	if err := report.WriteRoutesCSV(path + "_routes.csv"); err != nil {
+		logger.Error("Failed to write report", "path", path+"_routes.csv", "err", err)
+	}
+}
 
-func printStats() {
-	log.Printf("\n========== 统计信息 ==========")
-	log.Printf("总请求数: %d", atomic.LoadInt64(&totalRequests))
-	log.Printf("成功: %d", atomic.LoadInt64(&successCount))
-	log.Printf("失败: %d", atomic.LoadInt64(&failCount))
-	log.Printf("==============================\n")
+func errorRate(success, fail int64) float64 {
+	if success+fail == 0 {
+		return 0
+	}
+	return float64(fail) * 100 / float64(success+fail)
 }
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
-	// 捕获退出信号
+	// 日志：LOG_FORMAT=text/json，LOG_LEVEL 形如 "info,client=warn"；
+	// 运行中 SIGUSR1 / SIGUSR2 整体调低 / 调高一级
+	if err := logging.Setup(os.Stderr, getEnv("LOG_FORMAT", "text")); err != nil {
+		logger.Error("Failed to set up logging", "err", err)
+		os.Exit(1)
+	}
+	if err := logging.SetLevels(getEnv("LOG_LEVEL", "info")); err != nil {
+		logger.Error("Failed to set log level", "err", err)
+		os.Exit(1)
+	}
+	logging.WatchSignals()
+
+	scenario, err := setupScenario()
+	if err != nil {
+		logger.Error("Failed to load scenario", "err", err)
+		os.Exit(1)
+	}
+
+	// 请求追踪：span 以 OTLP/JSON 写入 TRACE_FILE，TRACE_SAMPLE 为采样比例
+	if path := getEnv("TRACE_FILE", ""); path != "" {
+		var err error
+		tracer, err = tracing.Open(path, "client-go")
+		if err != nil {
+			logger.Error("Failed to open trace file", "path", path, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// 收到退出信号后停止机器人并输出统计；再收到一次信号立即退出
+	ctx, cancel := context.WithCancel(context.Background())
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigChan
-		printStats()
-		os.Exit(0)
+		cancel()
+		<-sigChan
+		os.Exit(1)
 	}()
 
-	count := getIntEnv("COUNT", 1)
-	log.Printf("Starting %d robot(s)...", count)
+	runner := &load.Runner{
+		Scenario: scenario,
+		Options: client.ClientOptions{
+			Host: getEnv("SERVER_HOST", "127.0.0.1"),
+			Port: getIntEnv("SERVER_PORT", 3010),
+			// 断线后自动重连并恢复服务端 session，默认关闭，RECONNECT=1 开启
+			Reconnect: getIntEnv("RECONNECT", 0) != 0,
+			// body 编码：json / msgpack / protobuf，不设置时由服务端决定
+			Codec: getEnv("CODEC", ""),
+			// 希望使用的心跳间隔（秒），0 表示由服务端决定
+			Heartbeat: time.Duration(getIntEnv("HEARTBEAT", 0)) * time.Second,
+			// 请求追踪，TRACE_FILE 不设置时 tracer 为空
+			Tracer:      tracer,
+			TraceSample: getFloatEnv("TRACE_SAMPLE", 1),
+		},
+		ConnectRetries: 9,
+		RetryInterval:  5 * time.Second,
+		// 检查响应是否正确，ASSERT=0 关闭
+		SkipAssertions: getIntEnv("ASSERT", 1) == 0,
+	}
 
-	var wg sync.WaitGroup
-	for i := 0; i < count; i++ {
-		wg.Add(1)
-		go func(index int) {
-			defer wg.Done()
-			runRobot(index)
-		}(i + 1)
+	count := getIntEnv("COUNT", 1)
+	// TARGETS 设置时依次对每个目标运行同样的场景并对比
+	var code int
+	if spec := getEnv("TARGETS", ""); spec != "" {
+		code = runCompare(ctx, runner, scenario, count, spec)
+	} else {
+		code = runSingle(ctx, runner, scenario, count)
 	}
-	wg.Wait()
+	cancel()
+	if tracer != nil {
+		tracer.Close()
+	}
+	os.Exit(code)
 }
 
-func runRobot(index int) {
-	userId := generateRandomID()
-
-	opts := client.ClientOptions{
-		Host:   getEnv("SERVER_HOST", "127.0.0.1"),
-		Port:   getIntEnv("SERVER_PORT", 3010),
-		UserId: userId,
+// runSingle runs the scenario against SERVER_HOST for DURATION seconds,
+// or until ctx is done, and returns the exit code.
+func runSingle(ctx context.Context, runner *load.Runner, scenario *load.Scenario, count int) int {
+	stats := &load.Stats{}
+	runner.Stats = stats
+	if d := getIntEnv("DURATION", 0); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(d)*time.Second)
+		defer cancel()
 	}
 
-	cli := client.NewPinusTcpClient(opts)
+	// 每 REPORT_INTERVAL 秒输出一次这段时间的吞吐、失败率和延迟，0 关闭
+	if interval := getIntEnv("REPORT_INTERVAL", 10); interval > 0 {
+		go reportEvery(ctx, stats, time.Duration(interval)*time.Second)
+	}
 
-	maxRetries := 10
-	retryInterval := 5 * time.Second
-	var connected bool
-	for i := 0; i < maxRetries; i++ {
-		if err := cli.Connect(); err != nil {
-			log.Printf("Robot %d connection attempt %d/%d failed: %v", index, i+1, maxRetries, err)
-			if i < maxRetries-1 {
-				log.Printf("Robot %d retrying in %v...", index, retryInterval)
-				time.Sleep(retryInterval)
-			}
-		} else {
-			connected = true
-			break
+	// 报告：REPORT_FILE 为路径前缀，运行中每秒向 _series.csv 追加一行，
+	// 结束时写出 .json 和 _routes.csv
+	reportPath := getEnv("REPORT_FILE", "")
+	var series <-chan []load.Point
+	stopSeries := func() {}
+	if reportPath != "" {
+		w, err := load.CreateSeries(reportPath + "_series.csv")
+		if err != nil {
+			logger.Error("Failed to create report", "path", reportPath+"_series.csv", "err", err)
+			return 1
 		}
+		defer w.Close()
+		var seriesCtx context.Context
+		seriesCtx, stopSeries = context.WithCancel(context.Background())
+		series = recordSeries(seriesCtx, stats, w)
+	}
+
+	logger.Info("Starting robots", "count", count, "scenario", scenario.Name)
+	start := time.Now()
+	runner.Run(ctx, count)
+	stopSeries()
+
+	snap := stats.Snapshot()
+	printStats(snap, start)
+	if reportPath != "" {
+		writeReport(reportPath, runParams(scenario, runner, count), snap, start, <-series)
 	}
-	if !connected {
-		log.Printf("Robot %d failed to connect after %d attempts", index, maxRetries)
-		return
+	// 又快又错的服务端不算通过，丢弃了到达的开放模型也不算
+	if !snap.Passed() {
+		return 2
 	}
-	log.Printf("Robot %d connected with userId: %s", index, userId)
+	return 0
+}
 
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+// runCompare runs the scenario against each of TARGETS in turn: WARMUP
+// seconds unmeasured, DURATION seconds measured, and COOLDOWN seconds
+// before the next target. It returns the exit code.
+func runCompare(ctx context.Context, runner *load.Runner, scenario *load.Scenario, count int, spec string) int {
+	targets, err := load.ParseTargets(spec)
+	if err != nil {
+		logger.Error("Failed to parse targets", "err", err)
+		return 1
+	}
+	phases := load.Phases{
+		Warmup:   time.Duration(getIntEnv("WARMUP", 10)) * time.Second,
+		Measure:  time.Duration(getIntEnv("DURATION", 60)) * time.Second,
+		Cooldown: time.Duration(getIntEnv("COOLDOWN", 5)) * time.Second,
+	}
+	if phases.Measure <= 0 {
+		logger.Error("DURATION must be positive to compare targets")
+		return 1
+	}
+	params := runParams(scenario, runner, count)
+	params.Duration = int(phases.Measure / time.Second)
+
+	var progress func(context.Context, load.Target, *load.Stats)
+	if interval := getIntEnv("REPORT_INTERVAL", 10); interval > 0 {
+		progress = func(ctx context.Context, t load.Target, stats *load.Stats) {
+			reportEvery(ctx, stats, time.Duration(interval)*time.Second)
+		}
+	}
+
+	logger.Info("Comparing targets", "targets", len(targets), "robots", count, "scenario", scenario.Name)
+	cmp := runner.Compare(ctx, count, targets, phases, params, progress)
+	printComparison(cmp)
+
+	path := getEnv("REPORT_FILE", "compare")
+	if err := cmp.WriteJSON(path + ".json"); err != nil {
+		logger.Error("Failed to write report", "path", path+".json", "err", err)
+	}
+	if err := cmp.WriteCSV(path + ".csv"); err != nil {
+		logger.Error("Failed to write report", "path", path+".csv", "err", err)
+	}
+	if len(cmp.Targets) < len(targets) {
+		logger.Warn("Comparison interrupted", "done", len(cmp.Targets), "targets", len(targets))
+	}
+	if !cmp.Passed {
+		return 2
+	}
+	return 0
+}
 
-	reqId := 1
-	for range ticker.C {
-		msg := map[string]interface{}{
-			"data": fmt.Sprintf("world%d", reqId),
+// printComparison prints one row per target of the measure phase.
+func printComparison(cmp *load.Comparison) {
+	fmt.Printf("\n========== 对比（测量 %gs）==========\n", cmp.Measure)
+	fmt.Printf("%-12s %-21s %9s %9s %7s %9s %9s %9s %9s %9s %s\n", "target", "addr", "count", "req/s", "err%", "p50", "p90", "p99", "p99.9", "max", "check")
+	for _, res := range cmp.Targets {
+		rp := res.Report
+		check := "ok"
+		switch {
+		case rp.Violations > 0:
+			check = fmt.Sprintf("%d 失败", rp.Violations)
+		case !rp.Passed:
+			check = fmt.Sprintf("丢弃 %d", rp.Arrival.Dropped)
+		case rp.Requests == 0:
+			// 没有请求时检查无从谈起，比如一直连不上
+			check = fmt.Sprintf("无请求（连接失败 %d）", res.Connections.ConnectFailures)
 		}
-		reqId++
-		atomic.AddInt64(&totalRequests, 1)
-		res, err := cli.Request("connector.entryHandler.hello", msg)
+		fmt.Printf("%-12s %-21s %9d %9.1f %7.2f %9v %9v %9v %9v %9v %s\n", res.Target.Name, res.Target.String(),
+			rp.Requests, rp.Throughput, rp.ErrorRate*100,
+			msDuration(rp.Latency.P50), msDuration(rp.Latency.P90), msDuration(rp.Latency.P99), msDuration(rp.Latency.P999), msDuration(rp.Latency.Max), check)
+		for _, cause := range load.SortedKeys(rp.Errors) {
+			fmt.Printf("  %s: %d\n", cause, rp.Errors[cause])
+		}
+	}
+	fmt.Printf("==============================\n")
+}
+
+func msDuration(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond)).Round(time.Microsecond)
+}
+
+// setupScenario loads SCENARIO, or without it the echo robot that sends
+// PIPELINE hello requests a second. RATE switches to the open model: RATE
+// iterations a second overall, shaped by RATE_PROFILE over RATE_PERIOD.
+func setupScenario() (*load.Scenario, error) {
+	var arrival *load.Arrival
+	if rate := getFloatEnv("RATE", 0); rate > 0 {
+		var err error
+		arrival, err = load.RateProfile(getEnv("RATE_PROFILE", "constant"), rate, time.Duration(getIntEnv("RATE_PERIOD", 30))*time.Second)
 		if err != nil {
-			atomic.AddInt64(&failCount, 1)
-			log.Printf("Robot %d request failed: %v", index, err)
-		} else {
-			atomic.AddInt64(&successCount, 1)
-			log.Printf("Robot %d userId: %s, 发送: %v, 收到响应: %v", index, userId, msg, res)
+			return nil, err
+		}
+	}
+
+	path := getEnv("SCENARIO", "")
+	if path == "" {
+		if arrival != nil {
+			return load.OpenEchoScenario(getIntEnv("PIPELINE", load.OpenMaxInflight), arrival), nil
+		}
+		return load.EchoScenario(getIntEnv("PIPELINE", 1)), nil
+	}
+	scenario, err := load.LoadScenario(path)
+	if err != nil {
+		return nil, err
+	}
+	if arrival != nil {
+		if err := scenario.SetArrival(arrival); err != nil {
+			return nil, err
 		}
 	}
+	return scenario, nil
 }
 
-func generateRandomID() string {
-	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, 13)
-	for i := range b {
-		b[i] = charset[rand.Intn(len(charset))]
+// runParams describes the run for the report.
+func runParams(scenario *load.Scenario, runner *load.Runner, count int) load.Params {
+	params := load.Params{
+		Scenario:  scenario.Name,
+		File:      getEnv("SCENARIO", ""),
+		Host:      runner.Options.Host,
+		Port:      runner.Options.Port,
+		Robots:    count,
+		Duration:  getIntEnv("DURATION", 0),
+		Codec:     runner.Options.Codec,
+		Heartbeat: int(runner.Options.Heartbeat / time.Second),
+		Reconnect: runner.Options.Reconnect,
+
+		SkipAssertions: runner.SkipAssertions,
+	}
+	if params.File == "" {
+		params.Pipeline = getIntEnv("PIPELINE", 1)
+	}
+	if rate := getFloatEnv("RATE", 0); rate > 0 {
+		if params.File == "" {
+			params.Pipeline = getIntEnv("PIPELINE", load.OpenMaxInflight)
+		}
+		params.Rate = rate
+		params.RateProfile = getEnv("RATE_PROFILE", "constant")
+		params.RatePeriod = getIntEnv("RATE_PERIOD", 30)
 	}
-	return string(b)
+	return params
 }
 
 func getEnv(key, defaultValue string) string {
@@ -132,3 +433,12 @@ func getIntEnv(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if result, err := strconv.ParseFloat(value, 64); err == nil {
+			return result
+		}
+	}
+	return defaultValue
+}
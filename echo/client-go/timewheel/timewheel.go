@@ -0,0 +1,200 @@
+// Package timewheel implements a hashed timing wheel. Thousands of
+// heartbeat and timeout timers share one ticker goroutine instead of each
+// putting an entry on the runtime timer heap.
+//
+// Timers fire on the wheel goroutine with a resolution of one tick, so
+// callbacks must be short and must not block; start a goroutine from the
+// callback for anything slow.
+package timewheel
+
+import (
+	"sync"
+	"time"
+)
+
+// Timer is a single timer on a Wheel.
+type Timer struct {
+	w      *Wheel
+	fn     func()
+	period time.Duration // > 0 for timers created by Every
+	slot   int           // -1 when not scheduled
+	rounds int
+	prev   *Timer
+	next   *Timer
+}
+
+// Wheel is a ring of slots, each holding the timers that expire when the
+// wheel reaches it. Timers further away than one revolution wait for the
+// remaining rounds.
+type Wheel struct {
+	tick  time.Duration
+	mu    sync.Mutex
+	slots []*Timer // list heads
+	pos   int
+	count int
+	stop  chan struct{}
+	once  sync.Once
+}
+
+var (
+	defaultWheel *Wheel
+	defaultOnce  sync.Once
+)
+
+// Default returns the process wide wheel, with 100ms ticks and 512 slots.
+func Default() *Wheel {
+	defaultOnce.Do(func() {
+		defaultWheel = New(100*time.Millisecond, 512)
+	})
+	return defaultWheel
+}
+
+// New creates and starts a wheel with the given tick and number of slots.
+func New(tick time.Duration, size int) *Wheel {
+	if tick <= 0 {
+		tick = 100 * time.Millisecond
+	}
+	if size <= 0 {
+		size = 512
+	}
+	w := &Wheel{
+		tick:  tick,
+		slots: make([]*Timer, size),
+		stop:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Stop halts the wheel. Pending timers never fire.
+func (w *Wheel) Stop() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+// Len returns the number of pending timers.
+func (w *Wheel) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// AfterFunc calls f once after d.
+func (w *Wheel) AfterFunc(d time.Duration, f func()) *Timer {
+	t := &Timer{w: w, fn: f, slot: -1}
+	w.mu.Lock()
+	w.add(t, d)
+	w.mu.Unlock()
+	return t
+}
+
+// Every calls f every d until the timer is stopped.
+func (w *Wheel) Every(d time.Duration, f func()) *Timer {
+	t := &Timer{w: w, fn: f, period: d, slot: -1}
+	w.mu.Lock()
+	w.add(t, d)
+	w.mu.Unlock()
+	return t
+}
+
+// Stop cancels the timer. It reports whether the timer was pending.
+func (t *Timer) Stop() bool {
+	if t == nil {
+		return false
+	}
+	w := t.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.slot < 0 {
+		return false
+	}
+	w.remove(t)
+	return true
+}
+
+// Reset reschedules the timer to fire after d, and for timers created by
+// Every, every d after that. It reports whether the timer was pending.
+func (t *Timer) Reset(d time.Duration) bool {
+	w := t.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pending := t.slot >= 0
+	if pending {
+		w.remove(t)
+	}
+	if t.period > 0 {
+		t.period = d
+	}
+	w.add(t, d)
+	return pending
+}
+
+func (w *Wheel) add(t *Timer, d time.Duration) {
+	ticks := int((d + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	size := len(w.slots)
+	t.slot = (w.pos + ticks) % size
+	t.rounds = (ticks - 1) / size
+
+	t.prev = nil
+	t.next = w.slots[t.slot]
+	if t.next != nil {
+		t.next.prev = t
+	}
+	w.slots[t.slot] = t
+	w.count++
+}
+
+func (w *Wheel) remove(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		w.slots[t.slot] = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.prev, t.next = nil, nil
+	t.slot = -1
+	w.count--
+}
+
+func (w *Wheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	var due []func()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		w.pos = (w.pos + 1) % len(w.slots)
+		t := w.slots[w.pos]
+		for t != nil {
+			next := t.next
+			if t.rounds > 0 {
+				t.rounds--
+			} else {
+				w.remove(t)
+				if t.period > 0 {
+					// 先重新排期，回调里调用 Stop 才能生效
+					w.add(t, t.period)
+				}
+				due = append(due, t.fn)
+			}
+			t = next
+		}
+		w.mu.Unlock()
+
+		for i, fn := range due {
+			fn()
+			due[i] = nil
+		}
+		due = due[:0]
+	}
+}
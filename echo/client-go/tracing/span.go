@@ -0,0 +1,30 @@
+package tracing
+
+import "time"
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Attr is a span attribute. Value should be a string, an integer, a
+// float64 or a bool.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is a finished span ready to be exported.
+type Span struct {
+	Name    string
+	Kind    Kind
+	Context SpanContext
+	Parent  SpanID
+	Start   time.Time
+	End     time.Time
+	Attrs   []Attr
+}
@@ -0,0 +1,144 @@
+// Package accesslog writes one CSV line per request or notify handled by
+// the server, for finding slow routes and for offline analysis next to
+// the benchmark.sh monitor CSVs.
+//
+// Lines are formatted into pooled buffers and written by an AsyncWriter to
+// a RotatingFile, so logging costs the request path a few hundred
+// nanoseconds and never waits on the disk.
+package accesslog
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"server-go/netbuf"
+)
+
+// Header is the first line of every access log file. timestamp is unix
+// seconds with milliseconds, like the monitor CSVs use whole seconds.
+const Header = "timestamp,sid,uid,type,route,msg_id,req_bytes,resp_bytes,code,latency_us\n"
+
+const (
+	TypeRequest = "request"
+	TypeNotify  = "notify"
+)
+
+const (
+	asyncBufferSize    = 4 << 20
+	asyncFlushInterval = 200 * time.Millisecond
+)
+
+// Entry is one access log line. Code and RespSize are left empty for
+// notifies, which have no response.
+type Entry struct {
+	Time      time.Time
+	SessionID uint64
+	UID       string
+	Type      string
+	Route     string
+	MsgID     int
+	ReqSize   int
+	RespSize  int
+	Code      int
+	Latency   time.Duration
+}
+
+type Logger struct {
+	file *RotatingFile
+	w    *AsyncWriter
+}
+
+// Open starts an access log at path that rotates at maxSize bytes and
+// keeps backups old files.
+func Open(path string, maxSize int64, backups int) (*Logger, error) {
+	file, err := OpenRotatingFile(path, maxSize, backups)
+	if err != nil {
+		return nil, err
+	}
+	file.onOpen = writeHeader
+	if file.size == 0 {
+		if err := writeHeader(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &Logger{
+		file: file,
+		w:    NewAsyncWriter(file, asyncBufferSize, asyncFlushInterval),
+	}, nil
+}
+
+func writeHeader(f *RotatingFile) error {
+	n, err := f.file.WriteString(Header)
+	f.size += int64(n)
+	return err
+}
+
+// Log formats e and queues it for writing.
+func (l *Logger) Log(e *Entry) {
+	bp := netbuf.Get(256)
+	defer netbuf.Put(bp)
+
+	b := *bp
+	ms := e.Time.UnixMilli()
+	b = strconv.AppendInt(b, ms/1000, 10)
+	b = append(b, '.')
+	b = appendPadded(b, ms%1000)
+	b = append(b, ',')
+	b = strconv.AppendUint(b, e.SessionID, 10)
+	b = append(b, ',')
+	b = appendField(b, e.UID)
+	b = append(b, ',')
+	b = append(b, e.Type...)
+	b = append(b, ',')
+	b = appendField(b, e.Route)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(e.MsgID), 10)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(e.ReqSize), 10)
+	b = append(b, ',')
+	if e.Type != TypeNotify {
+		b = strconv.AppendInt(b, int64(e.RespSize), 10)
+	}
+	b = append(b, ',')
+	if e.Type != TypeNotify {
+		b = strconv.AppendInt(b, int64(e.Code), 10)
+	}
+	b = append(b, ',')
+	b = strconv.AppendInt(b, e.Latency.Microseconds(), 10)
+	b = append(b, '\n')
+	*bp = b
+
+	l.w.Write(b)
+}
+
+// Dropped returns how many lines were lost because the disk fell behind.
+func (l *Logger) Dropped() uint64 {
+	return l.w.Dropped()
+}
+
+// Close flushes and closes the file.
+func (l *Logger) Close() error {
+	return l.w.Close()
+}
+
+func appendPadded(b []byte, ms int64) []byte {
+	if ms < 100 {
+		b = append(b, '0')
+	}
+	if ms < 10 {
+		b = append(b, '0')
+	}
+	return strconv.AppendInt(b, ms, 10)
+}
+
+// appendField quotes s if it would break the CSV line.
+func appendField(b []byte, s string) []byte {
+	if !strings.ContainsAny(s, ",\"\n\r") {
+		return append(b, s...)
+	}
+	b = append(b, '"')
+	b = append(b, strings.ReplaceAll(s, `"`, `""`)...)
+	return append(b, '"')
+}
@@ -0,0 +1,113 @@
+package accesslog
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AsyncWriter collects writes in memory and hands them to the underlying
+// writer from a background goroutine, so that callers never wait on disk.
+// When the buffer is full, writes are dropped and counted instead of
+// blocking.
+type AsyncWriter struct {
+	w        io.Writer
+	maxBuf   int
+	interval time.Duration
+
+	mu      sync.Mutex
+	buf     []byte
+	spare   []byte
+	dropped uint64
+
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewAsyncWriter flushes to w every interval or whenever half of maxBuf
+// is filled.
+func NewAsyncWriter(w io.Writer, maxBuf int, interval time.Duration) *AsyncWriter {
+	a := &AsyncWriter{
+		w:        w,
+		maxBuf:   maxBuf,
+		interval: interval,
+		buf:      make([]byte, 0, maxBuf),
+		spare:    make([]byte, 0, maxBuf),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Write copies p into the buffer. It never blocks on the underlying writer.
+func (a *AsyncWriter) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	if len(a.buf)+len(p) > a.maxBuf {
+		a.mu.Unlock()
+		atomic.AddUint64(&a.dropped, 1)
+		return len(p), nil
+	}
+	a.buf = append(a.buf, p...)
+	half := len(a.buf) >= a.maxBuf/2
+	a.mu.Unlock()
+
+	if half {
+		select {
+		case a.wake <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// Dropped returns how many writes were lost because the buffer was full.
+func (a *AsyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+func (a *AsyncWriter) run() {
+	defer close(a.stopped)
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.done:
+			a.flush()
+			return
+		case <-ticker.C:
+		case <-a.wake:
+		}
+		a.flush()
+	}
+}
+
+// flush swaps the buffers so writers can go on while the full one is
+// written out.
+func (a *AsyncWriter) flush() {
+	a.mu.Lock()
+	out := a.buf
+	a.buf = a.spare[:0]
+	a.mu.Unlock()
+
+	if len(out) > 0 {
+		a.w.Write(out)
+	}
+	a.spare = out[:0]
+}
+
+// Close flushes what is buffered and closes the underlying writer if it
+// is an io.Closer.
+func (a *AsyncWriter) Close() error {
+	a.once.Do(func() { close(a.done) })
+	// 等后台 goroutine 写完最后一批
+	<-a.stopped
+	if c, ok := a.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+// routegen generates Go code from the route export of server-go
+// (./server-go routes): typed client-go methods wrapping
+// PinusTcpClient.Request / Notify, or server handler interfaces with
+// functions registering them as typed routes.
+//
+//	go run ./cmd/routegen -mode client -pkg api -o ../client-go/api/routes_gen.go routes.json
+//	go run ./cmd/routegen -mode server -pkg handler -o handler/routes_gen.go routes.json
+//
+// Types are named after the last route segment, e.g. HelloRequest and
+// HelloResponse for connector.entryHandler.hello, and nested objects after
+// their field, e.g. HelloResponseMsg. Routes without a schema use maps.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type export struct {
+	Routes []route `json:"routes"`
+}
+
+type route struct {
+	Route    string  `json:"route"`
+	Kind     string  `json:"kind"`
+	Request  *schema `json:"request"`
+	Response *schema `json:"response"`
+
+	base  string // 生成的方法名和类型名前缀
+	group string // 除最后一段以外的路由，如 connector.entryHandler
+}
+
+type schema struct {
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	Items                *schema            `json:"items"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+}
+
+const mapType = "map[string]interface{}"
+
+func main() {
+	mode := flag.String("mode", "client", "client or server")
+	pkg := flag.String("pkg", "", "package name of the generated file")
+	out := flag.String("o", "", "output file (default stdout)")
+	flag.Parse()
+	if flag.NArg() != 1 || *pkg == "" {
+		fmt.Fprintln(os.Stderr, "usage: routegen -mode client|server -pkg name [-o file] routes.json")
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var exp export
+	if err := json.Unmarshal(data, &exp); err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+	name(exp.Routes)
+
+	g := &generator{}
+	g.printf("// Code generated by routegen from %s. DO NOT EDIT.\n\npackage %s\n\n", flag.Arg(0), *pkg)
+	switch *mode {
+	case "client":
+		g.client(exp.Routes)
+	case "server":
+		g.server(exp.Routes)
+	default:
+		log.Fatalf("unknown mode %q", *mode)
+	}
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		log.Fatalf("format generated code: %v\n%s", err, g.buf.Bytes())
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// name picks the Go names of routes. The last segment is used unless two
+// routes share it, then the handler segment is prepended as well.
+func name(routes []route) {
+	count := map[string]int{}
+	for i := range routes {
+		r := &routes[i]
+		segs := strings.Split(r.Route, ".")
+		r.base = exported(segs[len(segs)-1])
+		r.group = strings.Join(segs[:len(segs)-1], ".")
+		count[r.base]++
+	}
+	for i := range routes {
+		r := &routes[i]
+		if count[r.base] > 1 && r.group != "" {
+			segs := strings.Split(r.group, ".")
+			r.base = exported(segs[len(segs)-1]) + r.base
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Route < routes[j].Route })
+}
+
+type generator struct {
+	buf   bytes.Buffer
+	types strings.Builder
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) routeConsts(routes []route) {
+	g.printf("// Routes.\nconst (\n")
+	for _, r := range routes {
+		g.printf("Route%s = %q\n", r.base, r.Route)
+	}
+	g.printf(")\n\n")
+}
+
+// typeOf returns the Go type of s, declaring the structs it needs under
+// the given name.
+func (g *generator) typeOf(s *schema, name string) string {
+	if s == nil {
+		return "interface{}"
+	}
+	switch s.Type {
+	case "string":
+		return "string"
+	case "boolean":
+		return "bool"
+	case "integer":
+		if s.Format == "uint32" {
+			return "uint32"
+		}
+		return "int"
+	case "number":
+		if s.Format == "float" {
+			return "float32"
+		}
+		return "float64"
+	case "array":
+		return "[]" + g.typeOf(s.Items, name)
+	case "object":
+		if s.AdditionalProperties != nil {
+			return "map[string]" + g.typeOf(s.AdditionalProperties, name+"Value")
+		}
+		if len(s.Properties) == 0 {
+			return mapType
+		}
+		g.structType(s, name)
+		return name
+	}
+	return "interface{}"
+}
+
+func (g *generator) structType(s *schema, name string) {
+	required := map[string]bool{}
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	fields := make([]string, 0, len(s.Properties))
+	for f := range s.Properties {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "type %s struct {\n", name)
+	for _, f := range fields {
+		goName := exported(f)
+		tag := f
+		if !required[f] {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&b, "%s %s `json:%q`\n", goName, g.typeOf(s.Properties[f], name+goName), tag)
+	}
+	b.WriteString("}\n\n")
+	// 嵌套类型在递归中先写入，排在外层类型之前
+	g.types.WriteString(b.String())
+}
+
+// bodyType returns the Go type of a request or response body, as a
+// pointer to a struct or a map.
+func (g *generator) bodyType(s *schema, name string) (typ string, isStruct bool) {
+	if s == nil || s.Type != "object" || len(s.Properties) == 0 || s.AdditionalProperties != nil {
+		return mapType, false
+	}
+	return g.typeOf(s, name), true
+}
+
+func (g *generator) flushTypes() {
+	g.buf.WriteString(g.types.String())
+	g.types.Reset()
+}
+
+func (g *generator) client(routes []route) {
+	for _, r := range routes {
+		if r.Kind == "push" {
+			g.printf("import \"client-go/client\"\n\n")
+			break
+		}
+	}
+	g.routeConsts(routes)
+	for _, r := range routes {
+		switch r.Kind {
+		case "request":
+			req, reqStruct := g.bodyType(r.Request, r.base+"Request")
+			resp, respStruct := g.bodyType(r.Response, r.base+"Response")
+			g.flushTypes()
+			g.printf("// %s sends a %s request.\n", r.base, r.Route)
+			g.printf("func (c *Client) %s(req %s) (%s, error) {\n", r.base, ptr(req, reqStruct), ptr(resp, respStruct))
+			if respStruct {
+				g.printf("resp := new(%s)\nif err := c.request(Route%s, req, resp); err != nil {\n", resp, r.base)
+			} else {
+				g.printf("var resp %s\nif err := c.request(Route%s, req, &resp); err != nil {\n", resp, r.base)
+			}
+			g.printf("return nil, err\n}\nreturn resp, nil\n}\n\n")
+		case "notify":
+			req, reqStruct := g.bodyType(r.Request, r.base+"Request")
+			g.flushTypes()
+			g.printf("// %s sends a %s notify.\n", r.base, r.Route)
+			g.printf("func (c *Client) %s(req %s) error {\nreturn c.notify(Route%s, req)\n}\n\n", r.base, ptr(req, reqStruct), r.base)
+		case "push":
+			body, bodyStruct := g.bodyType(r.Response, r.base+"Push")
+			g.flushTypes()
+			// pinus 的推送路由常以 on 开头（onChat），不再重复前缀
+			method := r.base
+			if !strings.HasPrefix(method, "On") {
+				method = "On" + method
+			}
+			g.printf("// %s subscribes fn to %s pushes.\n", method, r.Route)
+			g.printf("func (c *Client) %s(fn func(%s)) (unsubscribe func()) {\n", method, ptr(body, bodyStruct))
+			if bodyStruct {
+				g.printf("return client.OnPushTyped(c.PinusTcpClient, Route%s, fn)\n}\n\n", r.base)
+			} else {
+				g.printf("return c.OnPush(Route%s, func(p *client.Push) {\nbody, _ := p.Body.(%s)\nfn(body)\n})\n}\n\n", r.base, body)
+			}
+		}
+	}
+}
+
+func (g *generator) server(routes []route) {
+	g.printf("import \"server-go/session\"\n\n")
+	g.routeConsts(routes)
+
+	// 每个 handler（路由去掉最后一段）一个接口
+	groups := map[string][]route{}
+	var order []string
+	var pushes []route
+	for _, r := range routes {
+		if r.Kind == "push" {
+			pushes = append(pushes, r)
+			continue
+		}
+		if _, ok := groups[r.group]; !ok {
+			order = append(order, r.group)
+		}
+		groups[r.group] = append(groups[r.group], r)
+	}
+	names := interfaceNames(order)
+
+	for _, group := range order {
+		iface := names[group]
+		var methods, registers strings.Builder
+		for _, r := range groups[group] {
+			req, reqStruct := g.bodyType(r.Request, r.base+"Request")
+			switch r.Kind {
+			case "request":
+				resp, respStruct := g.bodyType(r.Response, r.base+"Response")
+				if reqStruct && respStruct {
+					fmt.Fprintf(&methods, "%s(s *session.Session, req *%s) *%s\n", r.base, req, resp)
+					fmt.Fprintf(&registers, "session.RegisterTyped(Route%s, h.%s)\n", r.base, r.base)
+				} else {
+					fmt.Fprintf(&methods, "%s(s *session.Session, body %s) %s\n", r.base, mapType, mapType)
+					fmt.Fprintf(&registers, "session.RegisterHandler(Route%s, h.%s)\n", r.base, r.base)
+				}
+			case "notify":
+				if reqStruct {
+					fmt.Fprintf(&methods, "%s(s *session.Session, req *%s)\n", r.base, req)
+					fmt.Fprintf(&registers, "session.RegisterTypedNotify(Route%s, h.%s)\n", r.base, r.base)
+				} else {
+					fmt.Fprintf(&methods, "%s(s *session.Session, body %s)\n", r.base, mapType)
+					fmt.Fprintf(&registers, "session.RegisterNotifyHandler(Route%s, h.%s)\n", r.base, r.base)
+				}
+			}
+		}
+		g.flushTypes()
+		g.printf("// %s handles the %s routes.\ntype %s interface {\n%s}\n\n", iface, group, iface, methods.String())
+		g.printf("// Register%s registers every route of h.\nfunc Register%s(h %s) {\n%s}\n\n", iface, iface, iface, registers.String())
+	}
+
+	if len(pushes) > 0 {
+		var declares strings.Builder
+		for _, r := range pushes {
+			typ, isStruct := g.bodyType(r.Response, r.base+"Push")
+			sample := "nil"
+			if isStruct {
+				sample = typ + "{}"
+			}
+			fmt.Fprintf(&declares, "session.DeclarePush(Route%s, %s)\n", r.base, sample)
+		}
+		g.flushTypes()
+		g.printf("// DeclarePushes declares the push routes.\nfunc DeclarePushes() {\n%s}\n", declares.String())
+	}
+}
+
+// interfaceNames names the interface of each group after its last
+// segment, or after all segments if that is taken twice.
+func interfaceNames(groups []string) map[string]string {
+	names := map[string]string{}
+	count := map[string]int{}
+	for _, group := range groups {
+		segs := strings.Split(group, ".")
+		names[group] = exported(segs[len(segs)-1])
+		count[names[group]]++
+	}
+	for _, group := range groups {
+		if count[names[group]] > 1 || names[group] == "" {
+			var b strings.Builder
+			for _, seg := range strings.Split(group, ".") {
+				b.WriteString(exported(seg))
+			}
+			names[group] = b.String()
+		}
+		if names[group] == "" {
+			names[group] = "Handler"
+		}
+	}
+	return names
+}
+
+func ptr(typ string, isStruct bool) string {
+	if isStruct {
+		return "*" + typ
+	}
+	return typ
+}
+
+// exported turns a route segment or json name into an exported Go
+// identifier: "entryHandler" -> "EntryHandler", "server_req-id" -> "ServerReqId".
+func exported(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	out := b.String()
+	if out != "" && unicode.IsDigit(rune(out[0])) {
+		out = "X" + out
+	}
+	return out
+}
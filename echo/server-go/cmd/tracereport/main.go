@@ -0,0 +1,141 @@
+// Command tracereport joins the span files written by client-go and
+// server-go and breaks the slowest requests down into network, queueing,
+// handler and write time.
+//
+//	go run ./cmd/tracereport -n 10 client-trace.json server-trace.json
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type span struct {
+	TraceID           string `json:"traceId"`
+	SpanID            string `json:"spanId"`
+	ParentSpanID      string `json:"parentSpanId"`
+	Name              string `json:"name"`
+	Kind              int    `json:"kind"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string `json:"endTimeUnixNano"`
+}
+
+type line struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []span `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+// request is the spans of one trace by role.
+type request struct {
+	route                          string
+	client, server, handler, write *span
+}
+
+func main() {
+	n := flag.Int("n", 10, "number of slowest requests to show")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: tracereport [-n N] file...")
+		os.Exit(2)
+	}
+
+	requests := make(map[string]*request)
+	for _, path := range flag.Args() {
+		if err := load(path, requests); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(1)
+		}
+	}
+
+	var complete []*request
+	for _, r := range requests {
+		if r.client != nil && r.server != nil {
+			complete = append(complete, r)
+		}
+	}
+	sort.Slice(complete, func(i, j int) bool {
+		return duration(complete[i].client) > duration(complete[j].client)
+	})
+	if len(complete) > *n {
+		complete = complete[:*n]
+	}
+
+	fmt.Printf("%-34s %-32s %10s %10s %10s %10s %10s\n", "trace", "route", "total", "network", "queue", "handler", "write")
+	for _, r := range complete {
+		total := duration(r.client)
+		network := total - duration(r.server)
+		var queue, handler, write time.Duration
+		if r.handler != nil {
+			queue = nanos(r.handler.StartTimeUnixNano) - nanos(r.server.StartTimeUnixNano)
+			handler = duration(r.handler)
+		}
+		if r.write != nil {
+			write = duration(r.write)
+		}
+		fmt.Printf("%-34s %-32s %10v %10v %10v %10v %10v\n", r.client.TraceID, r.route, total, network, queue, handler, write)
+	}
+}
+
+func load(path string, requests map[string]*request) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1<<20), 64<<20)
+	for scanner.Scan() {
+		var l line
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return err
+		}
+		for _, rs := range l.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for i := range ss.Spans {
+					add(requests, &ss.Spans[i])
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func add(requests map[string]*request, s *span) {
+	r, ok := requests[s.TraceID]
+	if !ok {
+		r = &request{}
+		requests[s.TraceID] = r
+	}
+	kind, route, _ := strings.Cut(s.Name, " ")
+	switch kind {
+	case "client":
+		r.client = s
+		r.route = route
+	case "server":
+		r.server = s
+	case "handler":
+		r.handler = s
+	case "write":
+		r.write = s
+	}
+}
+
+func nanos(s string) time.Duration {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return time.Duration(n)
+}
+
+func duration(s *span) time.Duration {
+	return nanos(s.EndTimeUnixNano) - nanos(s.StartTimeUnixNano)
+}
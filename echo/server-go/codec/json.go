@@ -0,0 +1,64 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+type jsonCodec struct{}
+
+// JSON is the default pinus body encoding.
+var JSON Codec = jsonCodec{}
+
+// jsonEncoder pairs a reusable buffer with an encoder writing into it.
+type jsonEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var jsonEncoders = sync.Pool{
+	New: func() interface{} {
+		e := &jsonEncoder{}
+		e.enc = json.NewEncoder(&e.buf)
+		return e
+	},
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Encode(route string, v interface{}) ([]byte, error) {
+	if out, ok := appendJSON(nil, v, 0); ok {
+		return out, nil
+	}
+	return json.Marshal(v)
+}
+
+func (jsonCodec) AppendEncode(dst []byte, route string, v interface{}) ([]byte, error) {
+	if out, ok := appendJSON(dst, v, 0); ok {
+		return out, nil
+	}
+	e := jsonEncoders.Get().(*jsonEncoder)
+	defer jsonEncoders.Put(e)
+	e.buf.Reset()
+	if err := e.enc.Encode(v); err != nil {
+		return dst, err
+	}
+	// Encoder terminates each value with a newline, json.Marshal does not
+	out := e.buf.Bytes()
+	return append(dst, out[:len(out)-1]...), nil
+}
+
+func (jsonCodec) Decode(route string, data []byte) (interface{}, error) {
+	var v interface{}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if v, ok := parseJSON(data); ok {
+		return v, nil
+	}
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
@@ -0,0 +1,194 @@
+package codec
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 快速路径的输出必须与 encoding/json 逐字节相同
+
+func TestAppendJSONMatchesMarshal(t *testing.T) {
+	values := []interface{}{
+		nil, true, false,
+		"", "world1", `quote " backslash \ slash /`, "<a href='x'>&</a>",
+		"tab\t nl\n cr\r ctl\x01\x1f del\x7f",
+		"中文 ✓ 😀", "line\u2028sep\u2029",
+		0, -1, 42, int8(-8), int16(300), int32(-70000), int64(math.MaxInt64), int64(math.MinInt64),
+		uint(7), uint8(255), uint16(65535), uint32(math.MaxUint32), uint64(math.MaxUint64),
+		0.0, math.Copysign(0, -1), 1.5, -2.25, 1e20, 1e21, 1e-6, 1e-7, 123456789.125, 5e-324, math.MaxFloat64,
+		float32(0.1), float32(1e21), float32(1e-7), float32(-3.5),
+		map[string]interface{}{},
+		[]interface{}{},
+		map[string]interface{}(nil),
+		[]interface{}(nil),
+		map[string]interface{}{
+			"code": 0,
+			"msg":  map[string]interface{}{"data": "world1", "serverReqId": 12, "z": nil, "a": []interface{}{1, "x", false}},
+		},
+		manyKeys(40),
+	}
+	for _, v := range values {
+		want, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("json.Marshal(%#v): %v", v, err)
+		}
+		got, ok := appendJSON(nil, v, 0)
+		if !ok {
+			t.Errorf("appendJSON(%#v) fell back", v)
+			continue
+		}
+		if string(got) != string(want) {
+			t.Errorf("appendJSON(%#v) = %s, want %s", v, got, want)
+		}
+	}
+}
+
+func manyKeys(n int) map[string]interface{} {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		m[strings.Repeat("k", i%5)+string(rune('a'+i%26))+string(rune('0'+i/26))] = i
+	}
+	return m
+}
+
+func TestAppendJSONFallsBack(t *testing.T) {
+	type point struct{ X int }
+	values := []interface{}{
+		point{1},
+		[]byte("raw"),
+		json.Number("1"),
+		map[string]int{"a": 1},
+		map[string]interface{}{"nested": []interface{}{point{2}}},
+		math.NaN(),
+		math.Inf(1),
+		"\b\f",
+		"bad \xff utf8 \xe4\xb8",
+		map[string]interface{}{"bad \xff": 1},
+		nested(jsonFastMaxDepth + 1),
+	}
+	for _, v := range values {
+		if _, ok := appendJSON(nil, v, 0); ok {
+			t.Errorf("appendJSON(%#v) did not fall back", v)
+		}
+	}
+
+	// 经过 Codec 回退时仍然得到 encoding/json 的结果
+	v := map[string]interface{}{"p": point{3}, "n": json.Number("12")}
+	want, _ := json.Marshal(v)
+	got, err := JSON.Encode("", v)
+	if err != nil || string(got) != string(want) {
+		t.Fatalf("Encode = %s, %v, want %s", got, err, want)
+	}
+	dst := []byte("prefix")
+	got, err = JSON.(Appender).AppendEncode(dst, "", v)
+	if err != nil || string(got) != "prefix"+string(want) {
+		t.Fatalf("AppendEncode = %s, %v, want prefix%s", got, err, want)
+	}
+	if _, err := JSON.Encode("", math.NaN()); err == nil {
+		t.Fatal("Encode(NaN) did not fail")
+	}
+}
+
+func nested(depth int) interface{} {
+	var v interface{} = "leaf"
+	for i := 0; i < depth; i++ {
+		v = []interface{}{v}
+	}
+	return v
+}
+
+func TestParseJSONMatchesUnmarshal(t *testing.T) {
+	inputs := []string{
+		`null`, `true`, `false`, `""`, `"world1"`, `"中文 ✓"`,
+		`0`, `-0`, `1`, `-1`, `42`, `123456789012345`, `1234567890123456789`, `-9007199254740993`,
+		`1.5`, `-2.25e3`, `1E-7`, `1e+2`, `0.1`, `5e-324`,
+		`{}`, `[]`, ` { "a" : [ 1 , 2 , { } ] , "b" : null } `,
+		`{"data":"world1","level":3,"ok":true}`,
+		`{"a":1,"a":2}`,
+		`[[[[["deep"]]]]]`,
+	}
+	for _, in := range inputs {
+		var want interface{}
+		if err := json.Unmarshal([]byte(in), &want); err != nil {
+			t.Fatalf("json.Unmarshal(%s): %v", in, err)
+		}
+		got, ok := parseJSON([]byte(in))
+		if !ok {
+			t.Errorf("parseJSON(%s) fell back", in)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("parseJSON(%s) = %#v, want %#v", in, got, want)
+		}
+		// -0 这类 DeepEqual 分不出来的值，重新编码后比较
+		a, _ := json.Marshal(got)
+		b, _ := json.Marshal(want)
+		if string(a) != string(b) {
+			t.Errorf("parseJSON(%s) re-encodes as %s, want %s", in, a, b)
+		}
+	}
+}
+
+func TestParseJSONFallsBack(t *testing.T) {
+	inputs := []string{
+		// encoding/json 能解码，但快速路径不处理
+		`"esc\"aped"`, `"\u4e2d"`, "\"bad \xff\"", `1e400`,
+		`[` + strings.Repeat(`[`, jsonFastMaxDepth) + strings.Repeat(`]`, jsonFastMaxDepth) + `]`,
+		// 不合法的 JSON
+		``, ` `, `{`, `[1,]`, `{"a":1,}`, `{"a" 1}`, `{a:1}`, `01`, `-`, `1.`, `.5`, `1e`, `+1`,
+		`0x10`, `Infinity`, `NaN`, `tru`, `nul`, `"open`, "\"ctl\x01\"", `1 2`, `{} x`,
+	}
+	for _, in := range inputs {
+		if v, ok := parseJSON([]byte(in)); ok {
+			t.Errorf("parseJSON(%q) = %#v, want fallback", in, v)
+		}
+	}
+
+	// 经过 Codec 回退时与 encoding/json 的结果相同
+	for _, in := range []string{`"esc\"aped"`, `{"k":"\u4e2d"}`, "\"bad \xff\""} {
+		var want interface{}
+		json.Unmarshal([]byte(in), &want)
+		got, err := JSON.Decode("", []byte(in))
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Decode(%q) = %#v, %v, want %#v", in, got, err, want)
+		}
+	}
+	if _, err := JSON.Decode("", []byte(`{"a":`)); err == nil {
+		t.Error("Decode of truncated JSON did not fail")
+	}
+}
+
+func BenchmarkJSON(b *testing.B) {
+	body := []byte(`{"data":"world1"}`)
+	resp := map[string]interface{}{"code": 0, "msg": map[string]interface{}{"data": "world1", "serverReqId": 12}}
+	buf := make([]byte, 0, 512)
+
+	b.Run("decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			JSON.Decode("", body)
+		}
+	})
+	b.Run("decode/encoding-json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var v interface{}
+			json.Unmarshal(body, &v)
+		}
+	})
+	b.Run("encode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf, _ = AppendEncode(JSON, buf[:0], "", resp)
+		}
+	})
+	b.Run("encode/encoding-json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			json.Marshal(resp)
+		}
+	})
+}
@@ -0,0 +1,435 @@
+package codec
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// JSON 快速路径：解码出来的消息体和 handler 返回的响应通常只由
+// map[string]interface{}、[]interface{}、字符串、数字、布尔和 nil 组成，
+// 这些类型不经过反射直接编解码，结果与 encoding/json 完全相同。
+// 其他类型、带转义的字符串、超出范围的数字等少见情况交还给 encoding/json。
+
+// jsonFastMaxDepth is the nesting the fast path handles; deeper values go
+// to encoding/json, which has its own limit.
+const jsonFastMaxDepth = 64
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSON appends the encoding of v to dst exactly as json.Marshal
+// would. It returns false for anything it does not handle, in which case
+// dst may have been extended and must be truncated by the caller.
+func appendJSON(dst []byte, v interface{}, depth int) ([]byte, bool) {
+	switch val := v.(type) {
+	case nil:
+		return append(dst, "null"...), true
+	case bool:
+		if val {
+			return append(dst, "true"...), true
+		}
+		return append(dst, "false"...), true
+	case string:
+		return appendJSONString(dst, val)
+	case float64:
+		return appendJSONFloat(dst, val, 64)
+	case float32:
+		return appendJSONFloat(dst, float64(val), 32)
+	case int:
+		return strconv.AppendInt(dst, int64(val), 10), true
+	case int8:
+		return strconv.AppendInt(dst, int64(val), 10), true
+	case int16:
+		return strconv.AppendInt(dst, int64(val), 10), true
+	case int32:
+		return strconv.AppendInt(dst, int64(val), 10), true
+	case int64:
+		return strconv.AppendInt(dst, val, 10), true
+	case uint:
+		return strconv.AppendUint(dst, uint64(val), 10), true
+	case uint8:
+		return strconv.AppendUint(dst, uint64(val), 10), true
+	case uint16:
+		return strconv.AppendUint(dst, uint64(val), 10), true
+	case uint32:
+		return strconv.AppendUint(dst, uint64(val), 10), true
+	case uint64:
+		return strconv.AppendUint(dst, val, 10), true
+	case map[string]interface{}:
+		if val == nil {
+			return append(dst, "null"...), true
+		}
+		if depth >= jsonFastMaxDepth {
+			return dst, false
+		}
+		return appendJSONObject(dst, val, depth+1)
+	case []interface{}:
+		if val == nil {
+			return append(dst, "null"...), true
+		}
+		if depth >= jsonFastMaxDepth {
+			return dst, false
+		}
+		dst = append(dst, '[')
+		for i, elem := range val {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			var ok bool
+			if dst, ok = appendJSON(dst, elem, depth+1); !ok {
+				return dst, false
+			}
+		}
+		return append(dst, ']'), true
+	}
+	return dst, false
+}
+
+// appendJSONObject writes the keys in sorted order, as encoding/json does.
+func appendJSONObject(dst []byte, m map[string]interface{}, depth int) ([]byte, bool) {
+	var small [16]string
+	keys := small[:0]
+	for k := range m {
+		keys = append(keys, k)
+	}
+	if len(keys) > len(small) {
+		sort.Strings(keys)
+	} else {
+		// 键很少，插入排序避免 sort.Strings 的开销
+		for i := 1; i < len(keys); i++ {
+			for j := i; j > 0 && keys[j] < keys[j-1]; j-- {
+				keys[j], keys[j-1] = keys[j-1], keys[j]
+			}
+		}
+	}
+
+	dst = append(dst, '{')
+	for i, k := range keys {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		var ok bool
+		if dst, ok = appendJSONString(dst, k); !ok {
+			return dst, false
+		}
+		dst = append(dst, ':')
+		if dst, ok = appendJSON(dst, m[k], depth); !ok {
+			return dst, false
+		}
+	}
+	return append(dst, '}'), true
+}
+
+// appendJSONString quotes s with the escaping of json.Marshal, which makes
+// <, > and & safe to embed in HTML. \b, \f and invalid UTF-8 are escaped
+// differently across Go releases and are left to encoding/json.
+func appendJSONString(dst []byte, s string) ([]byte, bool) {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= ' ' && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			if b == '\b' || b == '\f' {
+				return dst, false
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			return dst, false
+		}
+		// U+2028 和 U+2029 在 JSON 中合法，但在 JavaScript 字符串里是换行
+		if c == '\u2028' || c == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hexDigits[c&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"'), true
+}
+
+// appendJSONFloat formats f like encoding/json: plain decimals between
+// 1e-6 and 1e21, exponents without a leading zero outside. NaN and
+// infinities are errors, left to encoding/json to report.
+func appendJSONFloat(dst []byte, f float64, bits int) ([]byte, bool) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return dst, false
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	dst = strconv.AppendFloat(dst, f, format, -1, bits)
+	if format == 'e' {
+		// e-09 写成 e-9
+		n := len(dst)
+		if n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst, true
+}
+
+// parseJSON decodes data as json.Unmarshal into an interface{} would. It
+// returns false for invalid input and for what it leaves to encoding/json.
+func parseJSON(data []byte) (interface{}, bool) {
+	p := jsonParser{data: data}
+	v, ok := p.value(0)
+	if !ok {
+		return nil, false
+	}
+	p.skipSpace()
+	return v, p.pos == len(p.data)
+}
+
+type jsonParser struct {
+	data []byte
+	pos  int
+}
+
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *jsonParser) value(depth int) (interface{}, bool) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, false
+	}
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		if depth >= jsonFastMaxDepth {
+			return nil, false
+		}
+		return p.object(depth + 1)
+	case c == '[':
+		if depth >= jsonFastMaxDepth {
+			return nil, false
+		}
+		return p.array(depth + 1)
+	case c == '"':
+		s, ok := p.string()
+		return s, ok
+	case c == 't':
+		return true, p.literal("true")
+	case c == 'f':
+		return false, p.literal("false")
+	case c == 'n':
+		return nil, p.literal("null")
+	case c == '-' || c >= '0' && c <= '9':
+		return p.number()
+	}
+	return nil, false
+}
+
+func (p *jsonParser) literal(word string) bool {
+	if len(p.data)-p.pos < len(word) || string(p.data[p.pos:p.pos+len(word)]) != word {
+		return false
+	}
+	p.pos += len(word)
+	return true
+}
+
+func (p *jsonParser) object(depth int) (interface{}, bool) {
+	p.pos++ // {
+	m := make(map[string]interface{})
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == '}' {
+		p.pos++
+		return m, true
+	}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) || p.data[p.pos] != '"' {
+			return nil, false
+		}
+		key, ok := p.string()
+		if !ok {
+			return nil, false
+		}
+		p.skipSpace()
+		if p.pos >= len(p.data) || p.data[p.pos] != ':' {
+			return nil, false
+		}
+		p.pos++
+		v, ok := p.value(depth)
+		if !ok {
+			return nil, false
+		}
+		// 重复的键以最后一个为准，与 encoding/json 相同
+		m[key] = v
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, false
+		}
+		switch p.data[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return m, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+func (p *jsonParser) array(depth int) (interface{}, bool) {
+	p.pos++ // [
+	arr := []interface{}{}
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == ']' {
+		p.pos++
+		return arr, true
+	}
+	for {
+		v, ok := p.value(depth)
+		if !ok {
+			return nil, false
+		}
+		arr = append(arr, v)
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, false
+		}
+		switch p.data[p.pos] {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return arr, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// string reads a string without escapes. Escapes and invalid UTF-8, which
+// encoding/json replaces, are left to it.
+func (p *jsonParser) string() (string, bool) {
+	start := p.pos + 1
+	ascii := true
+	for i := start; i < len(p.data); i++ {
+		switch c := p.data[i]; {
+		case c == '"':
+			b := p.data[start:i]
+			if !ascii && !utf8.Valid(b) {
+				return "", false
+			}
+			p.pos = i + 1
+			return string(b), true
+		case c == '\\' || c < ' ':
+			return "", false
+		case c >= utf8.RuneSelf:
+			ascii = false
+		}
+	}
+	return "", false
+}
+
+// number reads a number with the JSON grammar, which is stricter than
+// strconv: no leading zeros, no "+", no hex, no "inf".
+func (p *jsonParser) number() (interface{}, bool) {
+	data := p.data
+	start := p.pos
+	i := start
+	if data[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(data) && data[i] == '0':
+		i++
+	case i < len(data) && data[i] >= '1' && data[i] <= '9':
+		for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+			i++
+		}
+	default:
+		return nil, false
+	}
+	integer := true
+	if i < len(data) && data[i] == '.' {
+		integer = false
+		i++
+		if !isDigits(data, &i) {
+			return nil, false
+		}
+	}
+	if i < len(data) && (data[i] == 'e' || data[i] == 'E') {
+		integer = false
+		i++
+		if i < len(data) && (data[i] == '+' || data[i] == '-') {
+			i++
+		}
+		if !isDigits(data, &i) {
+			return nil, false
+		}
+	}
+	p.pos = i
+
+	num := data[start:i]
+	// 15 位以内的整数可以精确地直接算出来
+	if integer && len(num) <= 15 {
+		var n int64
+		digits := num
+		if digits[0] == '-' {
+			digits = digits[1:]
+		}
+		for _, c := range digits {
+			n = n*10 + int64(c-'0')
+		}
+		if num[0] == '-' {
+			if n == 0 {
+				return math.Copysign(0, -1), true
+			}
+			n = -n
+		}
+		return float64(n), true
+	}
+	f, err := strconv.ParseFloat(string(num), 64)
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// isDigits advances *i past one or more digits.
+func isDigits(data []byte, i *int) bool {
+	start := *i
+	for *i < len(data) && data[*i] >= '0' && data[*i] <= '9' {
+		*i++
+	}
+	return *i > start
+}
@@ -0,0 +1,189 @@
+// Package diag serves profiling and runtime diagnostics on a separate,
+// loopback-only HTTP listener:
+//
+//	/debug/pprof/            net/http/pprof
+//	/debug/goroutines        goroutines grouped by state and stack
+//	/debug/heap/snapshot     write a heap profile after a GC
+//	/debug/trace/capture     record a runtime trace for ?seconds=N
+//	/debug/loglevel          show or change log levels (?spec=warn,session=info)
+//	/debug/routes            registered routes, schemas, dict and protos
+//
+// Snapshots and traces are written to the configured directory and the
+// response names the file, so they can be copied out of a container.
+package diag
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"server-go/logging"
+	"server-go/session"
+)
+
+var logger = logging.For("diag")
+
+var ErrNotLoopback = errors.New("diag: listen address must be a loopback address")
+
+const maxTraceSeconds = 300
+
+type server struct {
+	dir     string
+	tracing sync.Mutex
+}
+
+// Start listens on addr, which must be a loopback address, and serves
+// until the process exits. Files are written to dir.
+func Start(addr, dir string) error {
+	if err := checkLoopback(addr); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s := &server{dir: dir}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", s.goroutines)
+	mux.HandleFunc("/debug/heap/snapshot", s.heapSnapshot)
+	mux.HandleFunc("/debug/trace/capture", s.traceCapture)
+	mux.HandleFunc("/debug/loglevel", s.logLevel)
+	mux.HandleFunc("/debug/routes", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		session.ExportRoutes(w)
+	})
+
+	logger.Info("Diagnostics listening", "addr", ln.Addr().String(), "dir", dir)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			logger.Error("Diagnostics listener stopped", "err", err)
+		}
+	}()
+	return nil
+}
+
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return ErrNotLoopback
+	}
+	return nil
+}
+
+func (s *server) goroutines(w http.ResponseWriter, r *http.Request) {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writeGoroutineGroups(w, parseGoroutines(buf))
+}
+
+func (s *server) heapSnapshot(w http.ResponseWriter, r *http.Request) {
+	runtime.GC()
+	path := filepath.Join(s.dir, "heap-"+time.Now().Format("20060102-150405.000")+".pprof")
+	f, err := os.Create(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if err := rpprof.Lookup("heap").WriteTo(f, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	logger.Info("Heap snapshot written", "path", path)
+	fmt.Fprintf(w, "%s\nheap_alloc=%d heap_objects=%d num_gc=%d\n", path, m.HeapAlloc, m.HeapObjects, m.NumGC)
+}
+
+func (s *server) traceCapture(w http.ResponseWriter, r *http.Request) {
+	seconds, err := strconv.Atoi(r.URL.Query().Get("seconds"))
+	if err != nil || seconds <= 0 || seconds > maxTraceSeconds {
+		http.Error(w, fmt.Sprintf("seconds must be between 1 and %d", maxTraceSeconds), http.StatusBadRequest)
+		return
+	}
+	// runtime/trace 同一时间只能有一个
+	if !s.tracing.TryLock() {
+		http.Error(w, "a trace is already being captured", http.StatusConflict)
+		return
+	}
+	defer s.tracing.Unlock()
+
+	path := filepath.Join(s.dir, "trace-"+time.Now().Format("20060102-150405.000")+".out")
+	f, err := os.Create(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if err := trace.Start(f); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-r.Context().Done():
+	}
+	trace.Stop()
+
+	logger.Info("Runtime trace written", "path", path, "seconds", seconds)
+	fmt.Fprintf(w, "%s\n", path)
+}
+
+func (s *server) logLevel(w http.ResponseWriter, r *http.Request) {
+	if spec := r.URL.Query().Get("spec"); spec != "" {
+		if err := logging.SetLevels(spec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info("Log levels changed", "spec", spec)
+	}
+	levels := logging.Levels()
+	components := make([]string, 0, len(levels))
+	for c := range levels {
+		components = append(components, c)
+	}
+	sort.Strings(components)
+	for _, c := range components {
+		name := c
+		if name == "" {
+			name = "(default)"
+		}
+		fmt.Fprintf(w, "%s=%s\n", name, levels[c])
+	}
+}
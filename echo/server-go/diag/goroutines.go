@@ -0,0 +1,74 @@
+package diag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// goroutineGroup is the goroutines sharing one state and one stack.
+type goroutineGroup struct {
+	state string
+	stack string
+	count int
+}
+
+// parseGoroutines groups a runtime.Stack(all) dump. The state is taken
+// from the "goroutine N [state, 5 minutes]:" header without the wait time.
+func parseGoroutines(dump []byte) []goroutineGroup {
+	groups := make(map[string]*goroutineGroup)
+	for _, block := range bytes.Split(dump, []byte("\n\n")) {
+		header, stack, _ := strings.Cut(string(block), "\n")
+		open := strings.IndexByte(header, '[')
+		end := strings.LastIndexByte(header, ']')
+		if !strings.HasPrefix(header, "goroutine ") || open < 0 || end < open {
+			continue
+		}
+		state, _, _ := strings.Cut(header[open+1:end], ",")
+		key := state + "\n" + stack
+		g, ok := groups[key]
+		if !ok {
+			g = &goroutineGroup{state: state, stack: stack}
+			groups[key] = g
+		}
+		g.count++
+	}
+
+	out := make([]goroutineGroup, 0, len(groups))
+	for _, g := range groups {
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].count != out[j].count {
+			return out[i].count > out[j].count
+		}
+		return out[i].state < out[j].state
+	})
+	return out
+}
+
+// writeGoroutineGroups prints a per-state summary followed by every
+// distinct stack, most common first.
+func writeGoroutineGroups(w io.Writer, groups []goroutineGroup) {
+	total := 0
+	byState := make(map[string]int)
+	for _, g := range groups {
+		byState[g.state] += g.count
+		total += g.count
+	}
+	states := make([]string, 0, len(byState))
+	for state := range byState {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return byState[states[i]] > byState[states[j]] })
+
+	fmt.Fprintf(w, "goroutines: %d\n", total)
+	for _, state := range states {
+		fmt.Fprintf(w, "  %-24s %d\n", state, byState[state])
+	}
+	for _, g := range groups {
+		fmt.Fprintf(w, "\n%d goroutine(s) [%s]:\n%s\n", g.count, g.state, strings.TrimRight(g.stack, "\n"))
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+// 导出路由并生成 client-go 的类型化请求方法（client-go/api）和服务端 handler 接口（handler）：
+//
+//	go generate
+//
+//go:generate go run . routes routes.json
+//go:generate go run ./cmd/routegen -mode client -pkg api -o ../client-go/api/routes_gen.go routes.json
+//go:generate go run ./cmd/routegen -mode server -pkg handler -o handler/routes_gen.go routes.json
@@ -0,0 +1,4 @@
+// Package handler holds the server handler interfaces generated from the
+// route export by cmd/routegen. Implement an interface and pass it to its
+// Register function to serve the routes as typed handlers.
+package handler
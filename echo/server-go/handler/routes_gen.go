@@ -0,0 +1,34 @@
+// Code generated by routegen from routes.json. DO NOT EDIT.
+
+package handler
+
+import "server-go/session"
+
+// Routes.
+const (
+	RouteHello = "connector.entryHandler.hello"
+)
+
+type HelloRequest struct {
+	Data string `json:"data"`
+}
+
+type HelloResponseMsg struct {
+	Data        string `json:"data,omitempty"`
+	ServerReqId uint32 `json:"serverReqId,omitempty"`
+}
+
+type HelloResponse struct {
+	Code int              `json:"code"`
+	Msg  HelloResponseMsg `json:"msg"`
+}
+
+// EntryHandler handles the connector.entryHandler routes.
+type EntryHandler interface {
+	Hello(s *session.Session, req *HelloRequest) *HelloResponse
+}
+
+// RegisterEntryHandler registers every route of h.
+func RegisterEntryHandler(h EntryHandler) {
+	session.RegisterTyped(RouteHello, h.Hello)
+}
@@ -0,0 +1,142 @@
+// Package health serves the liveness and readiness probes used by
+// container orchestration:
+//
+//	/livez   200 while the process is serving
+//	/readyz  200 when new clients should be sent here, 503 with the
+//	         reason otherwise (starting, draining, or a failed check such
+//	         as a limit)
+//
+// The same binary probes itself with Probe, since the runtime image has no
+// curl or wget.
+package health
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"server-go/logging"
+)
+
+var logger = logging.For("health")
+
+var (
+	ErrStarting = errors.New("starting")
+	ErrDraining = errors.New("draining")
+)
+
+// Check reports why the server should not take new clients, or nil.
+type Check func() error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+var (
+	checksLock sync.RWMutex
+	checks     []namedCheck
+
+	started  atomic.Bool
+	draining atomic.Bool
+)
+
+// AddCheck adds a readiness check. Checks run on every /readyz request
+// and should be cheap.
+func AddCheck(name string, check Check) {
+	checksLock.Lock()
+	checks = append(checks, namedCheck{name, check})
+	checksLock.Unlock()
+}
+
+// Started marks the game port as listening; readiness fails until then.
+func Started() {
+	started.Store(true)
+}
+
+// Drain marks the server as shutting down; readiness fails from now on.
+func Drain() {
+	draining.Store(true)
+}
+
+// Ready returns nil if the server is ready for new clients.
+func Ready() error {
+	if !started.Load() {
+		return ErrStarting
+	}
+	if draining.Load() {
+		return ErrDraining
+	}
+	checksLock.RLock()
+	defer checksLock.RUnlock()
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			return fmt.Errorf("%s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// Start serves the probes on addr until the process exits.
+func Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	logger.Info("Health probes listening", "addr", ln.Addr().String())
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			logger.Error("Health listener stopped", "err", err)
+		}
+	}()
+	return nil
+}
+
+// Probe requests path from the health listener on addr and returns an
+// error unless it answers 200. An empty host in addr means loopback.
+func Probe(addr, path string, timeout time.Duration) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+net.JoinHostPort(host, port)+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("%s: %s: %s", path, resp.Status, bytes.TrimSpace(reason))
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package health
+
+import (
+	"fmt"
+	"runtime/metrics"
+)
+
+// Limit fails once current() reaches max.
+func Limit(what string, current func() int64, max int64) Check {
+	return func() error {
+		if n := current(); n >= max {
+			return fmt.Errorf("%d %s, limit %d", n, what, max)
+		}
+		return nil
+	}
+}
+
+// HeapBytes returns the bytes held by live and not yet swept heap
+// objects. Unlike runtime.ReadMemStats it does not stop the world.
+func HeapBytes() int64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return int64(sample[0].Value.Uint64())
+}
@@ -0,0 +1,216 @@
+// Package logging wraps log/slog with per-component levels that can be
+// changed at runtime and a sampler for hot paths.
+//
+// Components get their logger once, usually in a package level var:
+//
+//	var logger = logging.For("session")
+//
+// Setup and the level setters may be called later and affect loggers that
+// already exist.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	mu           sync.Mutex
+	levels       = make(map[string]*slog.LevelVar)
+	defaultLevel = new(slog.LevelVar) // for components without their own level
+
+	base       atomic.Pointer[slog.Handler]
+	generation atomic.Uint64
+)
+
+func init() {
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	base.Store(&h)
+}
+
+// Setup sets where and how records are written. format is "text" or
+// "json". The standard log package is redirected as component "log".
+func Setup(w io.Writer, format string) error {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	switch format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+	base.Store(&h)
+	generation.Add(1)
+	slog.SetDefault(For("log"))
+	return nil
+}
+
+// For returns the logger of component. Records carry component=name.
+func For(component string) *slog.Logger {
+	return slog.New(&handler{
+		level: levelVar(component),
+		ops:   []op{{attrs: []slog.Attr{slog.String("component", component)}}},
+	})
+}
+
+func levelVar(component string) *slog.LevelVar {
+	mu.Lock()
+	defer mu.Unlock()
+	lv, ok := levels[component]
+	if !ok {
+		lv = new(slog.LevelVar)
+		lv.Set(defaultLevel.Level())
+		levels[component] = lv
+	}
+	return lv
+}
+
+// SetLevel sets the level of one component, or of all components and the
+// default when component is empty.
+func SetLevel(component string, level slog.Level) {
+	if component != "" {
+		levelVar(component).Set(level)
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	defaultLevel.Set(level)
+	for _, lv := range levels {
+		lv.Set(level)
+	}
+}
+
+// SetLevels applies a spec like "info,session=warn,client=error". An entry
+// without a component sets every level and is applied first.
+func SetLevels(spec string) error {
+	var global *slog.Level
+	perComponent := make(map[string]slog.Level)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		component, name, found := strings.Cut(entry, "=")
+		if !found {
+			component, name = "", entry
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return fmt.Errorf("invalid log level %q: %w", entry, err)
+		}
+		if component == "" {
+			global = &level
+		} else {
+			perComponent[component] = level
+		}
+	}
+	if global != nil {
+		SetLevel("", *global)
+	}
+	for component, level := range perComponent {
+		SetLevel(component, level)
+	}
+	return nil
+}
+
+// Levels returns the current level of every known component; the default
+// level is listed under "".
+func Levels() map[string]slog.Level {
+	mu.Lock()
+	defer mu.Unlock()
+	out := make(map[string]slog.Level, len(levels)+1)
+	out[""] = defaultLevel.Level()
+	for component, lv := range levels {
+		out[component] = lv.Level()
+	}
+	return out
+}
+
+// Shift moves every level by steps, 4 per step as slog levels go: a
+// positive value makes logging quieter, a negative one more verbose.
+func Shift(steps int) {
+	mu.Lock()
+	defer mu.Unlock()
+	shift := func(lv *slog.LevelVar) {
+		level := lv.Level() + slog.Level(4*steps)
+		if level < slog.LevelDebug {
+			level = slog.LevelDebug
+		} else if level > slog.LevelError+4 {
+			level = slog.LevelError + 4
+		}
+		lv.Set(level)
+	}
+	shift(defaultLevel)
+	for _, lv := range levels {
+		shift(lv)
+	}
+}
+
+// op is one WithAttrs or WithGroup call, replayed on the base handler
+// whenever Setup replaces it.
+type op struct {
+	attrs []slog.Attr
+	group string
+}
+
+type handler struct {
+	level *slog.LevelVar
+	ops   []op
+	cache atomic.Pointer[built]
+}
+
+type built struct {
+	generation uint64
+	h          slog.Handler
+}
+
+func (h *handler) inner() slog.Handler {
+	gen := generation.Load()
+	if b := h.cache.Load(); b != nil && b.generation == gen {
+		return b.h
+	}
+	inner := *base.Load()
+	for _, o := range h.ops {
+		if o.group != "" {
+			inner = inner.WithGroup(o.group)
+		} else {
+			inner = inner.WithAttrs(o.attrs)
+		}
+	}
+	h.cache.Store(&built{generation: gen, h: inner})
+	return inner
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	return h.inner().Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(op{attrs: attrs})
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(op{group: name})
+}
+
+func (h *handler) with(o op) *handler {
+	ops := make([]op, len(h.ops)+1)
+	copy(ops, h.ops)
+	ops[len(h.ops)] = o
+	return &handler{level: h.level, ops: ops}
+}
@@ -0,0 +1,6 @@
+//go:build windows
+
+package logging
+
+// WatchSignals does nothing on windows, which has no SIGUSR1/SIGUSR2.
+func WatchSignals() {}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package logging
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchSignals makes SIGUSR1 lower every level by one step (more output)
+// and SIGUSR2 raise it by one step (less output).
+func WatchSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range ch {
+			if sig == syscall.SIGUSR1 {
+				Shift(-1)
+			} else {
+				Shift(1)
+			}
+			For("logging").Info("log levels changed", "signal", sig.String(), "default", Levels()[""].String())
+		}
+	}()
+}
@@ -1,36 +1,59 @@
 package main
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
 	"syscall"
+	"time"
 
+	"server-go/accesslog"
+	"server-go/codec"
+	"server-go/diag"
+	"server-go/health"
+	"server-go/logging"
+	"server-go/netpoll"
 	"server-go/protocol"
 	"server-go/session"
+	"server-go/tracing"
+)
+
+var (
+	logger     = logging.For("main")
+	connLogger = logging.Sampled(logger, 10, 100)
 )
 
 func main() {
 	port := ":3010"
-	listener, err := net.Listen("tcp", "0.0.0.0"+port)
-	if err != nil {
-		log.Fatalf("Failed to listen on port %s: %v", port, err)
-	}
-	defer listener.Close()
-
-	log.Printf("[main] Server listening on port %s", port)
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// 容器健康检查：./server-go healthcheck 探测本机正在运行的服务端的 /readyz，
+	// 与服务端使用同一个 HEALTH_ADDR
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		addr := getEnv("HEALTH_ADDR", "")
+		if addr == "" {
+			fmt.Fprintln(os.Stderr, "HEALTH_ADDR is not set, health probes are off")
+			os.Exit(1)
+		}
+		if err := health.Probe(addr, "/readyz", 2*time.Second); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	go func() {
-		<-sigChan
-		log.Println("[main] Shutting down server...")
-		listener.Close()
-		os.Exit(0)
-	}()
+	// 日志：LOG_FORMAT=text/json，LOG_LEVEL 形如 "info,session=warn"；
+	// 运行中 SIGUSR1 / SIGUSR2 整体调低 / 调高一级
+	if err := logging.Setup(os.Stderr, getEnv("LOG_FORMAT", "text")); err != nil {
+		fatal("Failed to set up logging", "err", err)
+	}
+	if err := logging.SetLevels(getEnv("LOG_LEVEL", "info")); err != nil {
+		fatal("Failed to set log level", "err", err)
+	}
+	logging.WatchSignals()
 
 	// Register handlers
 	session.RegisterHandler("connector.entryHandler.hello", func(s *session.Session, body map[string]interface{}) map[string]interface{} {
@@ -42,20 +65,268 @@ func main() {
 			"msg":  body,
 		}
 	})
+	session.DescribeRoute("connector.entryHandler.hello", helloRequest{}, helloResponse{})
+
+	// 握手时下发路由字典，客户端可以用 2 字节编号代替路由字符串。默认 0 关闭，
+	// 与其他 echo 服务端一致，ROUTE_DICT=1 开启
+	session.SetRouteDict(getIntEnv("ROUTE_DICT", 0) != 0)
+
+	// body 编码：json / msgpack / protobuf，客户端可以在握手时协商
+	if err := setupCodecs(getEnv("CODEC", "json"), getEnv("PROTOS_DIR", "config")); err != nil {
+		fatal("Failed to set up codecs", "err", err)
+	}
+
+	// ./server-go routes [file] 输出所有路由、schema、路由字典和 protos（JSON），
+	// cmd/routegen 据此生成客户端和服务端代码
+	if len(os.Args) > 1 && os.Args[1] == "routes" {
+		if err := exportRoutes(os.Args[2:]); err != nil {
+			fatal("Failed to export routes", "err", err)
+		}
+		return
+	}
+
+	// 访问日志：ACCESS_LOG 为文件路径，不设置时关闭
+	var access *accesslog.Logger
+	if path := getEnv("ACCESS_LOG", ""); path != "" {
+		var err error
+		access, err = accesslog.Open(path, int64(getIntEnv("ACCESS_LOG_MAX_MB", 100))<<20, getIntEnv("ACCESS_LOG_BACKUPS", 5))
+		if err != nil {
+			fatal("Failed to open access log", "path", path, "err", err)
+		}
+		session.SetAccessLog(access)
+		logger.Info("Access log enabled", "path", path)
+	}
+
+	// 请求追踪：TRACE_FILE 为 span 输出文件（OTLP/JSON），只记录客户端带了 trace 的请求
+	var tracer *tracing.Tracer
+	if path := getEnv("TRACE_FILE", ""); path != "" {
+		var err error
+		tracer, err = tracing.Open(path, "server-go")
+		if err != nil {
+			fatal("Failed to open trace file", "path", path, "err", err)
+		}
+		session.SetTracer(tracer)
+		logger.Info("Tracing enabled", "path", path)
+	}
+
+	// 诊断接口（pprof 等）：DIAG_ADDR 只能是回环地址，不设置时关闭
+	if addr := getEnv("DIAG_ADDR", ""); addr != "" {
+		if err := diag.Start(addr, getEnv("DIAG_DIR", os.TempDir())); err != nil {
+			fatal("Failed to start diagnostics", "addr", addr, "err", err)
+		}
+	}
+
+	// 存活 / 就绪探针：超过 MAX_CONNECTIONS、MAX_HEAP_MB 或正在关闭时 /readyz 返回 503。
+	// HEALTH_ADDR 不设置时不监听，镜像里设置为 :3011
+	if max := getIntEnv("MAX_CONNECTIONS", 0); max > 0 {
+		health.AddCheck("connections", health.Limit("connections", session.Connections, int64(max)))
+	}
+	if max := getIntEnv("MAX_HEAP_MB", 0); max > 0 {
+		health.AddCheck("heap", health.Limit("heap bytes", health.HeapBytes, int64(max)<<20))
+	}
+	if addr := getEnv("HEALTH_ADDR", ""); addr != "" {
+		if err := health.Start(addr); err != nil {
+			fatal("Failed to start health probes", "addr", addr, "err", err)
+		}
+	}
+
+	// Handle graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		logger.Info("Shutting down server...")
+		// 先让就绪检查失败，给负载均衡留出摘除的时间；再收到一次信号立即退出
+		health.Drain()
+		if delay := time.Duration(getIntEnv("SHUTDOWN_DELAY", 3)) * time.Second; delay > 0 {
+			logger.Info("Draining before exit", "delay", delay.String())
+			select {
+			case <-time.After(delay):
+			case <-sigChan:
+			}
+		}
+		if access != nil {
+			session.SetAccessLog(nil)
+			access.Close()
+			if dropped := access.Dropped(); dropped > 0 {
+				logger.Warn("Access log lines dropped", "count", dropped)
+			}
+		}
+		if tracer != nil {
+			session.SetTracer(nil)
+			tracer.Close()
+		}
+		os.Exit(0)
+	}()
+
+	// 断线重连的保留时间，默认 0 关闭，与其他 echo 服务端一致，RESUME_GRACE=30 开启
+	session.SetResumeGrace(time.Duration(getIntEnv("RESUME_GRACE", 0)) * time.Second)
+
+	// 心跳策略：both（双方都发，默认）/ echo（客户端发、服务端回，与 pinus 一致）/ server（服务端发、客户端回）
+	policy, err := session.ParseHeartbeatPolicy(getEnv("HEARTBEAT_POLICY", "both"))
+	if err != nil {
+		fatal("Invalid heartbeat policy", "err", err)
+	}
+	session.SetHeartbeat(policy, time.Duration(getIntEnv("HEARTBEAT_INTERVAL", 10))*time.Second)
+
+	// 网络模式：goroutine（每个连接一个 goroutine，默认）或 epoll（少量事件循环）
+	switch mode := getEnv("NET_MODE", "goroutine"); mode {
+	case "goroutine":
+		serveGoroutine("0.0.0.0" + port)
+	case "epoll":
+		serveEpoll("0.0.0.0"+port, getIntEnv("EPOLL_LOOPS", runtime.NumCPU()))
+	default:
+		fatal("Unknown NET_MODE", "mode", mode)
+	}
+}
+
+func serveGoroutine(addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("Failed to listen", "addr", addr, "err", err)
+	}
+	defer listener.Close()
+
+	logger.Info("Server listening", "addr", addr)
+	health.Started()
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Printf("[main] Failed to accept connection: %v", err)
+			logger.Error("Failed to accept connection", "err", err)
 			continue
 		}
 
-		log.Printf("[main] Client connected: %s", conn.RemoteAddr())
+		connLogger.Info("Client connected", "remote", conn.RemoteAddr().String())
 		sess := session.NewSession(conn)
 		go sess.Start()
 	}
 }
 
+func serveEpoll(addr string, loops int) {
+	ln, err := netpoll.Listen(addr)
+	if err != nil {
+		fatal("Failed to listen", "addr", addr, "err", err)
+	}
+	logger.Info("Server listening", "addr", addr, "loops", loops)
+	health.Started()
+
+	err = ln.Serve(loops, func(conn net.Conn) netpoll.Handler {
+		connLogger.Info("Client connected", "remote", conn.RemoteAddr().String())
+		return session.NewPolledSession(conn)
+	})
+	fatal("Failed to serve", "addr", addr, "err", err)
+}
+
+// setupCodecs registers the pinus protobuf codec from the proto files in
+// protosDir and the protos generated for typed routes, and selects the
+// server default codec.
+func setupCodecs(defaultName, protosDir string) error {
+	clientProtos, err := loadProtos(filepath.Join(protosDir, "clientProtos.json"))
+	if err != nil {
+		return err
+	}
+	serverProtos, err := loadProtos(filepath.Join(protosDir, "serverProtos.json"))
+	if err != nil {
+		return err
+	}
+
+	// 有类型的路由自动生成 protos，配置文件中已有的路由以文件为准
+	genClient, genServer, err := session.RouteProtos()
+	if err != nil {
+		logger.Warn("Some routes have no generated protos", "err", err)
+	}
+	mergeProtos(clientProtos, genClient)
+	mergeProtos(serverProtos, genServer)
+	pb, err := codec.NewProtobuf(serverProtos, clientProtos, codec.JSON)
+	if err != nil {
+		return err
+	}
+	codec.Register(pb)
+
+	c, ok := codec.Get(defaultName)
+	if !ok {
+		return fmt.Errorf("unknown codec: %s", defaultName)
+	}
+	session.SetDefaultCodec(c)
+	return nil
+}
+
+// helloRequest and helloResponse only describe the hello route; its
+// handler echoes the body as a map.
+type helloRequest struct {
+	Data string `json:"data"`
+}
+
+type helloResponse struct {
+	Code int `json:"code"`
+	Msg  struct {
+		Data        string `json:"data,omitempty"`
+		ServerReqId uint32 `json:"serverReqId,omitempty"`
+	} `json:"msg"`
+}
+
+func exportRoutes(args []string) error {
+	if len(args) == 0 {
+		return session.ExportRoutes(os.Stdout)
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := session.ExportRoutes(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func mergeProtos(dst, src map[string]interface{}) {
+	for route, def := range src {
+		if _, ok := dst[route]; !ok {
+			dst[route] = def
+		}
+	}
+}
+
+func loadProtos(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]interface{}{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	protos := map[string]interface{}{}
+	if err := json.Unmarshal(data, &protos); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return protos, nil
+}
+
+func fatal(msg string, args ...interface{}) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getIntEnv(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		var result int
+		if _, err := fmt.Sscanf(value, "%d", &result); err == nil {
+			return result
+		}
+	}
+	return defaultValue
+}
+
 func init() {
 	// Initialize protocol
 	_ = protocol.Package{}
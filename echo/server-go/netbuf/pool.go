@@ -0,0 +1,48 @@
+package netbuf
+
+import "sync"
+
+// 按大小分级的缓冲池，超过最大级别的缓冲区不回收
+var sizeClasses = [...]int{512, 4 << 10, 64 << 10}
+
+var pools [len(sizeClasses)]sync.Pool
+
+func init() {
+	for i := range pools {
+		size := sizeClasses[i]
+		pools[i].New = func() interface{} {
+			b := make([]byte, 0, size)
+			return &b
+		}
+	}
+}
+
+// Get returns an empty buffer with at least size bytes of capacity.
+// Release it with Put once it is no longer referenced.
+func Get(size int) *[]byte {
+	for i, class := range sizeClasses {
+		if size <= class {
+			bp := pools[i].Get().(*[]byte)
+			*bp = (*bp)[:0]
+			return bp
+		}
+	}
+	b := make([]byte, 0, size)
+	return &b
+}
+
+// Put returns a buffer obtained from Get. Buffers that grew far past the
+// largest size class are dropped.
+func Put(bp *[]byte) {
+	c := cap(*bp)
+	if c > 2*sizeClasses[len(sizeClasses)-1] {
+		return
+	}
+	for i := len(sizeClasses) - 1; i >= 0; i-- {
+		if c >= sizeClasses[i] {
+			*bp = (*bp)[:0]
+			pools[i].Put(bp)
+			return
+		}
+	}
+}
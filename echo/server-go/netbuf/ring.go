@@ -0,0 +1,90 @@
+package netbuf
+
+// Ring is a byte ring buffer used to reassemble frames from a stream.
+// Reads go straight into its free space, and complete frames are handed
+// out as views into it, so the common case copies nothing.
+type Ring struct {
+	buf []byte
+	r   int // read offset
+	n   int // bytes buffered
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{buf: make([]byte, size)}
+}
+
+func (r *Ring) Len() int {
+	return r.n
+}
+
+func (r *Ring) Cap() int {
+	return len(r.buf)
+}
+
+// WriteSpace returns the largest contiguous free region. Pass it to
+// Read and then call Commit with the number of bytes read.
+func (r *Ring) WriteSpace() []byte {
+	if r.n == 0 {
+		r.r = 0
+	}
+	w := r.r + r.n
+	if w >= len(r.buf) {
+		w -= len(r.buf)
+		return r.buf[w:r.r]
+	}
+	return r.buf[w:]
+}
+
+func (r *Ring) Commit(n int) {
+	r.n += n
+}
+
+// At returns the i-th buffered byte.
+func (r *Ring) At(i int) byte {
+	i += r.r
+	if i >= len(r.buf) {
+		i -= len(r.buf)
+	}
+	return r.buf[i]
+}
+
+// View returns the next n buffered bytes as one slice. If they wrap
+// around the end of the ring they are copied into a pooled buffer, which
+// is returned as well and must be given back with Put after use.
+// The view is only valid until the next Discard.
+func (r *Ring) View(n int) ([]byte, *[]byte) {
+	if r.r+n <= len(r.buf) {
+		return r.buf[r.r : r.r+n], nil
+	}
+	bp := Get(n)
+	first := len(r.buf) - r.r
+	b := append(*bp, r.buf[r.r:]...)
+	b = append(b, r.buf[:n-first]...)
+	*bp = b
+	return b, bp
+}
+
+func (r *Ring) Discard(n int) {
+	r.r += n
+	if r.r >= len(r.buf) {
+		r.r -= len(r.buf)
+	}
+	r.n -= n
+}
+
+// Grow makes room for at least size buffered bytes, for frames larger
+// than the ring.
+func (r *Ring) Grow(size int) {
+	if size <= len(r.buf) {
+		return
+	}
+	newSize := len(r.buf) * 2
+	for newSize < size {
+		newSize *= 2
+	}
+	buf := make([]byte, newSize)
+	first := copy(buf, r.buf[r.r:min(r.r+r.n, len(r.buf))])
+	copy(buf[first:], r.buf[:r.n-first])
+	r.buf = buf
+	r.r = 0
+}
@@ -0,0 +1,40 @@
+// Package netpoll serves TCP connections from a few epoll event loops
+// instead of one goroutine per connection, so that a single process can
+// hold a very large number of mostly idle sessions.
+//
+// Handlers run on the event loop goroutine and must not block. Timeouts
+// and heartbeats are left to the handler, e.g. on a timewheel.
+package netpoll
+
+import (
+	"errors"
+	"net"
+)
+
+// Handler receives the events of one connection.
+type Handler interface {
+	// Feed is called with newly read bytes. data is only valid during
+	// the call.
+	Feed(data []byte)
+	// Close is called when the peer closed the connection or reading
+	// failed. It is expected to close the net.Conn.
+	Close()
+}
+
+// AcceptFunc builds the handler for a new connection.
+type AcceptFunc func(conn net.Conn) Handler
+
+// Listener is a bound listening socket served by Serve.
+type Listener struct {
+	fd int
+}
+
+// maxPendingWrite is how much unsent output a connection may queue before
+// it is dropped as a slow consumer.
+const maxPendingWrite = 4 << 20
+
+var (
+	ErrClosed      = errors.New("netpoll: connection closed")
+	ErrSlowReader  = errors.New("netpoll: write buffer full")
+	ErrUnsupported = errors.New("netpoll: epoll mode is only supported on linux")
+)
@@ -0,0 +1,385 @@
+//go:build linux
+
+package netpoll
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"server-go/logging"
+)
+
+var logger = logging.For("netpoll")
+
+const readBufferSize = 64 << 10
+
+// lingerTimeout bounds how long Close waits for queued output to drain.
+const lingerTimeout = 5 * time.Second
+
+// Serve listens on addr and serves connections from the given number of
+// event loops. It only returns on a listen or accept error.
+func Serve(addr string, loops int, accept AcceptFunc) error {
+	ln, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+	return ln.Serve(loops, accept)
+}
+
+// Listen binds addr. Connections queue in the backlog until Serve.
+func Listen(addr string) (*Listener, error) {
+	fd, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{fd: fd}, nil
+}
+
+// Serve serves connections from the given number of event loops and
+// closes the listener when it returns on an accept error.
+func (ln *Listener) Serve(loops int, accept AcceptFunc) error {
+	lfd := ln.fd
+	defer syscall.Close(lfd)
+
+	if loops < 1 {
+		loops = 1
+	}
+	eventLoops := make([]*loop, loops)
+	for i := range eventLoops {
+		l, err := newLoop()
+		if err != nil {
+			return err
+		}
+		eventLoops[i] = l
+		go l.run()
+	}
+
+	// 监听 fd 是非阻塞的，用单独的 epoll 等待新连接
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(epfd)
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, lfd,
+		&syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(lfd)}); err != nil {
+		return err
+	}
+
+	events := make([]syscall.EpollEvent, 1)
+	next := 0
+	for {
+		if _, err := syscall.EpollWait(epfd, events, -1); err != nil && err != syscall.EINTR {
+			return err
+		}
+		for {
+			fd, sa, err := syscall.Accept4(lfd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+			if err != nil {
+				if err == syscall.EAGAIN || err == syscall.EINTR || err == syscall.ECONNABORTED {
+					break
+				}
+				if err == syscall.EMFILE || err == syscall.ENFILE {
+					logger.Error("Accept error", "err", err)
+					time.Sleep(10 * time.Millisecond)
+					break
+				}
+				return err
+			}
+			syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1)
+
+			l := eventLoops[next%len(eventLoops)]
+			next++
+			c := &conn{fd: fd, loop: l, remote: sockaddrToTCPAddr(sa)}
+			l.add(c, accept(c))
+		}
+	}
+}
+
+func listen(addr string) (int, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return -1, err
+	}
+
+	family := syscall.AF_INET
+	var sa syscall.Sockaddr
+	if ip4 := tcpAddr.IP.To4(); ip4 != nil || tcpAddr.IP == nil {
+		sa4 := &syscall.SockaddrInet4{Port: tcpAddr.Port}
+		copy(sa4.Addr[:], ip4)
+		sa = sa4
+	} else {
+		family = syscall.AF_INET6
+		sa6 := &syscall.SockaddrInet6{Port: tcpAddr.Port}
+		copy(sa6.Addr[:], tcpAddr.IP.To16())
+		sa = sa6
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if err := syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+func sockaddrToTCPAddr(sa syscall.Sockaddr) *net.TCPAddr {
+	switch a := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return &net.TCPAddr{IP: net.IP(a.Addr[:]).To16(), Port: a.Port}
+	case *syscall.SockaddrInet6:
+		return &net.TCPAddr{IP: net.IP(a.Addr[:]), Port: a.Port}
+	}
+	return &net.TCPAddr{}
+}
+
+type entry struct {
+	conn    *conn
+	handler Handler
+}
+
+// loop owns one epoll instance and the connections registered with it.
+type loop struct {
+	epfd    int
+	mu      sync.Mutex
+	entries map[int]entry
+	buf     []byte
+}
+
+func newLoop() (*loop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &loop{
+		epfd:    epfd,
+		entries: make(map[int]entry),
+		buf:     make([]byte, readBufferSize),
+	}, nil
+}
+
+func (l *loop) add(c *conn, h Handler) {
+	l.mu.Lock()
+	l.entries[c.fd] = entry{conn: c, handler: h}
+	l.mu.Unlock()
+	if err := l.ctl(syscall.EPOLL_CTL_ADD, c.fd, syscall.EPOLLIN|syscall.EPOLLRDHUP); err != nil {
+		logger.Error("Failed to register connection", "err", err)
+		h.Close()
+	}
+}
+
+func (l *loop) remove(fd int) {
+	l.mu.Lock()
+	delete(l.entries, fd)
+	l.mu.Unlock()
+}
+
+func (l *loop) get(fd int) (entry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[fd]
+	return e, ok
+}
+
+func (l *loop) ctl(op int, fd int, events uint32) error {
+	return syscall.EpollCtl(l.epfd, op, fd, &syscall.EpollEvent{Events: events, Fd: int32(fd)})
+}
+
+func (l *loop) run() {
+	events := make([]syscall.EpollEvent, 256)
+	for {
+		n, err := syscall.EpollWait(l.epfd, events, -1)
+		if err != nil && err != syscall.EINTR {
+			logger.Error("EpollWait error", "err", err)
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			e, ok := l.get(int(events[i].Fd))
+			if !ok {
+				continue
+			}
+			ev := events[i].Events
+			if ev&syscall.EPOLLOUT != 0 {
+				if err := e.conn.flush(); err != nil {
+					e.handler.Close()
+					continue
+				}
+			}
+			if ev&(syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+				l.read(e)
+			}
+		}
+	}
+}
+
+func (l *loop) read(e entry) {
+	n, err := syscall.Read(e.conn.fd, l.buf)
+	switch {
+	case n > 0:
+		e.handler.Feed(l.buf[:n])
+	case err == syscall.EAGAIN || err == syscall.EINTR:
+	default:
+		// n == 0 is EOF. 正在等待发送完的连接也不用再等了
+		e.handler.Close()
+		e.conn.shutdown()
+	}
+}
+
+// conn is the net.Conn handed to handlers. Reads are driven by the loop;
+// Write is safe for concurrent use and queues output the socket could
+// not take yet.
+type conn struct {
+	fd     int
+	loop   *loop
+	remote net.Addr
+
+	mu        sync.Mutex
+	closed    bool
+	lingering bool // closed, but queued output is still being flushed
+	pending   []byte
+	shutOnce  sync.Once
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	return 0, syscall.EINVAL
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, ErrClosed
+	}
+	if len(c.pending) > 0 {
+		return c.queue(b)
+	}
+
+	written := 0
+	for written < len(b) {
+		n, err := syscall.Write(c.fd, b[written:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			if _, err := c.queue(b[written:]); err != nil {
+				return written, err
+			}
+			c.loop.ctl(syscall.EPOLL_CTL_MOD, c.fd, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLOUT)
+			return len(b), nil
+		}
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (c *conn) queue(b []byte) (int, error) {
+	if len(c.pending)+len(b) > maxPendingWrite {
+		return 0, ErrSlowReader
+	}
+	c.pending = append(c.pending, b...)
+	return len(b), nil
+}
+
+// flush writes queued output once the socket is writable again. A
+// lingering connection is shut down once its output is gone.
+func (c *conn) flush() error {
+	c.mu.Lock()
+	if c.closed && !c.lingering {
+		c.mu.Unlock()
+		return nil
+	}
+	for len(c.pending) > 0 {
+		n, err := syscall.Write(c.fd, c.pending)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			c.mu.Unlock()
+			return nil
+		}
+		if err != nil {
+			lingering := c.lingering
+			c.mu.Unlock()
+			if lingering {
+				c.shutdown()
+			}
+			return err
+		}
+		c.pending = c.pending[n:]
+	}
+	c.pending = nil
+	if c.lingering {
+		c.mu.Unlock()
+		return c.shutdown()
+	}
+	c.mu.Unlock()
+	return c.loop.ctl(syscall.EPOLL_CTL_MOD, c.fd, syscall.EPOLLIN|syscall.EPOLLRDHUP)
+}
+
+// Close stops reading at once. Output that is still queued, like a kick
+// package, is flushed first for up to lingerTimeout.
+func (c *conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	if len(c.pending) > 0 {
+		c.lingering = true
+		c.mu.Unlock()
+		c.loop.ctl(syscall.EPOLL_CTL_MOD, c.fd, syscall.EPOLLOUT)
+		time.AfterFunc(lingerTimeout, func() { c.shutdown() })
+		return nil
+	}
+	c.mu.Unlock()
+	return c.shutdown()
+}
+
+// shutdown releases the fd. It is safe to call more than once.
+func (c *conn) shutdown() error {
+	var err error
+	c.shutOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		c.pending = nil
+		c.mu.Unlock()
+
+		c.loop.ctl(syscall.EPOLL_CTL_DEL, c.fd, 0)
+		c.loop.remove(c.fd)
+		err = syscall.Close(c.fd)
+	})
+	return err
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	sa, err := syscall.Getsockname(c.fd)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return sockaddrToTCPAddr(sa)
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Deadlines are not supported; the handler keeps its own timers.
+func (c *conn) SetDeadline(t time.Time) error      { return nil }
+func (c *conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *conn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package netpoll
+
+// Serve is only implemented on linux.
+func Serve(addr string, loops int, accept AcceptFunc) error {
+	return ErrUnsupported
+}
+
+// Listen is only implemented on linux.
+func Listen(addr string) (*Listener, error) {
+	return nil, ErrUnsupported
+}
+
+// Serve is only implemented on linux.
+func (ln *Listener) Serve(loops int, accept AcceptFunc) error {
+	return ErrUnsupported
+}
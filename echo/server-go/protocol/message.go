@@ -11,20 +11,29 @@ type Message struct {
 	ID            int
 	Type          int
 	CompressRoute bool
+	RouteCode     int // dict code of a compressed route; Route is then empty
 	Route         string
 	Body          []byte
 	CompressGzip  bool
 }
 
-func MessageEncode(id int, msgType int, compressRoute bool, route string, body []byte) []byte {
-	var result []byte
+func MessageEncode(id int, msgType int, routeCode int, route string, body []byte) []byte {
+	result := MessageAppendHead(make([]byte, 0, 1+5+1+len(route)+len(body)), id, msgType, routeCode, route)
+	return append(result, body...)
+}
+
+// MessageAppendHead appends the flag, id and route of a message to dst.
+// A non-zero routeCode is written instead of route, as a dict compressed
+// route. The body is expected to be appended right after it.
+func MessageAppendHead(dst []byte, id int, msgType int, routeCode int, route string) []byte {
+	compressRoute := routeCode > 0
 
 	// Encode flag: type(3 bits) << 1 | compressRoute(1 bit)
 	flag := byte(msgType << 1)
 	if compressRoute {
 		flag |= 1
 	}
-	result = append(result, flag)
+	dst = append(dst, flag)
 
 	// Encode id (base128, only for REQUEST/RESPONSE)
 	if msgType == MessageTypeRequest || msgType == MessageTypeResponse {
@@ -35,7 +44,7 @@ func MessageEncode(id int, msgType int, compressRoute bool, route string, body [
 			if next != 0 {
 				tmp += 128
 			}
-			result = append(result, byte(tmp))
+			dst = append(dst, byte(tmp))
 			idVal = next
 			if idVal == 0 {
 				break
@@ -47,29 +56,31 @@ func MessageEncode(id int, msgType int, compressRoute bool, route string, body [
 	if msgType == MessageTypeRequest || msgType == MessageTypeNotify || msgType == MessageTypePush {
 		if compressRoute {
 			// Compressed route: 2 bytes (big-endian)
-			routeNum := 0 // Assuming route is a number when compressed
-			result = append(result, byte((routeNum>>8)&0xFF))
-			result = append(result, byte(routeNum&0xFF))
+			dst = append(dst, byte((routeCode>>8)&0xFF), byte(routeCode&0xFF))
 		} else {
 			// Full route string: 1 byte length + route string
-			routeBytes := []byte(route)
-			result = append(result, byte(len(routeBytes)))
-			result = append(result, routeBytes...)
+			dst = append(dst, byte(len(route)))
+			dst = append(dst, route...)
 		}
 	}
 
-	// Encode body
-	if body != nil {
-		result = append(result, body...)
-	}
-
-	return result
+	return dst
 }
 
 func MessageDecode(data []byte) *Message {
-	if len(data) < 1 {
+	msg := &Message{}
+	if !MessageParse(data, msg) {
 		return nil
 	}
+	return msg
+}
+
+// MessageParse decodes data into msg without allocating. msg.Body
+// aliases data, and routes are interned.
+func MessageParse(data []byte, msg *Message) bool {
+	if len(data) < 1 {
+		return false
+	}
 
 	offset := 0
 
@@ -87,7 +98,7 @@ func MessageDecode(data []byte) *Message {
 		i := 0
 		for {
 			if offset >= len(data) {
-				return nil
+				return false
 			}
 			m := data[offset]
 			id += int(m&0x7F) << (7 * i)
@@ -101,28 +112,28 @@ func MessageDecode(data []byte) *Message {
 
 	// Parse route (only for REQUEST/NOTIFY/PUSH)
 	var route string
+	routeCode := 0
 	if msgType == MessageTypeRequest || msgType == MessageTypeNotify || msgType == MessageTypePush {
 		if compressRoute {
 			// Compressed route: 2 bytes (big-endian)
 			if offset+2 > len(data) {
-				return nil
+				return false
 			}
-			// routeNum := (int(data[offset]) << 8) | int(data[offset+1])
-			// route = strconv.Itoa(routeNum)
+			routeCode = int(data[offset])<<8 | int(data[offset+1])
 			offset += 2
 		} else {
 			// Full route string: 1 byte length + route string
 			if offset >= len(data) {
-				return nil
+				return false
 			}
 			routeLen := int(data[offset])
 			offset++
 
 			if routeLen > 0 {
 				if offset+routeLen > len(data) {
-					return nil
+					return false
 				}
-				route = string(data[offset : offset+routeLen])
+				route = internRoute(data[offset : offset+routeLen])
 				offset += routeLen
 			}
 		}
@@ -134,13 +145,14 @@ func MessageDecode(data []byte) *Message {
 		body = data[offset:]
 	}
 
-	return &Message{
+	*msg = Message{
 		ID:            id,
 		Type:          msgType,
 		CompressRoute: compressRoute,
+		RouteCode:     routeCode,
 		Route:         route,
 		Body:          body,
 		CompressGzip:  compressGzip,
 	}
+	return true
 }
-
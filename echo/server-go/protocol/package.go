@@ -8,6 +8,9 @@ const (
 	PackageTypeKick         = 5
 )
 
+// PackageHeadSize is the size of the type + 3 byte length header.
+const PackageHeadSize = 4
+
 type Package struct {
 	Type   byte
 	Length int
@@ -15,37 +18,38 @@ type Package struct {
 }
 
 func PackageEncode(pkgType byte, body []byte) []byte {
-	bodyLen := 0
-	if body != nil {
-		bodyLen = len(body)
-	}
-
-	result := make([]byte, 4+bodyLen)
-	result[0] = pkgType
-	result[1] = byte((bodyLen >> 16) & 0xFF)
-	result[2] = byte((bodyLen >> 8) & 0xFF)
-	result[3] = byte(bodyLen & 0xFF)
+	return PackageAppend(make([]byte, 0, PackageHeadSize+len(body)), pkgType, body)
+}
 
-	if bodyLen > 0 {
-		copy(result[4:], body)
-	}
+// PackageAppend appends the encoded package to dst.
+func PackageAppend(dst []byte, pkgType byte, body []byte) []byte {
+	bodyLen := len(body)
+	dst = append(dst, pkgType, byte((bodyLen>>16)&0xFF), byte((bodyLen>>8)&0xFF), byte(bodyLen&0xFF))
+	return append(dst, body...)
+}
 
-	return result
+// PutPackageHead writes a package header into the first PackageHeadSize
+// bytes of dst, for bodies that were encoded in place after it.
+func PutPackageHead(dst []byte, pkgType byte, bodyLen int) {
+	dst[0] = pkgType
+	dst[1] = byte((bodyLen >> 16) & 0xFF)
+	dst[2] = byte((bodyLen >> 8) & 0xFF)
+	dst[3] = byte(bodyLen & 0xFF)
 }
 
 func PackageDecode(data []byte) *Package {
-	if len(data) < 4 {
+	if len(data) < PackageHeadSize {
 		return nil
 	}
 
 	pkgType := data[0]
 	length := (int(data[1]) << 16) | (int(data[2]) << 8) | int(data[3])
 
-	if len(data) < 4+length {
+	if len(data) < PackageHeadSize+length {
 		return nil
 	}
 
-	body := data[4 : 4+length]
+	body := data[PackageHeadSize : PackageHeadSize+length]
 
 	return &Package{
 		Type:   pkgType,
@@ -53,4 +57,3 @@ func PackageDecode(data []byte) *Package {
 		Body:   body,
 	}
 }
-
@@ -0,0 +1,28 @@
+package protocol
+
+import "sync"
+
+// 路由数量有限，缓存 route 字符串避免每条消息都分配一次
+const maxInternedRoutes = 4096
+
+var (
+	routes     = make(map[string]string)
+	routesLock sync.RWMutex
+)
+
+func internRoute(b []byte) string {
+	routesLock.RLock()
+	route, ok := routes[string(b)]
+	routesLock.RUnlock()
+	if ok {
+		return route
+	}
+
+	route = string(b)
+	routesLock.Lock()
+	if len(routes) < maxInternedRoutes {
+		routes[route] = route
+	}
+	routesLock.Unlock()
+	return route
+}
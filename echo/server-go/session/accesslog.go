@@ -0,0 +1,44 @@
+package session
+
+import (
+	"sync/atomic"
+	"time"
+
+	"server-go/accesslog"
+)
+
+var accessLog atomic.Pointer[accesslog.Logger]
+
+// SetAccessLog starts writing one access log line per request and notify.
+// nil turns it off.
+func SetAccessLog(l *accesslog.Logger) {
+	accessLog.Store(l)
+}
+
+func (s *Session) logAccess(l *accesslog.Logger, start time.Time, typ string, route string, id int, reqSize, respSize, code int, latency time.Duration) {
+	l.Log(&accesslog.Entry{
+		Time:      start,
+		SessionID: s.id,
+		UID:       s.UID(),
+		Type:      typ,
+		Route:     route,
+		MsgID:     id,
+		ReqSize:   reqSize,
+		RespSize:  respSize,
+		Code:      code,
+		Latency:   latency,
+	})
+}
+
+// responseCode reads the code field handlers put in their response.
+func responseCode(body map[string]interface{}) int {
+	switch code := body["code"].(type) {
+	case int:
+		return code
+	case int64:
+		return int(code)
+	case float64:
+		return int(code)
+	}
+	return 0
+}
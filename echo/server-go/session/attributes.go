@@ -0,0 +1,189 @@
+package session
+
+import (
+	"sync"
+)
+
+// ChangeHook is called after a key is set or removed. new is nil when
+// the key was removed.
+type ChangeHook func(key string, old, new interface{})
+
+// PushHook is called by Push with the committed values, the way a pinus
+// backend session syncs changed keys back to the frontend session.
+type PushHook func(changes map[string]interface{})
+
+// Attributes is a concurrency-safe key/value store attached to a Session.
+//
+// Set changes the value locally and marks the key dirty; Push commits the
+// dirty keys and hands them to the push hooks.
+type Attributes struct {
+	mu          sync.RWMutex
+	values      map[string]interface{}
+	dirty       map[string]struct{}
+	changeHooks []ChangeHook
+	pushHooks   []PushHook
+}
+
+func newAttributes() *Attributes {
+	return &Attributes{
+		values: make(map[string]interface{}),
+		dirty:  make(map[string]struct{}),
+	}
+}
+
+func (a *Attributes) Set(key string, value interface{}) {
+	a.mu.Lock()
+	old := a.values[key]
+	a.values[key] = value
+	a.dirty[key] = struct{}{}
+	hooks := a.changeHooks
+	a.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(key, old, value)
+	}
+}
+
+func (a *Attributes) Remove(key string) {
+	a.mu.Lock()
+	old, ok := a.values[key]
+	if !ok {
+		a.mu.Unlock()
+		return
+	}
+	delete(a.values, key)
+	a.dirty[key] = struct{}{}
+	hooks := a.changeHooks
+	a.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(key, old, nil)
+	}
+}
+
+func (a *Attributes) Get(key string) (interface{}, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	v, ok := a.values[key]
+	return v, ok
+}
+
+func (a *Attributes) GetString(key string) string {
+	v, _ := a.Get(key)
+	s, _ := v.(string)
+	return s
+}
+
+// GetInt returns the value as an int. Numbers restored from JSON come
+// back as float64, so those are accepted as well.
+func (a *Attributes) GetInt(key string) int {
+	v, _ := a.Get(key)
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+func (a *Attributes) GetFloat(key string) float64 {
+	v, _ := a.Get(key)
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return 0
+}
+
+func (a *Attributes) GetBool(key string) bool {
+	v, _ := a.Get(key)
+	b, _ := v.(bool)
+	return b
+}
+
+// Keys returns all keys currently set.
+func (a *Attributes) Keys() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	keys := make([]string, 0, len(a.values))
+	for k := range a.values {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (a *Attributes) OnChange(hook ChangeHook) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.changeHooks = append(a.changeHooks, hook)
+}
+
+func (a *Attributes) OnPush(hook PushHook) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pushHooks = append(a.pushHooks, hook)
+}
+
+// Push commits the given dirty keys. Removed keys are reported with a nil
+// value. Keys that were not changed since the last push are skipped.
+func (a *Attributes) Push(keys ...string) {
+	a.mu.Lock()
+	changes := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if _, ok := a.dirty[key]; !ok {
+			continue
+		}
+		delete(a.dirty, key)
+		changes[key] = a.values[key]
+	}
+	hooks := a.pushHooks
+	a.mu.Unlock()
+
+	if len(changes) == 0 {
+		return
+	}
+	for _, hook := range hooks {
+		hook(changes)
+	}
+}
+
+// PushAll commits every dirty key.
+func (a *Attributes) PushAll() {
+	a.mu.RLock()
+	keys := make([]string, 0, len(a.dirty))
+	for key := range a.dirty {
+		keys = append(keys, key)
+	}
+	a.mu.RUnlock()
+	a.Push(keys...)
+}
+
+// Snapshot returns a copy of all values. The copy is shallow and can be
+// marshaled to move the attributes to another node.
+func (a *Attributes) Snapshot() map[string]interface{} {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	snapshot := make(map[string]interface{}, len(a.values))
+	for k, v := range a.values {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// Restore replaces all values with snapshot without firing hooks.
+func (a *Attributes) Restore(snapshot map[string]interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.values = make(map[string]interface{}, len(snapshot))
+	for k, v := range snapshot {
+		a.values[k] = v
+	}
+	a.dirty = make(map[string]struct{})
+}
@@ -0,0 +1,183 @@
+package session
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"server-go/codec"
+	"server-go/logging"
+	"server-go/protocol"
+)
+
+// 以下基准测量每次 echo 往返和每个心跳的耗时与内存分配，legacy 是
+// 改用缓冲池之前逐次 append 再切片的读写路径。
+//
+//	go test -run '^$' -bench . -benchmem ./session
+
+const benchRoute = "connector.entryHandler.hello"
+
+var benchSetup sync.Once
+
+func setupBench() {
+	benchSetup.Do(func() {
+		logging.SetLevel("", slog.LevelWarn)
+		SetResumeGrace(0)
+		RegisterHandler(benchRoute, func(s *Session, body map[string]interface{}) map[string]interface{} {
+			s.ReqId++
+			body["serverReqId"] = s.ReqId
+			return map[string]interface{}{
+				"code": 0,
+				"msg":  body,
+			}
+		})
+	})
+}
+
+func benchRequest(c codec.Codec) []byte {
+	body, _ := c.Encode(benchRoute, map[string]interface{}{"data": "world1"})
+	return protocol.PackageEncode(protocol.PackageTypeData,
+		protocol.MessageEncode(1, protocol.MessageTypeRequest, 0, benchRoute, body))
+}
+
+func BenchmarkEcho(b *testing.B) {
+	setupBench()
+	b.Run("legacy", func(b *testing.B) { benchLegacy(b, benchRequest(codec.JSON)) })
+	b.Run("session", func(b *testing.B) { benchSession(b, "json", benchRequest(codec.JSON)) })
+	b.Run("session-msgpack", func(b *testing.B) { benchSession(b, "msgpack", benchRequest(codec.MsgPack)) })
+}
+
+func BenchmarkHeartbeat(b *testing.B) {
+	setupBench()
+	heartbeat := protocol.PackageEncode(protocol.PackageTypeHeartbeat, nil)
+	b.Run("legacy", func(b *testing.B) { benchLegacy(b, heartbeat) })
+	b.Run("session", func(b *testing.B) { benchSession(b, "json", heartbeat) })
+	b.Run("tick", benchHeartbeatTick)
+}
+
+func benchSession(b *testing.B, codecName string, frame []byte) {
+	handshake := protocol.PackageEncode(protocol.PackageTypeHandshake,
+		[]byte(`{"sys":{"codecs":["`+codecName+`"]}}`))
+	ack := protocol.PackageEncode(protocol.PackageTypeHandshakeAck, nil)
+	conn := newReplayConn(frame, b.N, handshake, ack)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	NewSession(conn).Start()
+}
+
+// benchHeartbeatTick measures the heartbeat the server sends on its own,
+// from the timewheel callback.
+func benchHeartbeatTick(b *testing.B) {
+	conn := newReplayConn(nil, 0)
+	s := NewSession(conn)
+	defer s.Close()
+	s.mu.Lock()
+	s.state = StateWorking
+	s.heartbeatPolicy = HeartbeatBoth
+	s.heartbeatTimeout = time.Hour
+	s.lastHeartbeat = time.Now()
+	s.mu.Unlock()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.heartbeatTick()
+	}
+}
+
+func benchLegacy(b *testing.B, frame []byte) {
+	conn := newReplayConn(frame, b.N)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	legacyStart(conn)
+}
+
+// legacyStart is the read loop as it was before pooling: every read is
+// appended to a growing slice and every message allocates on decode and
+// encode.
+func legacyStart(conn net.Conn) {
+	buf := make([]byte, 4096)
+	var dataBuf []byte
+	reqId := 0
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		dataBuf = append(dataBuf, buf[:n]...)
+
+		for len(dataBuf) >= 4 {
+			pkgLen := (int(dataBuf[1]) << 16) | (int(dataBuf[2]) << 8) | int(dataBuf[3])
+			totalLen := 4 + pkgLen
+			if len(dataBuf) < totalLen {
+				break
+			}
+
+			pkg := protocol.PackageDecode(dataBuf[:totalLen])
+			switch pkg.Type {
+			case protocol.PackageTypeHeartbeat:
+				conn.Write(protocol.PackageEncode(protocol.PackageTypeHeartbeat, nil))
+			case protocol.PackageTypeData:
+				msg := protocol.MessageDecode(pkg.Body)
+				var body map[string]interface{}
+				json.Unmarshal(msg.Body, &body)
+				reqId++
+				body["serverReqId"] = reqId
+				resp, _ := json.Marshal(map[string]interface{}{"code": 0, "msg": body})
+				respMsg := protocol.MessageEncode(msg.ID, protocol.MessageTypeResponse, 0, "", resp)
+				conn.Write(protocol.PackageEncode(protocol.PackageTypeData, respMsg))
+			}
+			dataBuf = dataBuf[totalLen:]
+		}
+	}
+}
+
+// replayConn is an in-memory connection that returns the prelude frames
+// and then frame count times, one frame per Read, and discards writes.
+type replayConn struct {
+	frames [][]byte
+	frame  []byte
+	count  int
+	off    int
+}
+
+func newReplayConn(frame []byte, count int, prelude ...[]byte) *replayConn {
+	return &replayConn{frames: prelude, frame: frame, count: count}
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	if len(c.frames) > 0 {
+		n := copy(p, c.frames[0])
+		c.frames = c.frames[1:]
+		return n, nil
+	}
+	if c.off == 0 {
+		if c.count == 0 {
+			return 0, io.EOF
+		}
+		c.count--
+	}
+	n := copy(p, c.frame[c.off:])
+	c.off += n
+	if c.off == len(c.frame) {
+		c.off = 0
+	}
+	return n, nil
+}
+
+func (c *replayConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *replayConn) Close() error                       { return nil }
+func (c *replayConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *replayConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,119 @@
+package session
+
+import (
+	"errors"
+	"sync"
+
+	"server-go/codec"
+)
+
+var ErrNotBound = errors.New("session is not bound to a uid")
+
+// Channel is a named group of uids that receive the same pushes,
+// modeled after pinus channelService.
+type Channel struct {
+	name string
+	mu   sync.RWMutex
+	uids map[string]struct{}
+}
+
+var (
+	channels     = make(map[string]*Channel)
+	channelsLock sync.Mutex
+)
+
+// GetChannel returns the channel called name, creating it if create is set.
+func GetChannel(name string, create bool) *Channel {
+	channelsLock.Lock()
+	defer channelsLock.Unlock()
+	c, ok := channels[name]
+	if !ok && create {
+		c = &Channel{name: name, uids: make(map[string]struct{})}
+		channels[name] = c
+	}
+	return c
+}
+
+// DestroyChannel removes the channel and all of its memberships.
+func DestroyChannel(name string) {
+	channelsLock.Lock()
+	c, ok := channels[name]
+	delete(channels, name)
+	channelsLock.Unlock()
+	if !ok {
+		return
+	}
+	for _, uid := range c.Members() {
+		c.Leave(uid)
+	}
+}
+
+func (c *Channel) Name() string {
+	return c.name
+}
+
+// Add puts the session's bound uid into the channel.
+func (c *Channel) Add(s *Session) error {
+	uid := s.UID()
+	if uid == "" {
+		return ErrNotBound
+	}
+	c.mu.Lock()
+	c.uids[uid] = struct{}{}
+	c.mu.Unlock()
+
+	s.mu.Lock()
+	if s.channels == nil {
+		s.channels = make(map[string]struct{})
+	}
+	s.channels[c.name] = struct{}{}
+	s.mu.Unlock()
+	return nil
+}
+
+// Leave removes uid from the channel.
+func (c *Channel) Leave(uid string) {
+	c.mu.Lock()
+	delete(c.uids, uid)
+	c.mu.Unlock()
+
+	if s := GetByUid(uid); s != nil {
+		s.mu.Lock()
+		delete(s.channels, c.name)
+		s.mu.Unlock()
+	}
+}
+
+func (c *Channel) Members() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	uids := make([]string, 0, len(c.uids))
+	for uid := range c.uids {
+		uids = append(uids, uid)
+	}
+	return uids
+}
+
+// PushMessage pushes msg to every member. Members that are waiting to
+// resume get the push queued and delivered after they come back.
+// The message is encoded once per codec in use by the members.
+func (c *Channel) PushMessage(route string, msg interface{}) error {
+	encoded := make(map[codec.Codec][]byte)
+	for _, uid := range c.Members() {
+		s := GetByUid(uid)
+		if s == nil {
+			continue
+		}
+		bodyCodec := s.codecFor(route)
+		data, ok := encoded[bodyCodec]
+		if !ok {
+			var err error
+			if data, err = encodePush(bodyCodec, route, msg); err != nil {
+				return err
+			}
+			encoded[bodyCodec] = data
+		}
+		s.pushPackage(data)
+	}
+	return nil
+}
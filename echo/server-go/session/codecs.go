@@ -0,0 +1,41 @@
+package session
+
+import (
+	"sync"
+
+	"server-go/codec"
+)
+
+var (
+	defaultCodec codec.Codec = codec.JSON
+	routeCodecs              = make(map[string]codec.Codec)
+	codecsLock   sync.RWMutex
+)
+
+// SetDefaultCodec sets the body codec used when the client does not
+// negotiate one in the handshake.
+func SetDefaultCodec(c codec.Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	defaultCodec = c
+}
+
+// SetRouteCodec pins route to c regardless of what the session negotiated.
+func SetRouteCodec(route string, c codec.Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	routeCodecs[route] = c
+}
+
+func getDefaultCodec() codec.Codec {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	return defaultCodec
+}
+
+func getRouteCodec(route string) (codec.Codec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	c, ok := routeCodecs[route]
+	return c, ok
+}
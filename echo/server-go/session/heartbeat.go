@@ -0,0 +1,133 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 普通连接的 Write 可能阻塞，时间轮回调不能直接写，也不为每次心跳启动
+// goroutine：心跳交给固定数量的发送 goroutine。对端不读时写会一直阻塞到
+// 心跳超时关闭连接，所以发送者不止一个；队列满时这次心跳跳过，下个周期再发
+const (
+	heartbeatSenders   = 8
+	heartbeatQueueSize = 4096
+)
+
+var (
+	heartbeatQueue     chan *Session
+	heartbeatQueueOnce sync.Once
+)
+
+// HeartbeatPolicy decides which side drives heartbeats.
+type HeartbeatPolicy int
+
+const (
+	// HeartbeatBoth: the server sends its own heartbeats and also echoes
+	// every client heartbeat.
+	HeartbeatBoth HeartbeatPolicy = iota
+	// HeartbeatEcho: the client drives, the server only echoes, as pinus does.
+	HeartbeatEcho
+	// HeartbeatServer: the server drives and the client answers.
+	HeartbeatServer
+)
+
+// 客户端在握手中给出的心跳间隔只在这个范围内被接受
+const (
+	minHeartbeatInterval = 2 * time.Second
+	maxHeartbeatInterval = 120 * time.Second
+)
+
+var (
+	heartbeatPolicy   = HeartbeatBoth
+	heartbeatInterval = 10 * time.Second
+	heartbeatLock     sync.RWMutex
+)
+
+func (p HeartbeatPolicy) String() string {
+	switch p {
+	case HeartbeatEcho:
+		return "echo"
+	case HeartbeatServer:
+		return "server"
+	}
+	return "both"
+}
+
+// ParseHeartbeatPolicy parses "both", "echo" or "server".
+func ParseHeartbeatPolicy(name string) (HeartbeatPolicy, error) {
+	switch name {
+	case "both":
+		return HeartbeatBoth, nil
+	case "echo":
+		return HeartbeatEcho, nil
+	case "server":
+		return HeartbeatServer, nil
+	}
+	return HeartbeatBoth, fmt.Errorf("unknown heartbeat policy: %s", name)
+}
+
+// SetHeartbeat sets the policy and the interval used when the client gives
+// no hint. Sessions that already finished the handshake keep their values.
+func SetHeartbeat(policy HeartbeatPolicy, interval time.Duration) {
+	heartbeatLock.Lock()
+	defer heartbeatLock.Unlock()
+	heartbeatPolicy = policy
+	if interval > 0 {
+		heartbeatInterval = interval
+	}
+}
+
+// negotiateHeartbeat returns the policy and the interval for a client that
+// asked for hint seconds, 0 meaning no preference.
+func negotiateHeartbeat(hint int) (HeartbeatPolicy, time.Duration) {
+	heartbeatLock.RLock()
+	defer heartbeatLock.RUnlock()
+
+	interval := heartbeatInterval
+	if hint > 0 {
+		interval = time.Duration(hint) * time.Second
+		if interval < minHeartbeatInterval {
+			interval = minHeartbeatInterval
+		} else if interval > maxHeartbeatInterval {
+			interval = maxHeartbeatInterval
+		}
+	}
+	return heartbeatPolicy, interval
+}
+
+// echoes reports whether the server answers client heartbeats.
+func (p HeartbeatPolicy) echoes() bool {
+	return p != HeartbeatServer
+}
+
+// sends reports whether the server sends heartbeats on its own.
+func (p HeartbeatPolicy) sends() bool {
+	return p != HeartbeatEcho
+}
+
+// queueHeartbeat hands the heartbeat of s to the senders without blocking.
+// A session is queued at most once at a time.
+func queueHeartbeat(s *Session) {
+	heartbeatQueueOnce.Do(startHeartbeatSenders)
+	if !s.heartbeatQueued.CompareAndSwap(false, true) {
+		return
+	}
+	select {
+	case heartbeatQueue <- s:
+	default:
+		s.heartbeatQueued.Store(false)
+	}
+}
+
+func startHeartbeatSenders() {
+	heartbeatQueue = make(chan *Session, heartbeatQueueSize)
+	for i := 0; i < heartbeatSenders; i++ {
+		go func() {
+			for s := range heartbeatQueue {
+				s.heartbeatQueued.Store(false)
+				s.send(heartbeatPacket)
+			}
+		}()
+	}
+}
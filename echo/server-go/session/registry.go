@@ -0,0 +1,175 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"server-go/timewheel"
+)
+
+// maxPendingPushes 限制断线期间为一个 session 缓存的推送数量
+const maxPendingPushes = 256
+
+var (
+	nextSessionId uint64
+	connections   int64
+
+	registryLock    sync.Mutex
+	sessionsByUid   = make(map[string]*Session)
+	sessionsByToken = make(map[string]*Session)
+
+	resumeGrace int64 // 默认关闭
+)
+
+// SetResumeGrace sets how long a dropped session is kept for resume.
+// A zero or negative duration disables resume.
+func SetResumeGrace(d time.Duration) {
+	atomic.StoreInt64(&resumeGrace, int64(d))
+}
+
+func getResumeGrace() time.Duration {
+	return time.Duration(atomic.LoadInt64(&resumeGrace))
+}
+
+// Connections returns the number of sessions that currently hold a
+// connection. Sessions waiting for resume are not counted.
+func Connections() int64 {
+	return atomic.LoadInt64(&connections)
+}
+
+// GetByUid returns the session bound to uid, including a detached one
+// that is still waiting for its client to come back.
+func GetByUid(uid string) *Session {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return sessionsByUid[uid]
+}
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// issueToken registers a fresh resume token for s.
+func issueToken(s *Session) string {
+	token := newResumeToken()
+	if token == "" {
+		return ""
+	}
+	registryLock.Lock()
+	if s.resumeToken != "" {
+		delete(sessionsByToken, s.resumeToken)
+	}
+	s.resumeToken = token
+	sessionsByToken[token] = s
+	registryLock.Unlock()
+	return token
+}
+
+// takeOver moves the state of the session owning token into s.
+// It returns false if the token is unknown or already expired.
+func takeOver(s *Session, token string) bool {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	old, ok := sessionsByToken[token]
+	if !ok || old == s {
+		return false
+	}
+	delete(sessionsByToken, token)
+	if old.graceTimer != nil {
+		old.graceTimer.Stop()
+		old.graceTimer = nil
+	}
+
+	// 旧连接可能还没有发现自己已经断开，直接关掉且不再进入等待重连状态
+	old.mu.Lock()
+	prev := old.state
+	old.state = StateClosed
+	timer := old.timer
+	uid := old.uid
+	channels := old.channels
+	reqId := old.ReqId
+	pending := old.pending
+	old.channels = nil
+	old.pending = nil
+	old.mu.Unlock()
+	if prev != StateDetached && prev != StateClosed {
+		// 旧连接之后的 Close 看到 StateClosed 什么也不做，心跳定时器只能在这里停掉
+		timer.Stop()
+		close(old.closeChan)
+		old.conn.Close()
+		atomic.AddInt64(&connections, -1)
+	}
+
+	if uid != "" && sessionsByUid[uid] == old {
+		sessionsByUid[uid] = s
+	}
+
+	// 属性通过快照转移，旧 session 上注册的钩子不会带过来
+	s.attrs.Restore(old.attrs.Snapshot())
+
+	s.mu.Lock()
+	s.uid = uid
+	s.channels = channels
+	s.ReqId = reqId
+	s.pending = pending
+	s.mu.Unlock()
+	return true
+}
+
+// park keeps a dropped session around for the grace window so that its
+// client can resume it.
+func park(s *Session, grace time.Duration) {
+	registryLock.Lock()
+	s.graceTimer = timewheel.Default().AfterFunc(grace, func() {
+		s.mu.Lock()
+		if s.state != StateDetached {
+			s.mu.Unlock()
+			return
+		}
+		s.state = StateClosed
+		s.mu.Unlock()
+		release(s)
+	})
+	registryLock.Unlock()
+}
+
+// release drops every registry and channel reference to s.
+func release(s *Session) {
+	registryLock.Lock()
+	if s.graceTimer != nil {
+		s.graceTimer.Stop()
+		s.graceTimer = nil
+	}
+	if s.resumeToken != "" && sessionsByToken[s.resumeToken] == s {
+		delete(sessionsByToken, s.resumeToken)
+	}
+	owned := s.uid != "" && sessionsByUid[s.uid] == s
+	if owned {
+		delete(sessionsByUid, s.uid)
+	}
+	registryLock.Unlock()
+
+	if !owned {
+		return
+	}
+	s.mu.Lock()
+	uid := s.uid
+	names := make([]string, 0, len(s.channels))
+	for name := range s.channels {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	for _, name := range names {
+		if c := GetChannel(name, false); c != nil {
+			c.Leave(uid)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"server-go/codec"
+)
+
+type RouteHandler func(s *Session, body map[string]interface{}) map[string]interface{}
+
+type NotifyHandler func(s *Session, body map[string]interface{})
+
+type RouteKind string
+
+const (
+	RouteRequest RouteKind = "request"
+	RouteNotify  RouteKind = "notify"
+	RoutePush    RouteKind = "push"
+)
+
+// RouteInfo describes a registered route. Request and Response are only
+// known for typed handlers; a push has its message schema in Response.
+type RouteInfo struct {
+	Route    string    `json:"route"`
+	Kind     RouteKind `json:"kind"`
+	Code     int       `json:"code"` // 握手 dict 中的路由编号
+	Request  *Schema   `json:"request,omitempty"`
+	Response *Schema   `json:"response,omitempty"`
+}
+
+var (
+	handlers       = make(map[string]RouteHandler)
+	notifyHandlers = make(map[string]NotifyHandler)
+	routeInfos     = make(map[string]*RouteInfo)
+	routeCodes     []string // 下标 + 1 即路由编号，只增不减
+	handlersLock   sync.RWMutex
+
+	routeDictEnabled atomic.Bool
+)
+
+// SetRouteDict turns the handshake route dict on or off. With the dict a
+// client may send a 2 byte code instead of the route string, and pushes
+// are sent the same way. It is off by default.
+func SetRouteDict(enabled bool) {
+	routeDictEnabled.Store(enabled)
+}
+
+func RegisterHandler(route string, handler RouteHandler) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	handlers[route] = handler
+	describe(route, RouteRequest, nil, nil)
+}
+
+// RegisterNotifyHandler registers the handler of a notify route.
+func RegisterNotifyHandler(route string, handler NotifyHandler) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	notifyHandlers[route] = handler
+	describe(route, RouteNotify, nil, nil)
+}
+
+// RegisterTyped registers a request handler working on Go structs. The
+// body is converted into Req through its json tags and the returned Resp
+// back into the response body, so the handler should set its own "code".
+// The schemas of both are exported with the route and used to generate
+// protobuf definitions.
+func RegisterTyped[Req, Resp interface{}](route string, handler func(s *Session, req *Req) *Resp) {
+	reqSchema := SchemaOf(reflect.TypeOf((*Req)(nil)).Elem())
+	respSchema := SchemaOf(reflect.TypeOf((*Resp)(nil)).Elem())
+
+	h := func(s *Session, body map[string]interface{}) map[string]interface{} {
+		var req Req
+		if err := convert(body, &req); err != nil {
+			return map[string]interface{}{"code": 400, "msg": err.Error()}
+		}
+		var out map[string]interface{}
+		if err := convert(handler(s, &req), &out); err != nil {
+			return map[string]interface{}{"code": 500, "msg": err.Error()}
+		}
+		return out
+	}
+
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	handlers[route] = h
+	describe(route, RouteRequest, reqSchema, respSchema)
+}
+
+// RegisterTypedNotify registers a notify handler working on a Go struct.
+func RegisterTypedNotify[Req interface{}](route string, handler func(s *Session, req *Req)) {
+	reqSchema := SchemaOf(reflect.TypeOf((*Req)(nil)).Elem())
+
+	h := func(s *Session, body map[string]interface{}) {
+		var req Req
+		if err := convert(body, &req); err != nil {
+			s.log(connLogger, slog.LevelWarn, "Bad notify body", "route", route, "err", err)
+			return
+		}
+		handler(s, &req)
+	}
+
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	notifyHandlers[route] = h
+	describe(route, RouteNotify, reqSchema, nil)
+}
+
+// DeclarePush declares a route the server pushes on, so that it is listed
+// and gets a dict code. msg is a sample value of the pushed message, or
+// nil if it has no fixed shape.
+func DeclarePush(route string, msg interface{}) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	describe(route, RoutePush, nil, sampleSchema(msg))
+}
+
+// DescribeRoute attaches request and response schemas, derived from the
+// sample values, to a route registered with a map based handler. The
+// handler itself is unchanged; a nil sample leaves that schema unknown.
+func DescribeRoute(route string, request, response interface{}) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	kind := RouteRequest
+	if info, ok := routeInfos[route]; ok {
+		kind = info.Kind
+	}
+	describe(route, kind, sampleSchema(request), sampleSchema(response))
+}
+
+func sampleSchema(v interface{}) *Schema {
+	if v == nil {
+		return nil
+	}
+	return SchemaOf(reflect.TypeOf(v))
+}
+
+// describe records a route; handlersLock must be held. A route keeps its
+// code when registered again, so codes handed out stay valid.
+func describe(route string, kind RouteKind, request, response *Schema) {
+	info, ok := routeInfos[route]
+	if !ok {
+		routeCodes = append(routeCodes, route)
+		info = &RouteInfo{Route: route, Code: len(routeCodes)}
+		routeInfos[route] = info
+	}
+	info.Kind = kind
+	info.Request = request
+	info.Response = response
+}
+
+func getHandler(route string) (RouteHandler, bool) {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	h, ok := handlers[route]
+	return h, ok
+}
+
+func getNotifyHandler(route string) (NotifyHandler, bool) {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	h, ok := notifyHandlers[route]
+	return h, ok
+}
+
+// routeCode returns the dict code of route, or 0 if it is sent as a string.
+func routeCode(route string) int {
+	if !routeDictEnabled.Load() {
+		return 0
+	}
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	if info, ok := routeInfos[route]; ok {
+		return info.Code
+	}
+	return 0
+}
+
+// routeByCode resolves a compressed route.
+func routeByCode(code int) (string, bool) {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	if code < 1 || code > len(routeCodes) {
+		return "", false
+	}
+	return routeCodes[code-1], true
+}
+
+// routeDict is the dict sent in the handshake, empty when disabled.
+func routeDict() map[string]int {
+	if !routeDictEnabled.Load() {
+		return map[string]int{}
+	}
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	dict := make(map[string]int, len(routeCodes))
+	for i, route := range routeCodes {
+		dict[route] = i + 1
+	}
+	return dict
+}
+
+// Routes lists the registered routes sorted by route.
+func Routes() []RouteInfo {
+	handlersLock.RLock()
+	out := make([]RouteInfo, 0, len(routeInfos))
+	for _, info := range routeInfos {
+		out = append(out, *info)
+	}
+	handlersLock.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Route < out[j].Route })
+	return out
+}
+
+// RouteProtos generates pinus protobuf definitions from the schemas of
+// typed routes: requests and notifies go to client, responses and pushes
+// to server. Routes whose schema cannot be expressed are left out and
+// reported in err.
+func RouteProtos() (client, server map[string]interface{}, err error) {
+	client = make(map[string]interface{})
+	server = make(map[string]interface{})
+	var errs []error
+	add := func(protos map[string]interface{}, route string, schema *Schema) {
+		if schema == nil {
+			return
+		}
+		def, e := schema.Proto()
+		if e != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", route, e))
+			return
+		}
+		protos[route] = def
+	}
+	for _, info := range Routes() {
+		add(client, info.Route, info.Request)
+		add(server, info.Route, info.Response)
+	}
+	return client, server, errors.Join(errs...)
+}
+
+// ExportRoutes writes the routes together with the handshake dict and the
+// protobuf definitions in use as indented JSON.
+func ExportRoutes(w io.Writer) error {
+	clientProtos := map[string]interface{}{}
+	serverProtos := map[string]interface{}{}
+	if c, ok := codec.Get("protobuf"); ok {
+		if pb, ok := c.(*codec.Protobuf); ok {
+			serverProtos, clientProtos = pb.Protos()
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{
+		"routes": Routes(),
+		"dict":   routeDict(),
+		"protos": map[string]interface{}{
+			"client": clientProtos,
+			"server": serverProtos,
+		},
+	})
+}
+
+// convert copies v into out through JSON, the same representation the
+// codecs decode bodies into.
+func convert(v, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
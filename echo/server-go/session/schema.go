@@ -0,0 +1,195 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Schema is a small subset of JSON Schema, derived from the Go types of
+// typed handlers. Object fields keep their struct order, which also
+// gives the generated protobuf tags.
+type Schema struct {
+	Type       string  // object, array, string, integer, number, boolean or "" for any
+	Format     string  // int32 / uint32 / float / double for numbers
+	Fields     []Field // object fields
+	Items      *Schema // array items
+	Additional *Schema // map values
+}
+
+type Field struct {
+	Name     string
+	Required bool
+	Schema   *Schema
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// SchemaOf derives the schema of t from its json tags. Fields tagged
+// omitempty and pointers are optional.
+func SchemaOf(t reflect.Type) *Schema {
+	return schemaOf(t, map[reflect.Type]bool{})
+}
+
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "uint32"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string"} // json 把 []byte 编码为 base64
+		}
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return &Schema{Type: "object", Additional: schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		// 递归类型不展开
+		if seen[t] {
+			return &Schema{}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		s := &Schema{Type: "object"}
+		addFields(s, t, seen)
+		return s
+	}
+	return &Schema{}
+}
+
+func addFields(s *Schema, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(s, ft, seen)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		required := f.Type.Kind() != reflect.Pointer && !strings.Contains(opts, "omitempty")
+		s.Fields = append(s.Fields, Field{Name: name, Required: required, Schema: schemaOf(f.Type, seen)})
+	}
+}
+
+// MarshalJSON writes the schema as JSON Schema.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{}
+	if s.Type != "" {
+		out["type"] = s.Type
+	}
+	if s.Format != "" {
+		out["format"] = s.Format
+	}
+	if s.Items != nil {
+		out["items"] = s.Items
+	}
+	if s.Additional != nil {
+		out["additionalProperties"] = s.Additional
+	}
+	if len(s.Fields) > 0 {
+		props := make(map[string]*Schema, len(s.Fields))
+		var required []string
+		for _, f := range s.Fields {
+			props[f.Name] = f.Schema
+			if f.Required {
+				required = append(required, f.Name)
+			}
+		}
+		out["properties"] = props
+		if len(required) > 0 {
+			out["required"] = required
+		}
+	}
+	return json.Marshal(out)
+}
+
+// Proto converts an object schema into a pinus protobuf message
+// definition, e.g. {"required string name": 1, "message Pos": {...}}.
+// Tags follow the field order. Booleans, maps and untyped values have no
+// pinus protobuf type and are rejected.
+func (s *Schema) Proto() (map[string]interface{}, error) {
+	if s.Type != "object" || s.Additional != nil {
+		return nil, fmt.Errorf("%s is not a struct", describeType(s))
+	}
+	def := make(map[string]interface{}, len(s.Fields))
+	for i, f := range s.Fields {
+		option := "optional"
+		if f.Required {
+			option = "required"
+		}
+		fs := f.Schema
+		if fs.Type == "array" {
+			option = "repeated"
+			fs = fs.Items
+		}
+
+		var typ string
+		switch fs.Type {
+		case "string":
+			typ = "string"
+		case "integer":
+			typ = "sInt32"
+			if fs.Format == "uint32" {
+				typ = "uInt32"
+			}
+		case "number":
+			typ = fs.Format
+		case "object":
+			if fs.Additional != nil {
+				return nil, fmt.Errorf("%s: map has no protobuf type", f.Name)
+			}
+			sub, err := fs.Proto()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+			typ = strings.ToUpper(f.Name[:1]) + f.Name[1:]
+			def["message "+typ] = sub
+		default:
+			return nil, fmt.Errorf("%s: %s has no protobuf type", f.Name, describeType(fs))
+		}
+		def[option+" "+typ+" "+f.Name] = float64(i + 1)
+	}
+	return def, nil
+}
+
+func describeType(s *Schema) string {
+	switch {
+	case s.Type == "":
+		return "untyped value"
+	case s.Additional != nil:
+		return "map"
+	}
+	return s.Type
+}
@@ -1,13 +1,22 @@
 package session
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"server-go/accesslog"
+	"server-go/codec"
+	"server-go/logging"
+	"server-go/netbuf"
 	"server-go/protocol"
+	"server-go/timewheel"
+	"server-go/tracing"
 )
 
 type ConnectionState int
@@ -17,47 +26,99 @@ const (
 	StateWaitAck
 	StateWorking
 	StateClosed
+	StateDetached // 连接已断开，等待客户端携带 resumeToken 重连
 )
 
-type RouteHandler func(s *Session, body map[string]interface{}) map[string]interface{}
+var ErrSessionClosed = errors.New("session closed")
 
 var (
-	handlers     = make(map[string]RouteHandler)
-	handlersLock sync.RWMutex
+	logger = logging.For("session")
+	// 连接建立、断开这类每个连接都会有的日志按秒采样
+	connLogger = logging.Sampled(logger, 10, 100)
 )
 
-func RegisterHandler(route string, handler RouteHandler) {
-	handlersLock.Lock()
-	defer handlersLock.Unlock()
-	handlers[route] = handler
-}
+const (
+	readBufferSize  = 4096
+	writeBufferSize = 512
+
+	handshakeTimeout = 60 * time.Second
+
+	// kickLinger 是被踢的 TCP 连接半关闭后等待客户端读完 kick 包的时间
+	kickLinger = 2 * time.Second
+)
+
+// KickDuplicateLogin is the kick reason sent when another connection
+// binds the same uid.
+const KickDuplicateLogin = "duplicate_login"
+
+// heartbeatPacket is shared by all sessions so heartbeats never allocate.
+var heartbeatPacket = protocol.PackageEncode(protocol.PackageTypeHeartbeat, nil)
 
 type Session struct {
+	id                uint64
 	conn              net.Conn
 	state             ConnectionState
+	heartbeatPolicy   HeartbeatPolicy
 	heartbeatInterval time.Duration
 	heartbeatTimeout  time.Duration
 	lastHeartbeat     time.Time
 	heartbeatSeq      int
+	codec             codec.Codec
 	closeChan         chan struct{}
 	mu                sync.Mutex
 	ReqId             int // 记录总共收到多少次请求
+
+	// 握手超时和心跳共用一个时间轮上的定时器
+	timer *timewheel.Timer
+	// 心跳已经排队等待发送，见 queueHeartbeat
+	heartbeatQueued atomic.Bool
+	// 关闭前先把已写出的数据（kick 包）发完
+	flushOnClose bool
+	// 正在处理的请求的 trace，见 TraceContext
+	trace tracing.SpanContext
+
+	// 由外部事件循环驱动（epoll 模式）时使用，见 Feed
+	polled  bool
+	partial *[]byte
+
+	// 以下状态在断线重连（resume）时会转移到新的 session 上
+	uid         string
+	attrs       *Attributes
+	channels    map[string]struct{}
+	pending     [][]byte
+	resumeToken string
+	graceTimer  *timewheel.Timer
 }
 
 func NewSession(conn net.Conn) *Session {
-	return &Session{
+	s := &Session{
+		id:        atomic.AddUint64(&nextSessionId, 1),
 		conn:      conn,
+		attrs:     newAttributes(),
+		codec:     getDefaultCodec(),
 		state:     StateInited,
 		closeChan: make(chan struct{}),
 		ReqId:     0,
 	}
+	s.timer = timewheel.Default().AfterFunc(handshakeTimeout, s.handshakeExpired)
+	atomic.AddInt64(&connections, 1)
+	return s
+}
+
+// NewPolledSession creates a session driven by an event loop: instead of
+// calling Start, the loop passes read bytes to Feed. Its conn must not
+// block on Write.
+func NewPolledSession(conn net.Conn) *Session {
+	s := NewSession(conn)
+	s.polled = true
+	return s
 }
 
 func (s *Session) Start() {
 	defer s.Close()
 
-	buf := make([]byte, 4096)
-	var dataBuf []byte
+	// 读到的数据直接进入环形缓冲区，完整的包以视图的形式交给上层处理
+	ring := netbuf.NewRing(readBufferSize)
 
 	for {
 		select {
@@ -66,58 +127,152 @@ func (s *Session) Start() {
 		default:
 		}
 
-		s.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-		n, err := s.conn.Read(buf)
+		n, err := s.conn.Read(ring.WriteSpace())
 		if err != nil {
-			log.Printf("[session] Read error: %v", err)
+			s.log(connLogger, slog.LevelDebug, "Read error", "err", err)
 			return
 		}
-
-		dataBuf = append(dataBuf, buf[:n]...)
+		ring.Commit(n)
 
 		// Process complete packages
-		for len(dataBuf) >= 4 {
-			pkgLen := (int(dataBuf[1]) << 16) | (int(dataBuf[2]) << 8) | int(dataBuf[3])
-			totalLen := 4 + pkgLen
+		for ring.Len() >= protocol.PackageHeadSize {
+			pkgLen := (int(ring.At(1)) << 16) | (int(ring.At(2)) << 8) | int(ring.At(3))
+			totalLen := protocol.PackageHeadSize + pkgLen
 
-			if len(dataBuf) < totalLen {
+			if ring.Len() < totalLen {
+				ring.Grow(totalLen)
 				break
 			}
 
-			pkg := protocol.PackageDecode(dataBuf[:totalLen])
-			if pkg != nil {
-				s.processPackage(pkg)
+			frame, pooled := ring.View(totalLen)
+			s.processPackage(frame[0], frame[protocol.PackageHeadSize:])
+			if pooled != nil {
+				netbuf.Put(pooled)
 			}
-			dataBuf = dataBuf[totalLen:]
+			ring.Discard(totalLen)
+		}
+	}
+}
+
+// Feed processes bytes read by the event loop. Only an incomplete trailing
+// package is copied, into a pooled buffer, so idle sessions hold no read
+// buffer at all.
+func (s *Session) Feed(data []byte) {
+	if s.partial != nil {
+		*s.partial = append(*s.partial, data...)
+		data = *s.partial
+	}
+
+	rest := data[s.processPackages(data):]
+	if len(rest) == 0 {
+		if s.partial != nil {
+			netbuf.Put(s.partial)
+			s.partial = nil
+		}
+		return
+	}
+	if s.partial == nil {
+		s.partial = netbuf.Get(len(rest))
+	}
+	// rest may alias *s.partial, copy handles the overlap
+	*s.partial = append((*s.partial)[:0], rest...)
+}
+
+// processPackages handles the complete packages at the start of data and
+// returns how many bytes they took.
+func (s *Session) processPackages(data []byte) int {
+	offset := 0
+	for len(data)-offset >= protocol.PackageHeadSize {
+		pkgLen := (int(data[offset+1]) << 16) | (int(data[offset+2]) << 8) | int(data[offset+3])
+		totalLen := protocol.PackageHeadSize + pkgLen
+		if len(data)-offset < totalLen {
+			break
 		}
+		s.processPackage(data[offset], data[offset+protocol.PackageHeadSize:offset+totalLen])
+		offset += totalLen
 	}
+	return offset
 }
 
-func (s *Session) processPackage(pkg *protocol.Package) {
-	switch pkg.Type {
+func (s *Session) handshakeExpired() {
+	s.mu.Lock()
+	state := s.state
+	s.mu.Unlock()
+
+	if state == StateInited || state == StateWaitAck {
+		s.log(connLogger, slog.LevelInfo, "Handshake timeout")
+		s.Close()
+	}
+}
+
+// processPackage handles one package. body is only valid during the call.
+func (s *Session) processPackage(pkgType byte, body []byte) {
+	// 任何数据都说明连接还活着，不只是心跳包
+	s.mu.Lock()
+	s.lastHeartbeat = time.Now()
+	s.mu.Unlock()
+
+	switch pkgType {
 	case protocol.PackageTypeHandshake:
-		s.handleHandshake(pkg.Body)
+		s.handleHandshake(body)
 	case protocol.PackageTypeHandshakeAck:
 		s.handleHandshakeAck()
 	case protocol.PackageTypeHeartbeat:
 		s.handleHeartbeat()
 	case protocol.PackageTypeData:
-		s.handleData(pkg.Body)
+		s.handleData(body)
 	case protocol.PackageTypeKick:
-		s.Close()
+		// 客户端主动离开，不保留重连状态
+		s.closeWith(false)
 	}
 }
 
+type handshakeRequest struct {
+	Sys struct {
+		ResumeToken string   `json:"resumeToken"`
+		Codecs      []string `json:"codecs"`
+		Heartbeat   int      `json:"heartbeat"` // 客户端期望的心跳间隔（秒）
+	} `json:"sys"`
+}
+
 func (s *Session) handleHandshake(body []byte) {
+	var req handshakeRequest
+	if len(body) > 0 {
+		json.Unmarshal(body, &req)
+	}
+
+	resumed := false
+	if req.Sys.ResumeToken != "" && getResumeGrace() > 0 {
+		resumed = takeOver(s, req.Sys.ResumeToken)
+		if resumed {
+			s.log(logger, slog.LevelInfo, "Session resumed")
+		}
+	}
+	token := issueToken(s)
+
+	// 按客户端给出的顺序协商 body 编码，没有给出时使用服务端默认编码
+	bodyCodec := codec.Negotiate(req.Sys.Codecs, getDefaultCodec())
+	clientProtos := map[string]interface{}{}
+	serverProtos := map[string]interface{}{}
+	if pb, ok := bodyCodec.(*codec.Protobuf); ok {
+		serverProtos, clientProtos = pb.Protos()
+	}
+
+	policy, interval := negotiateHeartbeat(req.Sys.Heartbeat)
+
 	// Prepare handshake response
 	response := map[string]interface{}{
 		"code": 200,
 		"sys": map[string]interface{}{
-			"heartbeat": 10,
-			"dict":      map[string]interface{}{},
+			"heartbeat":       int(interval / time.Second),
+			"heartbeatPolicy": policy.String(),
+			"resumeToken":     token,
+			"resumed":         resumed,
+			"codec":           bodyCodec.Name(),
+			"dict":            routeDict(),
 			"protos": map[string]interface{}{
-				"client": map[string]interface{}{},
-				"server": map[string]interface{}{},
+				"client": clientProtos,
+				"server": serverProtos,
 			},
 		},
 		"user": map[string]interface{}{},
@@ -128,108 +283,202 @@ func (s *Session) handleHandshake(body []byte) {
 	s.send(responsePkg)
 
 	s.mu.Lock()
+	s.codec = bodyCodec
 	s.state = StateWaitAck
-	s.heartbeatInterval = 10 * time.Second
-	s.heartbeatTimeout = 20 * time.Second
+	s.heartbeatPolicy = policy
+	s.heartbeatInterval = interval
+	s.heartbeatTimeout = 2 * interval
 	s.mu.Unlock()
 }
 
 func (s *Session) handleHandshakeAck() {
 	s.mu.Lock()
+	// 只接受握手之后的第一个 ack；已关闭或等待重连的 session 不能重新启动心跳
+	if s.state != StateWaitAck {
+		s.mu.Unlock()
+		return
+	}
 	s.state = StateWorking
 	s.lastHeartbeat = time.Now()
+	pending := s.pending
+	s.pending = nil
+	// 握手完成，定时器从握手超时切换为心跳
+	s.timer.Stop()
+	s.timer = timewheel.Default().Every(s.heartbeatInterval, s.heartbeatTick)
 	s.mu.Unlock()
 
-	// Start heartbeat
-	go s.heartbeatLoop()
+	// 补发断线期间缓存的推送
+	for _, data := range pending {
+		s.send(data)
+	}
 }
 
 func (s *Session) handleHeartbeat() {
 	s.mu.Lock()
-	s.lastHeartbeat = time.Now()
+	policy := s.heartbeatPolicy
 	s.mu.Unlock()
 
 	// Send heartbeat response
-	heartbeatPkg := protocol.PackageEncode(protocol.PackageTypeHeartbeat, nil)
-	s.send(heartbeatPkg)
+	if policy.echoes() {
+		s.send(heartbeatPacket)
+	}
 }
 
 func (s *Session) handleData(body []byte) {
-	s.mu.Lock()
-	s.lastHeartbeat = time.Now()
-	s.mu.Unlock()
+	t := tracer.Load()
+	var received time.Time
+	if t != nil {
+		received = time.Now()
+	}
 
-	msg := protocol.MessageDecode(body)
-	if msg == nil {
-		log.Printf("[session] Failed to decode message")
+	var msg protocol.Message
+	if !protocol.MessageParse(body, &msg) {
+		s.log(connLogger, slog.LevelWarn, "Failed to decode message")
 		return
 	}
+	if msg.CompressRoute {
+		route, ok := routeByCode(msg.RouteCode)
+		if !ok {
+			s.log(connLogger, slog.LevelWarn, "Unknown route code", "code", msg.RouteCode)
+			return
+		}
+		msg.Route = route
+	}
 
-	// Parse body as JSON
-	var msgBody map[string]interface{}
-	if len(msg.Body) > 0 {
-		json.Unmarshal(msg.Body, &msgBody)
+	decoded, err := s.codecFor(msg.Route).Decode(msg.Route, msg.Body)
+	if err != nil {
+		s.log(connLogger, slog.LevelWarn, "Failed to decode body", "route", msg.Route, "err", err)
+		return
 	}
+	msgBody, ok := decoded.(map[string]interface{})
+	if !ok {
+		msgBody = make(map[string]interface{})
+	}
+
+	// trace 字段是保留字段，无论是否开启追踪都不交给 handler
+	parent, traced := tracing.Extract(msgBody)
 
+	reqSize := protocol.PackageHeadSize + len(body)
 	if msg.Type == protocol.MessageTypeRequest {
-		s.handleRequest(msg.ID, msg.Route, msgBody)
+		var rt *requestTrace
+		if traced && t != nil {
+			rt = &requestTrace{t: t, parent: parent, server: parent.Child(), received: received}
+		}
+		s.handleRequest(msg.ID, msg.Route, msgBody, reqSize, rt)
 	} else if msg.Type == protocol.MessageTypeNotify {
-		log.Printf("[session] Notify received: route=%s, body=%v", msg.Route, msgBody)
+		s.log(logger, slog.LevelDebug, "Notify received", "route", msg.Route, "body", msgBody)
+		al := accessLog.Load()
+		var start time.Time
+		if al != nil {
+			start = time.Now()
+		}
+		if handler, ok := getNotifyHandler(msg.Route); ok {
+			handler(s, msgBody)
+		}
+		if al != nil {
+			s.logAccess(al, start, accesslog.TypeNotify, msg.Route, 0, reqSize, 0, 0, time.Since(start))
+		}
 	}
 }
 
-func (s *Session) handleRequest(id int, route string, body map[string]interface{}) {
+func (s *Session) handleRequest(id int, route string, body map[string]interface{}, reqSize int, rt *requestTrace) {
 	var responseBody map[string]interface{}
 
-	handlersLock.RLock()
-	handler, ok := handlers[route]
-	handlersLock.RUnlock()
+	// 只在打开访问日志时取时间，不给请求路径增加开销
+	al := accessLog.Load()
+	var start time.Time
+	if al != nil {
+		start = time.Now()
+	}
+
+	handler, ok := getHandler(route)
+
+	if rt != nil {
+		s.setTrace(rt.server)
+		rt.handlerStart = time.Now()
+	}
 
 	if ok {
 		responseBody = handler(s, body)
 	} else {
-		log.Printf("[session] Unknown route: %s", route)
+		s.log(connLogger, slog.LevelWarn, "Unknown route", "route", route)
 		responseBody = map[string]interface{}{
 			"code": 404,
 			"msg":  "Route not found: " + route,
 		}
 	}
 
-	responseBodyBytes, _ := json.Marshal(responseBody)
-	responseMsg := protocol.MessageEncode(id, protocol.MessageTypeResponse, false, "", responseBodyBytes)
-	responsePkg := protocol.PackageEncode(protocol.PackageTypeData, responseMsg)
-	s.send(responsePkg)
+	var latency time.Duration
+	if al != nil {
+		latency = time.Since(start)
+	}
+	if rt != nil {
+		rt.handlerEnd = time.Now()
+	}
+
+	respSize, err := s.writeMessage(id, protocol.MessageTypeResponse, route, responseBody)
+	if al != nil {
+		s.logAccess(al, start, accesslog.TypeRequest, route, id, reqSize, respSize, responseCode(responseBody), latency)
+	}
+	if rt != nil {
+		rt.finish(s, route, id, responseCode(responseBody), time.Now())
+		s.setTrace(tracing.SpanContext{})
+	}
+	if err != nil {
+		s.log(logger, slog.LevelError, "Failed to encode response", "route", route, "err", err)
+	}
 }
 
-func (s *Session) heartbeatLoop() {
-	ticker := time.NewTicker(s.heartbeatInterval)
-	defer ticker.Stop()
+// writeMessage encodes package header, message header and body into one
+// pooled buffer and sends it. route selects the codec; it is only written
+// to the wire for message types that carry one. It returns the package size.
+func (s *Session) writeMessage(id int, msgType int, route string, body interface{}) (int, error) {
+	bp := netbuf.Get(writeBufferSize)
+	defer netbuf.Put(bp)
 
-	for {
-		select {
-		case <-s.closeChan:
-			return
-		case <-ticker.C:
-			s.mu.Lock()
-			state := s.state
-			lastHB := s.lastHeartbeat
-			s.mu.Unlock()
-
-			if state != StateWorking {
-				return
-			}
+	wireRoute := route
+	if msgType == protocol.MessageTypeResponse {
+		wireRoute = ""
+	}
+	out := append(*bp, 0, 0, 0, 0)
+	out = protocol.MessageAppendHead(out, id, msgType, 0, wireRoute)
+	out, err := codec.AppendEncode(s.codecFor(route), out, route, body)
+	if err != nil {
+		return 0, err
+	}
+	protocol.PutPackageHead(out, protocol.PackageTypeData, len(out)-protocol.PackageHeadSize)
+	*bp = out
+	s.send(out)
+	return len(out), nil
+}
 
-			// Check timeout
-			if time.Since(lastHB) > s.heartbeatTimeout {
-				log.Printf("[session] Heartbeat timeout")
-				s.Close()
-				return
-			}
+// heartbeatTick runs on the timewheel goroutine, so it must not block.
+func (s *Session) heartbeatTick() {
+	s.mu.Lock()
+	state := s.state
+	lastHB := s.lastHeartbeat
+	policy := s.heartbeatPolicy
+	s.mu.Unlock()
 
-			// Send heartbeat
-			heartbeatPkg := protocol.PackageEncode(protocol.PackageTypeHeartbeat, nil)
-			s.send(heartbeatPkg)
-		}
+	if state != StateWorking {
+		return
+	}
+
+	// Check timeout
+	if time.Since(lastHB) > s.heartbeatTimeout {
+		s.log(connLogger, slog.LevelInfo, "Heartbeat timeout")
+		s.Close()
+		return
+	}
+
+	// Send heartbeat. epoll 模式的 Write 不会阻塞，可以直接写
+	if !policy.sends() {
+		return
+	}
+	if s.polled {
+		s.send(heartbeatPacket)
+	} else {
+		queueHeartbeat(s)
 	}
 }
 
@@ -237,16 +486,213 @@ func (s *Session) send(data []byte) {
 	s.conn.Write(data)
 }
 
+// log writes a record with the session id and the bound uid attached.
+func (s *Session) log(l *slog.Logger, level slog.Level, msg string, args ...interface{}) {
+	ctx := context.Background()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	s.mu.Lock()
+	uid := s.uid
+	trace := s.trace
+	s.mu.Unlock()
+	attrs := []interface{}{"sid", s.id, "uid", uid}
+	if trace.IsValid() {
+		attrs = append(attrs, "trace_id", trace.TraceID.String())
+	}
+	l.Log(ctx, level, msg, append(attrs, args...)...)
+}
+
+func (s *Session) ID() uint64 {
+	return s.id
+}
+
+func (s *Session) UID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uid
+}
+
+// Bind associates the session with uid so it can be found by GetByUid
+// and added to channels. Another session already bound to uid is kicked
+// with KickDuplicateLogin and its channels move over to s.
+func (s *Session) Bind(uid string) {
+	registryLock.Lock()
+	s.mu.Lock()
+	prev := s.uid
+	s.uid = uid
+	s.mu.Unlock()
+
+	if prev != "" && sessionsByUid[prev] == s {
+		delete(sessionsByUid, prev)
+	}
+	old := sessionsByUid[uid]
+	sessionsByUid[uid] = s
+	registryLock.Unlock()
+
+	if old == nil || old == s {
+		return
+	}
+	old.mu.Lock()
+	channels := old.channels
+	old.channels = nil
+	old.mu.Unlock()
+	s.mu.Lock()
+	if s.channels == nil {
+		s.channels = make(map[string]struct{})
+	}
+	for name := range channels {
+		s.channels[name] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	old.log(logger, slog.LevelInfo, "uid bound by another session", "by", s.id)
+	old.Kick(KickDuplicateLogin)
+}
+
+// Codec returns the body codec negotiated in the handshake.
+func (s *Session) Codec() codec.Codec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.codec
+}
+
+func (s *Session) codecFor(route string) codec.Codec {
+	if c, ok := getRouteCodec(route); ok {
+		return c
+	}
+	return s.Codec()
+}
+
+// Attributes returns the per-player key/value store of the session.
+func (s *Session) Attributes() *Attributes {
+	return s.attrs
+}
+
+func (s *Session) Set(key string, value interface{}) {
+	s.attrs.Set(key, value)
+}
+
+func (s *Session) Get(key string) interface{} {
+	v, _ := s.attrs.Get(key)
+	return v
+}
+
+func (s *Session) Remove(key string) {
+	s.attrs.Remove(key)
+}
+
+// Push sends a push message to the client. While the session is waiting
+// to be resumed the push is queued instead.
+func (s *Session) Push(route string, msg interface{}) error {
+	data, err := encodePush(s.codecFor(route), route, msg)
+	if err != nil {
+		return err
+	}
+	return s.pushPackage(data)
+}
+
+func encodePush(c codec.Codec, route string, msg interface{}) ([]byte, error) {
+	body, err := c.Encode(route, msg)
+	if err != nil {
+		return nil, err
+	}
+	pushMsg := protocol.MessageEncode(0, protocol.MessageTypePush, routeCode(route), route, body)
+	return protocol.PackageEncode(protocol.PackageTypeData, pushMsg), nil
+}
+
+func (s *Session) pushPackage(data []byte) error {
+	s.mu.Lock()
+	switch s.state {
+	case StateWorking:
+		s.mu.Unlock()
+		s.send(data)
+		return nil
+	case StateClosed:
+		s.mu.Unlock()
+		return ErrSessionClosed
+	}
+	if len(s.pending) < maxPendingPushes {
+		s.pending = append(s.pending, data)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Kick sends a kick package with reason to the client, lets it flush and
+// closes the session without keeping it for resume. A session waiting to
+// be resumed is dropped right away.
+func (s *Session) Kick(reason string) error {
+	s.mu.Lock()
+	state := s.state
+	switch state {
+	case StateClosed:
+		s.mu.Unlock()
+		return ErrSessionClosed
+	case StateDetached:
+		s.state = StateClosed
+		s.mu.Unlock()
+		release(s)
+		return nil
+	}
+	s.flushOnClose = true
+	s.mu.Unlock()
+
+	body, _ := json.Marshal(map[string]interface{}{"reason": reason})
+	s.send(protocol.PackageEncode(protocol.PackageTypeKick, body))
+	s.log(logger, slog.LevelInfo, "Session kicked", "reason", reason)
+	s.closeWith(false)
+	return nil
+}
+
+// Close closes the connection. A working session is kept for the resume
+// grace window so that its client can reconnect to it.
 func (s *Session) Close() {
+	s.closeWith(true)
+}
+
+func (s *Session) closeWith(resumable bool) {
+	grace := getResumeGrace()
+
 	s.mu.Lock()
-	if s.state == StateClosed {
+	if s.state == StateClosed || s.state == StateDetached {
 		s.mu.Unlock()
 		return
 	}
-	s.state = StateClosed
+	detach := resumable && grace > 0 && s.state == StateWorking
+	if detach {
+		s.state = StateDetached
+	} else {
+		s.state = StateClosed
+	}
+	timer := s.timer
+	flush := s.flushOnClose
 	s.mu.Unlock()
 
+	timer.Stop()
 	close(s.closeChan)
+	atomic.AddInt64(&connections, -1)
+	if flush {
+		s.closeConnAfterFlush()
+	} else {
+		s.conn.Close()
+	}
+
+	if detach {
+		park(s, grace)
+		s.log(connLogger, slog.LevelInfo, "Connection closed, kept for resume")
+	} else {
+		release(s)
+		s.log(connLogger, slog.LevelInfo, "Connection closed")
+	}
+}
+
+func (s *Session) closeConnAfterFlush() {
+	// TCP 连接先半关闭，FIN 跟在已写出的数据后面，客户端读完后自己断开。
+	// epoll 模式的连接在 Close 时会自己发完排队的数据
+	if cw, ok := s.conn.(interface{ CloseWrite() error }); ok && cw.CloseWrite() == nil {
+		timewheel.Default().AfterFunc(kickLinger, func() { s.conn.Close() })
+		return
+	}
 	s.conn.Close()
-	log.Printf("[session] Connection closed")
 }
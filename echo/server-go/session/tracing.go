@@ -0,0 +1,80 @@
+package session
+
+import (
+	"sync/atomic"
+	"time"
+
+	"server-go/tracing"
+)
+
+var tracer atomic.Pointer[tracing.Tracer]
+
+// SetTracer starts recording spans for requests that carry a trace
+// context. nil turns it off.
+func SetTracer(t *tracing.Tracer) {
+	tracer.Store(t)
+}
+
+// TraceContext returns the server span of the request being handled, for
+// handlers that want to pass it on. It is invalid outside a traced request.
+func (s *Session) TraceContext() tracing.SpanContext {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trace
+}
+
+func (s *Session) setTrace(c tracing.SpanContext) {
+	s.mu.Lock()
+	s.trace = c
+	s.mu.Unlock()
+}
+
+// requestTrace collects the server side timestamps of one traced request:
+// received is when the package was taken off the connection, the handler
+// runs from handlerStart to handlerEnd and the response is written by
+// written.
+type requestTrace struct {
+	t            *tracing.Tracer
+	parent       tracing.SpanContext
+	server       tracing.SpanContext
+	received     time.Time
+	handlerStart time.Time
+	handlerEnd   time.Time
+}
+
+func (rt *requestTrace) finish(s *Session, route string, id int, code int, written time.Time) {
+	attrs := []tracing.Attr{
+		{Key: "rpc.method", Value: route},
+		{Key: "session.id", Value: s.id},
+		{Key: "message.id", Value: id},
+		{Key: "response.code", Value: code},
+	}
+	if uid := s.UID(); uid != "" {
+		attrs = append(attrs, tracing.Attr{Key: "enduser.id", Value: uid})
+	}
+	rt.t.Export(&tracing.Span{
+		Name:    "server " + route,
+		Kind:    tracing.KindServer,
+		Context: rt.server,
+		Parent:  rt.parent.SpanID,
+		Start:   rt.received,
+		End:     written,
+		Attrs:   attrs,
+	})
+	rt.t.Export(&tracing.Span{
+		Name:    "handler " + route,
+		Kind:    tracing.KindInternal,
+		Context: rt.server.Child(),
+		Parent:  rt.server.SpanID,
+		Start:   rt.handlerStart,
+		End:     rt.handlerEnd,
+	})
+	rt.t.Export(&tracing.Span{
+		Name:    "write " + route,
+		Kind:    tracing.KindInternal,
+		Context: rt.server.Child(),
+		Parent:  rt.server.SpanID,
+		Start:   rt.handlerEnd,
+		End:     written,
+	})
+}
@@ -0,0 +1,96 @@
+// Package tracing carries a W3C trace context inside the message body and
+// records spans in the OpenTelemetry OTLP/JSON format, one batch per line,
+// the same layout the collector's file exporter writes.
+//
+// The client puts a traceparent into the reserved Field of the request
+// body; the server removes it before the handler sees the body and
+// records its spans as children of the client span.
+package tracing
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"strings"
+)
+
+// Field is the reserved body key holding the traceparent.
+const Field = "__traceparent"
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsZero() bool { return id == TraceID{} }
+func (id SpanID) IsZero() bool  { return id == SpanID{} }
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (c SpanContext) IsValid() bool {
+	return !c.TraceID.IsZero() && !c.SpanID.IsZero()
+}
+
+// NewRoot starts a new trace.
+func NewRoot() SpanContext {
+	var c SpanContext
+	putRandom(c.TraceID[:])
+	putRandom(c.SpanID[:])
+	return c
+}
+
+// Child returns a new span in the same trace.
+func (c SpanContext) Child() SpanContext {
+	child := SpanContext{TraceID: c.TraceID}
+	putRandom(child.SpanID[:])
+	return child
+}
+
+// 只用作 ID，不需要密码学强度的随机数
+func putRandom(b []byte) {
+	for i := 0; i < len(b); i += 8 {
+		v := rand.Uint64()
+		for j := i; j < len(b) && j < i+8; j++ {
+			b[j] = byte(v)
+			v >>= 8
+		}
+	}
+}
+
+// Traceparent formats c as a W3C traceparent header value.
+func (c SpanContext) Traceparent() string {
+	return "00-" + c.TraceID.String() + "-" + c.SpanID.String() + "-01"
+}
+
+// Parse reads a W3C traceparent value.
+func Parse(traceparent string) (SpanContext, bool) {
+	var c SpanContext
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return c, false
+	}
+	if _, err := hex.Decode(c.TraceID[:], []byte(parts[1])); err != nil {
+		return c, false
+	}
+	if _, err := hex.Decode(c.SpanID[:], []byte(parts[2])); err != nil {
+		return c, false
+	}
+	return c, c.IsValid()
+}
+
+// Extract removes the traceparent from body and returns it.
+func Extract(body map[string]interface{}) (SpanContext, bool) {
+	v, ok := body[Field]
+	if !ok {
+		return SpanContext{}, false
+	}
+	delete(body, Field)
+	s, _ := v.(string)
+	return Parse(s)
+}
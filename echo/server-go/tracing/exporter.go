@@ -0,0 +1,183 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	queueSize     = 8192
+	batchSize     = 512
+	flushInterval = time.Second
+)
+
+// Tracer writes finished spans to a file from a background goroutine.
+// Export never blocks; spans are dropped when the queue is full.
+type Tracer struct {
+	service string
+	file    *os.File
+	queue   chan *Span
+	dropped uint64
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Open appends spans of service to path.
+func Open(path, service string) (*Tracer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tracer{
+		service: service,
+		file:    f,
+		queue:   make(chan *Span, queueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go t.run()
+	return t, nil
+}
+
+func (t *Tracer) Export(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// Dropped returns how many spans were lost because the queue was full.
+func (t *Tracer) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// Close writes the queued spans and closes the file. Spans exported
+// after Close are dropped.
+func (t *Tracer) Close() error {
+	t.once.Do(func() { close(t.stop) })
+	<-t.done
+	return t.file.Close()
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	w := bufio.NewWriter(t.file)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			t.writeBatch(w, batch)
+			batch = batch[:0]
+		}
+		w.Flush()
+	}
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.stop:
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+					if len(batch) == batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// OTLP/JSON，字段名和编码方式见 opentelemetry-proto 的 JSON 映射：
+// ID 用十六进制，时间是字符串形式的纳秒
+type otlpAttr struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              Kind       `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+}
+
+func (t *Tracer) writeBatch(w *bufio.Writer, batch []*Span) {
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		o := otlpSpan{
+			TraceID:           s.Context.TraceID.String(),
+			SpanID:            s.Context.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if !s.Parent.IsZero() {
+			o.ParentSpanID = s.Parent.String()
+		}
+		for _, a := range s.Attrs {
+			o.Attributes = append(o.Attributes, otlpAttr{Key: a.Key, Value: attrValue(a.Value)})
+		}
+		spans[i] = o
+	}
+
+	doc := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttr{{Key: "service.name", Value: attrValue(t.service)}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "cyberbullfight/tracing"},
+				"spans": spans,
+			}},
+		}},
+	}
+	line, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	w.Write(line)
+	w.WriteByte('\n')
+}
+
+func attrValue(v interface{}) map[string]interface{} {
+	switch x := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": x}
+	case bool:
+		return map[string]interface{}{"boolValue": x}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(x)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+	case uint64:
+		return map[string]interface{}{"intValue": strconv.FormatUint(x, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": x}
+	}
+	b, _ := json.Marshal(v)
+	return map[string]interface{}{"stringValue": string(b)}
+}